@@ -14,4 +14,5 @@ Actions related to the calculation of mappings and similar entries.`,
 
 func init() {
 	cmd.RootCmd.AddCommand(calculateCmd)
+	cmd.UsesDatabase(calculateCmd, cmd.DatabaseReadWrite)
 }
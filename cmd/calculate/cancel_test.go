@@ -0,0 +1,50 @@
+package calculate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+	"go.uber.org/ratelimit"
+)
+
+func TestRunConcurrentProcessingCancelled(t *testing.T) {
+	data := &SimilarityData{
+		MangaList: []internal.Manga{{Id: "uuid-1"}, {Id: "uuid-2"}, {Id: "uuid-3"}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runConcurrentProcessing(ctx, data, processingConfig{threads: 2})
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers did not stop after cancellation")
+	}
+}
+
+func TestMangaUpdatesLookupCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := takeContext(ctx, ratelimit.New(1)); !errors.Is(err, context.Canceled) {
+		t.Errorf("takeContext: expected context.Canceled, got %v", err)
+	}
+
+	start := time.Now()
+	if err := sleepContext(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("sleepContext: expected context.Canceled, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("sleepContext did not return when cancelled")
+	}
+}
@@ -0,0 +1,231 @@
+package calculate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+// conflictsReportFile lists every external id linked from more than one manga.
+const conflictsReportFile = "data/mappings/conflicts.json"
+
+// variantTitle matches the titles of colored and official re-uploads of a manga, which
+// should never be picked over the original entry.
+var variantTitle = regexp.MustCompile(`(?i)\b(full[- ]?colou?r|colou?red|colou?r edition|digital colou?r|fan[- ]colou?r)\b|\(official\)|\bofficial (colou?r|translation|english)`)
+
+// mappingConflict is an external id which several MangaDex entries link to.
+type mappingConflict struct {
+	Site      string          `json:"site"`
+	Id        string          `json:"id"`
+	Canonical string          `json:"canonical"`
+	Entries   []conflictEntry `json:"entries"`
+}
+
+// conflictEntry is one of the manga linking to a conflicting id.
+type conflictEntry struct {
+	UUID      string `json:"uuid"`
+	Title     string `json:"title"`
+	CreatedAt string `json:"created_at"`
+	Variant   bool   `json:"variant,omitempty"`
+}
+
+// findMappingConflicts finds the external ids of a site linked from several manga, picks the
+// canonical manga of each and records them in the conflicts table, replacing the conflicts
+// found by an earlier run.
+func findMappingConflicts(site internal.MappingSite) ([]mappingConflict, error) {
+	rows, err := internal.DB.Query("SELECT ID, UUID FROM " + site.Table + " WHERE ID IN (SELECT ID FROM " + site.Table + " GROUP BY ID HAVING COUNT(*) > 1) ORDER BY ID, UUID")
+	if err != nil {
+		return nil, fmt.Errorf("query %s conflicts: %w", site.Name, err)
+	}
+	var conflicts []mappingConflict
+	for rows.Next() {
+		var id, uuid string
+		if err := rows.Scan(&id, &uuid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan %s conflicts: %w", site.Name, err)
+		}
+		if len(conflicts) == 0 || conflicts[len(conflicts)-1].Id != id {
+			conflicts = append(conflicts, mappingConflict{Site: site.Key, Id: id})
+		}
+		last := &conflicts[len(conflicts)-1]
+		last.Entries = append(last.Entries, conflictEntry{UUID: uuid})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s conflicts: %w", site.Name, err)
+	}
+
+	for i := range conflicts {
+		for j := range conflicts[i].Entries {
+			if err := loadConflictEntry(&conflicts[i].Entries[j]); err != nil {
+				return nil, err
+			}
+		}
+		conflicts[i].Canonical = chooseCanonical(conflicts[i].Entries)
+	}
+	return conflicts, saveMappingConflicts(site, conflicts)
+}
+
+// loadConflictEntry fills in the title and the date the manga was created on MangaDex.
+func loadConflictEntry(entry *conflictEntry) error {
+	var jsonManga []byte
+	err := internal.DB.QueryRow("SELECT JSON FROM "+internal.TableManga+" WHERE UUID = ?", entry.UUID).Scan(&jsonManga)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("query manga %s: %w", entry.UUID, err)
+	}
+	var manga internal.Manga
+	if err := json.Unmarshal(jsonManga, &manga); err != nil {
+		return fmt.Errorf("decode manga %s: %w", entry.UUID, err)
+	}
+	entry.CreatedAt = manga.CreatedAt
+	if manga.Title != nil {
+		titles := *manga.Title
+		entry.Title = titles["en"]
+		// Without an english title the first by language code, so reports are stable
+		for _, lang := range slices.Sorted(maps.Keys(titles)) {
+			if entry.Title == "" {
+				entry.Title = titles[lang]
+			}
+			entry.Variant = entry.Variant || variantTitle.MatchString(titles[lang])
+		}
+	}
+	return nil
+}
+
+// chooseCanonical picks the manga an external id should map to: an original entry over a
+// colored or official variant, then the entry created first on MangaDex, then the lowest uuid.
+func chooseCanonical(entries []conflictEntry) string {
+	sorted := append([]conflictEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Variant != b.Variant {
+			return !a.Variant
+		}
+		// Entries missing from the manga table, or stored before their creation date was, go last
+		if (a.CreatedAt == "") != (b.CreatedAt == "") {
+			return a.CreatedAt != ""
+		}
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+		return a.UUID < b.UUID
+	})
+	return sorted[0].UUID
+}
+
+func saveMappingConflicts(site internal.MappingSite, conflicts []mappingConflict) error {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin %s conflicts: %w", site.Name, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM "+internal.TableMappingConflicts+" WHERE SITE = ?", site.Key); err != nil {
+		return fmt.Errorf("clear %s conflicts: %w", site.Name, err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO " + internal.TableMappingConflicts + " (SITE, ID, UUID, CANONICAL) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("prepare %s conflicts: %w", site.Name, err)
+	}
+	defer stmt.Close()
+	for _, conflict := range conflicts {
+		for _, entry := range conflict.Entries {
+			if _, err := stmt.Exec(site.Key, conflict.Id, entry.UUID, entry.UUID == conflict.Canonical); err != nil {
+				return fmt.Errorf("insert %s conflict %s: %w", site.Name, conflict.Id, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit %s conflicts: %w", site.Name, err)
+	}
+	return nil
+}
+
+// getNonCanonicalMappings returns the id and uuid pairs of a site which lost a conflict, keyed
+// by the id followed by the uuid.
+func getNonCanonicalMappings(siteKey string) (map[string]bool, error) {
+	rows, err := internal.DB.Query("SELECT ID, UUID FROM "+internal.TableMappingConflicts+" WHERE SITE = ? AND CANONICAL = 0", siteKey)
+	if err != nil {
+		return nil, fmt.Errorf("query %s conflicts: %w", siteKey, err)
+	}
+	defer rows.Close()
+	nonCanonical := make(map[string]bool)
+	for rows.Next() {
+		var id, uuid string
+		if err := rows.Scan(&id, &uuid); err != nil {
+			return nil, fmt.Errorf("scan %s conflicts: %w", siteKey, err)
+		}
+		nonCanonical[id+" "+uuid] = true
+	}
+	return nonCanonical, rows.Err()
+}
+
+// reportMappingConflicts writes the conflicts report for moderators to review.
+func reportMappingConflicts() error {
+	count, err := writeConflictsReport()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d external ids linked from more than one manga to %s\n", count, conflictsReportFile)
+	return nil
+}
+
+// writeConflictsReport writes every recorded conflict, ordered by site and id, and returns
+// how many there are.
+func writeConflictsReport() (int, error) {
+	rows, err := internal.DB.Query("SELECT SITE, ID, UUID, CANONICAL FROM " + internal.TableMappingConflicts + " ORDER BY SITE, ID, UUID")
+	if err != nil {
+		return 0, fmt.Errorf("query conflicts: %w", err)
+	}
+	conflicts := []mappingConflict{}
+	for rows.Next() {
+		var site, id, uuid string
+		var canonical bool
+		if err := rows.Scan(&site, &id, &uuid, &canonical); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan conflicts: %w", err)
+		}
+		if len(conflicts) == 0 || conflicts[len(conflicts)-1].Site != site || conflicts[len(conflicts)-1].Id != id {
+			conflicts = append(conflicts, mappingConflict{Site: site, Id: id})
+		}
+		last := &conflicts[len(conflicts)-1]
+		last.Entries = append(last.Entries, conflictEntry{UUID: uuid})
+		if canonical {
+			last.Canonical = uuid
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate conflicts: %w", err)
+	}
+
+	for i := range conflicts {
+		for j := range conflicts[i].Entries {
+			if err := loadConflictEntry(&conflicts[i].Entries[j]); err != nil {
+				return 0, err
+			}
+		}
+	}
+	data, err := json.MarshalIndent(conflicts, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("encode conflicts report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(conflictsReportFile), 0755); err != nil {
+		return 0, fmt.Errorf("create conflicts report dir: %w", err)
+	}
+	if err := os.WriteFile(conflictsReportFile, append(data, '\n'), 0644); err != nil {
+		return 0, fmt.Errorf("write conflicts report: %w", err)
+	}
+	return len(conflicts), nil
+}
@@ -3,117 +3,195 @@ package calculate
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"github.com/similar-manga/similar/internal"
-	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 )
 
-func DeleteSimilarDB() {
-	_, err := internal.DB.Exec("DELETE FROM " + internal.TableSimilar)
-	internal.CheckErr(err)
+func DeleteSimilarDB() error {
+	if _, err := internal.DB.Exec("DELETE FROM " + internal.TableSimilar); err != nil {
+		return fmt.Errorf("clear similar: %w", err)
+	}
+	return nil
 }
 
 var (
 	similarInsertStmt *sql.Stmt
+	similarInsertErr  error
 	similarInsertOnce sync.Once
 )
 
 // initSimilarInsertStmt initializes the prepared statement for InsertSimilarData.
 // It is exposed for testing purposes to allow resetting the global state.
 func initSimilarInsertStmt() {
-	var err error
-	similarInsertStmt, err = internal.DB.Prepare("INSERT INTO " + internal.TableSimilar + " (UUID, JSON) VALUES (?, ?)")
-	internal.CheckErr(err)
+	similarInsertStmt, similarInsertErr = internal.DB.Prepare("INSERT INTO " + internal.TableSimilar + " (UUID, JSON) VALUES (?, ?)")
+	if similarInsertErr != nil {
+		similarInsertErr = fmt.Errorf("prepare similar insert: %w", similarInsertErr)
+	}
 }
 
 // resetSimilarInsertStmt closes the prepared statement and resets the once flag.
 // This is strictly used for testing and benching.
 func resetSimilarInsertStmt() {
 	similarInsertOnce = sync.Once{}
+	similarInsertErr = nil
 	if similarInsertStmt != nil {
 		similarInsertStmt.Close()
 		similarInsertStmt = nil
 	}
 }
 
-func InsertSimilarData(similarData internal.SimilarManga) {
+func InsertSimilarData(similarData internal.SimilarManga) error {
 	similarInsertOnce.Do(initSimilarInsertStmt)
+	if similarInsertErr != nil {
+		return similarInsertErr
+	}
 
 	jsonSimilar, err := json.Marshal(similarData)
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("marshal similar %s: %w", similarData.Id, err)
+	}
+
+	if _, err = similarInsertStmt.Exec(similarData.Id, jsonSimilar); err != nil {
+		return fmt.Errorf("insert similar %s: %w", similarData.Id, err)
+	}
+	return nil
+}
+
+func getDBSimilar() ([]internal.DbSimilar, error) {
+	return getDBSimilarFromTable(internal.TableSimilar)
+}
 
-	_, err = similarInsertStmt.Exec(similarData.Id, jsonSimilar)
-	internal.CheckErr(err)
+func getDBSimilarInbound() ([]internal.DbSimilar, error) {
+	return getDBSimilarFromTable(internal.TableSimilarInbound)
 }
 
-func getDBSimilar() []internal.DbSimilar {
-	rows, err := internal.DB.Query("SELECT UUID, JSON FROM SIMILAR ORDER BY UUID ASC")
-	internal.CheckErr(err)
+func getDBSimilarFromTable(tableName string) ([]internal.DbSimilar, error) {
+	rows, err := internal.DB.Query("SELECT UUID, JSON FROM " + tableName + " ORDER BY UUID ASC")
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", tableName, err)
+	}
 	defer rows.Close()
 
 	var similarList []internal.DbSimilar
 	for rows.Next() {
 		similar := internal.DbSimilar{}
-		err = rows.Scan(&similar.Id, &similar.JSON)
-		internal.CheckErr(err)
+		if err := rows.Scan(&similar.Id, &similar.JSON); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", tableName, err)
+		}
 		similarList = append(similarList, similar)
 	}
-	internal.CheckErr(rows.Err())
-	return similarList
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", tableName, err)
+	}
+	return similarList, nil
 }
 
-func WriteLineToDebugFile(fileName string, line string) {
-	os.MkdirAll("debug", 0700)
+func WriteLineToDebugFile(fileName string, line string) error {
+	if err := os.MkdirAll("debug", 0700); err != nil {
+		return fmt.Errorf("create debug dir: %w", err)
+	}
 	file, err := os.OpenFile(filepath.Join("debug", filepath.Base(fileName)+".txt"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	internal.CheckErr(err)
-	_, err = file.WriteString(line + "\n")
-	internal.CheckErr(err)
-	file.Close()
+	if err != nil {
+		return fmt.Errorf("open debug file: %w", err)
+	}
+	if _, err = file.WriteString(line + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("write debug file: %w", err)
+	}
+	return file.Close()
 }
 
-func exportMapping(tableName string, fileName string) {
-	genericList := getAllGenericFromTable(tableName)
-	exportGeneric(fileName, genericList)
+// exportMapping resolves the conflicts of a mapping table then exports it, marking the
+// mappings which lost a conflict as duplicates. The tables must have been migrated already.
+func exportMapping(tableName string, fileName string) error {
+	site, err := mappingSiteByTable(tableName)
+	if err != nil {
+		return err
+	}
+	conflicts, err := findMappingConflicts(site)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		fmt.Printf("Found %d %s ids linked from more than one manga\n", len(conflicts), site.Name)
+	}
+	nonCanonical, err := getNonCanonicalMappings(site.Key)
+	if err != nil {
+		return err
+	}
+
+	genericList, err := getAllGenericFromTable(tableName)
+	if err != nil {
+		return err
+	}
+	return exportGeneric(fileName, genericList, nonCanonical)
 }
 
-func exportGeneric(fileName string, genericList []internal.DbGeneric) {
-	file, err := os.Create("data/mappings/" + fileName + ".txt")
-	internal.CheckErr(err)
+// exportGeneric writes one `id:::||@!@||:::uuid` line per mapping. Mappings in nonCanonical,
+// keyed by the id followed by the uuid, get a third `duplicate` field.
+func exportGeneric(fileName string, genericList []internal.DbGeneric, nonCanonical map[string]bool) error {
+	file, err := CreateMappingsFile(fileName)
+	if err != nil {
+		return err
+	}
 	for _, entry := range genericList {
-		file.WriteString(entry.ID + ":::||@!@||:::" + entry.UUID + "\n")
+		line := entry.ID + ":::||@!@||:::" + entry.UUID
+		if nonCanonical[entry.ID+" "+entry.UUID] {
+			line += ":::||@!@||:::duplicate"
+		}
+		if _, err := file.WriteString(line + "\n"); err != nil {
+			file.Close()
+			return fmt.Errorf("write %s mappings: %w", fileName, err)
+		}
+	}
+	return file.Close()
+}
+
+// mappingSiteByTable returns the mapping site stored in a table.
+func mappingSiteByTable(tableName string) (internal.MappingSite, error) {
+	index := slices.IndexFunc(internal.MappingSites, func(site internal.MappingSite) bool { return site.Table == tableName })
+	if index < 0 {
+		return internal.MappingSite{}, fmt.Errorf("invalid mapping table name %s", tableName)
 	}
-	file.Close()
+	return internal.MappingSites[index], nil
 }
 
-func getAllGenericFromTable(tableName string) []internal.DbGeneric {
-	switch tableName {
-	case internal.TableAnilist, internal.TableAnimePlanet, internal.TableBookWalker, internal.TableKitsu, internal.TableMyanimelist, internal.TableMangaupdates, internal.TableMangaupdatesNewId, internal.TableNovelUpdates:
-		// OK
-	default:
-		log.Fatalf("getAllGenericFromTable: invalid table name %s", tableName)
+func getAllGenericFromTable(tableName string) ([]internal.DbGeneric, error) {
+	if !slices.ContainsFunc(internal.MappingSites, func(site internal.MappingSite) bool { return site.Table == tableName }) {
+		return nil, fmt.Errorf("getAllGenericFromTable: invalid table name %s", tableName)
 	}
 
 	rows, err := internal.DB.Query("SELECT UUID, ID FROM " + tableName + " ORDER BY UUID asc ")
-	internal.CheckErr(err)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", tableName, err)
+	}
 	defer rows.Close()
 
 	var genericList []internal.DbGeneric
 	for rows.Next() {
 		generic := internal.DbGeneric{}
-		err = rows.Scan(&generic.UUID, &generic.ID)
-		internal.CheckErr(err)
+		if err := rows.Scan(&generic.UUID, &generic.ID); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", tableName, err)
+		}
 		genericList = append(genericList, generic)
 	}
-	internal.CheckErr(rows.Err())
-	return genericList
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", tableName, err)
+	}
+	return genericList, nil
 }
 
-func CreateMappingsFile(fileName string) *os.File {
+func CreateMappingsFile(fileName string) (*os.File, error) {
+	if err := os.MkdirAll("data/mappings", 0755); err != nil {
+		return nil, fmt.Errorf("create mappings dir: %w", err)
+	}
 	file, err := os.Create("data/mappings/" + fileName + ".txt")
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("create %s mappings: %w", fileName, err)
 	}
-	return file
+	return file, nil
 }
@@ -0,0 +1,108 @@
+package calculate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+// calculateInbound inverts the SIMILAR table so that for each manga we know which seeds
+// recommend it and at which rank. The result replaces the SIMILAR_INBOUND table.
+func calculateInbound() error {
+	similarList, err := getDBSimilar()
+	if err != nil {
+		return err
+	}
+	inbound := buildInboundIndex(similarList)
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin inbound: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM " + internal.TableSimilarInbound); err != nil {
+		return fmt.Errorf("clear inbound: %w", err)
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO " + internal.TableSimilarInbound + " (UUID, JSON) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("prepare inbound insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, entry := range inbound {
+		jsonInbound, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("marshal inbound %s: %w", entry.Id, err)
+		}
+		if _, err = stmt.Exec(entry.Id, jsonInbound); err != nil {
+			return fmt.Errorf("insert inbound %s: %w", entry.Id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit inbound: %w", err)
+	}
+	return nil
+}
+
+// buildInboundIndex returns one entry per recommended manga, ordered by UUID, with the
+// seeds ordered by the rank they give it (best first) and then by score.
+func buildInboundIndex(similarList []internal.DbSimilar) []internal.InboundManga {
+	byTarget := make(map[string][]internal.InboundMatch)
+	for _, row := range similarList {
+		var sim internal.SimilarManga
+		if err := json.Unmarshal([]byte(row.JSON), &sim); err != nil {
+			log.Printf("Warning: failed to unmarshal similar entry %s: %v", row.Id, err)
+			continue
+		}
+		for rank, match := range sim.SimilarMatches {
+			byTarget[match.Id] = append(byTarget[match.Id], internal.InboundMatch{
+				Id:    row.Id,
+				Title: sim.Title,
+				Rank:  rank + 1,
+				Score: match.Score,
+			})
+		}
+	}
+
+	inbound := make([]internal.InboundManga, 0, len(byTarget))
+	for id, matches := range byTarget {
+		sort.Slice(matches, func(i, j int) bool {
+			if matches[i].Rank != matches[j].Rank {
+				return matches[i].Rank < matches[j].Rank
+			}
+			if matches[i].Score != matches[j].Score {
+				return matches[i].Score > matches[j].Score
+			}
+			return matches[i].Id < matches[j].Id
+		})
+		inbound = append(inbound, internal.InboundManga{Id: id, Inbound: matches})
+	}
+	sort.Slice(inbound, func(i, j int) bool { return inbound[i].Id < inbound[j].Id })
+	return inbound
+}
+
+// inboundUpdatedFile records when the inbound shards were last exported. It is kept out of
+// the shards so a run which changes no recommendation leaves them untouched.
+const inboundUpdatedFile = "data/similar_inbound/updated_at.txt"
+
+// exportInbound writes the inbound shards, and now as the time they were exported.
+func exportInbound(now time.Time) error {
+	inboundList, err := getDBSimilarInbound()
+	if err != nil {
+		return err
+	}
+	if err := exportSharded("data/similar_inbound/", inboundList); err != nil {
+		return err
+	}
+	if err := os.WriteFile(inboundUpdatedFile, []byte(now.UTC().Format(time.RFC3339)), 0644); err != nil {
+		return fmt.Errorf("write %s: %w", inboundUpdatedFile, err)
+	}
+	return nil
+}
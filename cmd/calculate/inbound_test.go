@@ -0,0 +1,91 @@
+package calculate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+func TestBuildInboundIndex(t *testing.T) {
+	seedA, _ := json.Marshal(internal.SimilarManga{
+		Id:    "aaa-1",
+		Title: map[string]string{"en": "Seed A"},
+		SimilarMatches: []internal.SimilarMatch{
+			{Id: "ccc-1", Score: 0.9},
+			{Id: "bbb-1", Score: 0.5},
+		},
+	})
+	seedB, _ := json.Marshal(internal.SimilarManga{
+		Id:    "ddd-1",
+		Title: map[string]string{"en": "Seed B"},
+		SimilarMatches: []internal.SimilarMatch{
+			{Id: "bbb-1", Score: 0.8},
+		},
+	})
+
+	inbound := buildInboundIndex([]internal.DbSimilar{
+		{Id: "aaa-1", JSON: string(seedA)},
+		{Id: "ddd-1", JSON: string(seedB)},
+		{Id: "eee-1", JSON: "not json"},
+	})
+
+	if len(inbound) != 2 {
+		t.Fatalf("expected 2 inbound entries, got %d", len(inbound))
+	}
+	if inbound[0].Id != "bbb-1" || inbound[1].Id != "ccc-1" {
+		t.Fatalf("expected entries ordered by UUID, got %s, %s", inbound[0].Id, inbound[1].Id)
+	}
+
+	bbb := inbound[0].Inbound
+	if len(bbb) != 2 {
+		t.Fatalf("expected bbb-1 to be recommended from 2 seeds, got %d", len(bbb))
+	}
+	if bbb[0].Id != "ddd-1" || bbb[0].Rank != 1 {
+		t.Errorf("expected first seed ddd-1 at rank 1, got %s at rank %d", bbb[0].Id, bbb[0].Rank)
+	}
+	if bbb[1].Id != "aaa-1" || bbb[1].Rank != 2 {
+		t.Errorf("expected second seed aaa-1 at rank 2, got %s at rank %d", bbb[1].Id, bbb[1].Rank)
+	}
+	if bbb[1].Title["en"] != "Seed A" {
+		t.Errorf("expected seed title to be carried over, got %v", bbb[1].Title)
+	}
+}
+
+func TestExportInboundIsStableAcrossRuns(t *testing.T) {
+	setupMappingsTest(t)
+	seed, _ := json.Marshal(internal.SimilarManga{
+		Id:             "aaa-1",
+		Title:          map[string]string{"en": "Seed A"},
+		SimilarMatches: []internal.SimilarMatch{{Id: "bbb-1", Score: 0.9}},
+	})
+	if _, err := internal.DB.Exec("INSERT INTO "+internal.TableSimilar+" (UUID, JSON) VALUES (?, ?)", "aaa-1", seed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Runs at different times still write the same shard, only the index file changes
+	var shards []string
+	for _, now := range []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)} {
+		if err := calculateInbound(); err != nil {
+			t.Fatal(err)
+		}
+		if err := exportInbound(now); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join("data", "similar_inbound", "bb", "bbb.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, string(data))
+	}
+	if shards[0] != shards[1] || strings.Contains(shards[0], "updatedAt") {
+		t.Errorf("expected identical shards without a timestamp, got %q and %q", shards[0], shards[1])
+	}
+	if updated, err := os.ReadFile(inboundUpdatedFile); err != nil || string(updated) != "2024-01-02T00:00:00Z" {
+		t.Errorf("expected the last export time in %s, got %q: %v", inboundUpdatedFile, updated, err)
+	}
+}
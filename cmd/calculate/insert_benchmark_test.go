@@ -38,11 +38,14 @@ func BenchmarkInsertSimilarData(b *testing.B) {
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
 			id := atomic.AddUint64(&counter, 1)
-			InsertSimilarData(internal.SimilarManga{
+			err := InsertSimilarData(internal.SimilarManga{
 				Id:        fmt.Sprintf("uuid-%d", id),
 				Title:     map[string]string{"en": "Test"},
 				UpdatedAt: now,
 			})
+			if err != nil {
+				b.Error(err)
+			}
 		}
 	})
 }
@@ -0,0 +1,79 @@
+package calculate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+// KitsuBaseUrl is the Kitsu api slugs are resolved against, overridden by --kitsu-url.
+const KitsuBaseUrl = "https://kitsu.io/api/edge"
+
+func init() {
+	registerResolver(newKitsuResolver)
+}
+
+// kitsuResolver resolves Kitsu links, stored as either numeric ids or slugs, to numeric ids.
+type kitsuResolver struct {
+	client  *resolverClient
+	baseUrl string
+}
+
+// newKitsuResolver allows five requests a second to the Kitsu api.
+func newKitsuResolver(opts resolverOptions) Resolver {
+	opts.client.setRate(5, hostOf(opts.kitsuUrl))
+	return &kitsuResolver{client: opts.client, baseUrl: opts.kitsuUrl}
+}
+
+func (r *kitsuResolver) Site() internal.MappingSite {
+	site, _ := internal.MappingSiteByKey("kt_id")
+	return site
+}
+
+func (r *kitsuResolver) LinkKey() string { return "kt" }
+
+func (r *kitsuResolver) FileName() string { return "kitsu_resolved2mdex" }
+
+// Resolve returns numeric links as they are and looks slugs up through the Kitsu api.
+func (r *kitsuResolver) Resolve(ctx context.Context, uuid string, link string) (string, error) {
+	slug, err := normalizeKitsuId(link)
+	if err != nil {
+		return "", &resolveError{outcome: outcomeNotFound, err: err}
+	}
+	if numericId.MatchString(slug) {
+		return slug, nil
+	}
+
+	resp, err := r.client.get(ctx, r.baseUrl+"/manga?fields[manga]=slug&filter[slug]="+url.QueryEscape(slug), nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", resolveFailure(outcomeNetworkError, "get kitsu slug %s: %w", slug, err)
+	}
+	defer drainAndClose(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", resolveFailure(outcomeForStatus(resp.StatusCode), "kitsu slug %s: unexpected http code %d", slug, resp.StatusCode)
+	}
+
+	var body struct {
+		Data []struct {
+			Id         string `json:"id"`
+			Attributes struct {
+				Slug string `json:"slug"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", resolveFailure(outcomeNetworkError, "decode kitsu response: %w", err)
+	}
+	for _, manga := range body.Data {
+		if manga.Attributes.Slug == slug && numericId.MatchString(manga.Id) {
+			return manga.Id, nil
+		}
+	}
+	return "", resolveFailure(outcomeNotFound, "kitsu slug %s not found", slug)
+}
@@ -0,0 +1,67 @@
+package calculate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+func TestRunMappingsResolvesKitsuSlugs(t *testing.T) {
+	setupMappingsTest(t,
+		internal.Manga{Id: "uuid-1", Links: map[string]string{"kt": "38"}},
+		internal.Manga{Id: "uuid-2", Links: map[string]string{"kt": "https://kitsu.app/manga/naruto"}},
+		internal.Manga{Id: "uuid-3", Links: map[string]string{"kt": "missing-slug"}},
+		internal.Manga{Id: "uuid-4", Links: map[string]string{"kt": "bleach"}},
+	)
+	// Resolved by an earlier run
+	if err := upsertResolvedId(internal.TableKitsuResolved, "uuid-4", "3", internal.NewMappingRun("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var slugs []string
+	ids := map[string]string{"naruto": "11", "bleach": "3"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug := r.URL.Query().Get("filter[slug]")
+		mu.Lock()
+		slugs = append(slugs, slug)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		if id, ok := ids[slug]; ok {
+			w.Write([]byte(`{"data":[{"id":"` + id + `","type":"manga","attributes":{"slug":"` + slug + `"}}]}`))
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer server.Close()
+
+	if err := mappingsCmd.ParseFlags([]string{"--kitsu-url", server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mappingsCmd.Flags().Set("kitsu-url", KitsuBaseUrl) })
+	mappingsCmd.SetContext(context.Background())
+	if err := runMappings(mappingsCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(slugs, ",") != "missing-slug,naruto" && strings.Join(slugs, ",") != "naruto,missing-slug" {
+		t.Errorf("expected only the unresolved slugs to be looked up, got %v", slugs)
+	}
+	want := []string{
+		"38:::||@!@||:::uuid-1",
+		"11:::||@!@||:::uuid-2",
+		"3:::||@!@||:::uuid-4",
+	}
+	if got := readMappingFile(t, "kitsu_resolved2mdex"); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("unexpected resolved kitsu mappings %v", got)
+	}
+	// The slugs are still exported as they are
+	if got := readMappingFile(t, "kitsu2mdex"); len(got) != 4 {
+		t.Errorf("expected every kitsu link in kitsu2mdex, got %v", got)
+	}
+}
@@ -0,0 +1,178 @@
+package calculate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+var (
+	numericId = regexp.MustCompile(`^[0-9]+$`)
+	// slugId allows the trailing and doubled dashes AnimePlanet and NovelUpdates keep in
+	// slugs made from titles ending in punctuation
+	slugId = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	// bookWalkerDeId is the id of a single BookWalker title, de followed by a uuid
+	bookWalkerDeId = regexp.MustCompile(`^de[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// errInvalidLinkId is returned for link values which cannot be fixed up into an id.
+var errInvalidLinkId = errors.New("invalid link id")
+
+// normalizeNumericId returns a numeric id, such as AniList's, out of a bare id, an id with a
+// trailing slash or title, or a full url where the id follows the given path segment.
+func normalizeNumericId(segment string) func(string) (string, error) {
+	return func(value string) (string, error) {
+		id := extractLinkId(value, segment)
+		if !numericId.MatchString(id) {
+			return "", fmt.Errorf("%w: %q is not numeric", errInvalidLinkId, value)
+		}
+		return id, nil
+	}
+}
+
+// normalizeSlugId returns a lower case slug, such as AnimePlanet's, out of a bare slug or a
+// full url where the slug follows the given path segment.
+func normalizeSlugId(segment string) func(string) (string, error) {
+	return func(value string) (string, error) {
+		id := strings.ToLower(extractLinkId(value, segment))
+		if !slugId.MatchString(id) {
+			return "", fmt.Errorf("%w: %q is not a slug", errInvalidLinkId, value)
+		}
+		return id, nil
+	}
+}
+
+// normalizeKitsuId accepts either of the numeric ids or slugs Kitsu links are stored as.
+func normalizeKitsuId(value string) (string, error) {
+	id := extractLinkId(value, "manga")
+	if numericId.MatchString(id) {
+		return id, nil
+	}
+	if id = strings.ToLower(id); slugId.MatchString(id) {
+		return id, nil
+	}
+	return "", fmt.Errorf("%w: %q is neither an id nor a slug", errInvalidLinkId, value)
+}
+
+// normalizeBookWalkerId returns either of the forms BookWalker links are stored as, a
+// series/{id} or a de{uuid} title id, dropping the url and anything after the id.
+func normalizeBookWalkerId(value string) (string, error) {
+	id := strings.ToLower(extractLinkId(value, "series"))
+	if bookWalkerDeId.MatchString(id) {
+		return id, nil
+	}
+	if numericId.MatchString(id) {
+		return "series/" + id, nil
+	}
+	return "", fmt.Errorf("%w: %q is neither a series/{id} nor a de{uuid}", errInvalidLinkId, value)
+}
+
+// extractLinkId finds the id in a link value. Full urls and values like "123/title" are
+// reduced to the path segment following segment, or the first one.
+func extractLinkId(value string, segment string) string {
+	value = strings.TrimSpace(value)
+	if looksLikeURL(value) {
+		raw := value
+		if !strings.Contains(raw, "://") {
+			raw = "https://" + raw
+		}
+		if u, err := url.Parse(raw); err == nil {
+			value = u.Path
+		}
+	}
+	parts := strings.Split(strings.Trim(value, "/"), "/")
+	for i, part := range parts {
+		if part == segment && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+	return parts[0]
+}
+
+// looksLikeURL reports whether a value starts with a scheme or a host name.
+func looksLikeURL(value string) bool {
+	if strings.Contains(value, "://") {
+		return true
+	}
+	host, _, _ := strings.Cut(value, "/")
+	return strings.Contains(host, ".")
+}
+
+// normalizeNewMuId checks a resolved MangaUpdates id, the api only knows numeric ones.
+var normalizeNewMuId = normalizeNumericId("series")
+
+// linkIssuesReportFile lists the link values rejected or fixed up by the last mappings run.
+const linkIssuesReportFile = "data/mappings/link_issues.json"
+
+// linkIssue is a link value which was rejected, or fixed up before being mapped.
+type linkIssue struct {
+	Site  string `json:"site"`
+	UUID  string `json:"uuid"`
+	Value string `json:"value"`
+	Fixed string `json:"fixed,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// reportLinkIssues replaces the recorded issues with those of this run, writes them to the
+// report and prints how many there are per site.
+func reportLinkIssues(issues []linkIssue) error {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin link issues: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM " + internal.TableLinkIssues); err != nil {
+		return fmt.Errorf("clear link issues: %w", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO " + internal.TableLinkIssues + " (SITE, UUID, VALUE, FIXED, ERROR) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("prepare link issues: %w", err)
+	}
+	defer stmt.Close()
+
+	report := struct {
+		Rejected []linkIssue `json:"rejected"`
+		Fixed    []linkIssue `json:"fixed"`
+	}{[]linkIssue{}, []linkIssue{}}
+	rejected := make(map[string]int)
+	fixed := make(map[string]int)
+	for _, issue := range issues {
+		if _, err := stmt.Exec(issue.Site, issue.UUID, issue.Value, issue.Fixed, issue.Error); err != nil {
+			return fmt.Errorf("insert link issue %s %s: %w", issue.Site, issue.UUID, err)
+		}
+		if issue.Error != "" {
+			report.Rejected = append(report.Rejected, issue)
+			rejected[issue.Site]++
+		} else {
+			report.Fixed = append(report.Fixed, issue)
+			fixed[issue.Site]++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit link issues: %w", err)
+	}
+
+	for _, site := range internal.MappingSites {
+		if rejected[site.Key] > 0 || fixed[site.Key] > 0 {
+			fmt.Printf("%s links: %d rejected, %d fixed\n", site.Name, rejected[site.Key], fixed[site.Key])
+		}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode link issues report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(linkIssuesReportFile), 0755); err != nil {
+		return fmt.Errorf("create link issues report dir: %w", err)
+	}
+	if err := os.WriteFile(linkIssuesReportFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("write link issues report: %w", err)
+	}
+	return nil
+}
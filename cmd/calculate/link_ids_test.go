@@ -0,0 +1,71 @@
+package calculate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeLinkIds(t *testing.T) {
+	tests := []struct {
+		site      string
+		normalize func(string) (string, error)
+		value     string
+		want      string
+	}{
+		{"al", normalizeNumericId("manga"), "30013", "30013"},
+		{"al", normalizeNumericId("manga"), " 30013/ ", "30013"},
+		{"al", normalizeNumericId("manga"), "https://anilist.co/manga/30013/One-Piece/", "30013"},
+		{"al", normalizeNumericId("manga"), "anilist.co/manga/30013", "30013"},
+		{"mal", normalizeNumericId("manga"), "https://myanimelist.net/manga/13/One_Piece", "13"},
+		{"mal", normalizeNumericId("manga"), "13/One_Piece", "13"},
+		{"mu_new", normalizeNewMuId, "55099564912", "55099564912"},
+		{"ap", normalizeSlugId("manga"), "One-Piece", "one-piece"},
+		{"ap", normalizeSlugId("manga"), "https://www.anime-planet.com/manga/one-piece/", "one-piece"},
+		{"nu", normalizeSlugId("series"), "https://www.novelupdates.com/series/omniscient-readers-viewpoint/", "omniscient-readers-viewpoint"},
+		{"kt", normalizeKitsuId, "38", "38"},
+		{"kt", normalizeKitsuId, "https://kitsu.app/manga/one-piece", "one-piece"},
+		{"bw", normalizeBookWalkerId, "series/12345", "series/12345"},
+		{"bw", normalizeBookWalkerId, "12345", "series/12345"},
+		{"bw", normalizeBookWalkerId, "https://bookwalker.jp/series/12345/list/", "series/12345"},
+		// Values from the manga dump
+		{"ap", normalizeSlugId("manga"), "valkyrie-drive-mermaid-", "valkyrie-drive-mermaid-"},
+		{"ap", normalizeSlugId("manga"), "ghost-teller ", "ghost-teller"},
+		{"nu", normalizeSlugId("series"), "listen-to-my-ladys-story-", "listen-to-my-ladys-story-"},
+		{"nu", normalizeSlugId("series"), "the-inverted-dragons-scale/?grr=1", "the-inverted-dragons-scale"},
+		{"kt", normalizeKitsuId, "sonic-x-shadow--generations", "sonic-x-shadow--generations"},
+		{"bw", normalizeBookWalkerId, "series/123456/list/?srsltid=AfmBOopkBe", "series/123456"},
+		{"bw", normalizeBookWalkerId, "de7c66b233-81f4-4e15-9265-24e94ff5c3be", "de7c66b233-81f4-4e15-9265-24e94ff5c3be"},
+		{"bw", normalizeBookWalkerId, "de1be58bea-d879-43cc-b17e-09943170ea10/?adpcnt=GDPL5fFf", "de1be58bea-d879-43cc-b17e-09943170ea10"},
+		{"bw", normalizeBookWalkerId, "https://bookwalker.jp/de38c24781-3eef-4d5f-b4fb-1727cdf2ec81", "de38c24781-3eef-4d5f-b4fb-1727cdf2ec81"},
+	}
+	for _, tt := range tests {
+		got, err := tt.normalize(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("%s %q = %q, %v, want %q", tt.site, tt.value, got, err, tt.want)
+		}
+	}
+
+	invalid := []struct {
+		site      string
+		normalize func(string) (string, error)
+		value     string
+	}{
+		{"al", normalizeNumericId("manga"), "one-piece"},
+		{"mal", normalizeNumericId("manga"), "https://myanimelist.net/anime/21"},
+		{"mu_new", normalizeNewMuId, "abc1234"},
+		{"ap", normalizeSlugId("manga"), "one piece!"},
+		{"nu", normalizeSlugId("series"), ""},
+		{"kt", normalizeKitsuId, "one_piece?"},
+		{"bw", normalizeBookWalkerId, "de4a1b2c3d-1234"},
+		// Values from the manga dump which are not an id at all
+		{"ap", normalizeSlugId("manga"), "hyakuoku nengo no kimi no koe mo"},
+		{"nu", normalizeSlugId("series"), "__trashed-214"},
+		{"bw", normalizeBookWalkerId, "author/12345"},
+		{"bw", normalizeBookWalkerId, "top/?pid=FcV8y7"},
+	}
+	for _, tt := range invalid {
+		if got, err := tt.normalize(tt.value); !errors.Is(err, errInvalidLinkId) {
+			t.Errorf("%s %q = %q, %v, want errInvalidLinkId", tt.site, tt.value, got, err)
+		}
+	}
+}
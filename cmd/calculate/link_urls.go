@@ -0,0 +1,118 @@
+package calculate
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// trackingParams are query parameters which only identify where a link was shared from.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "igshid": true, "mc_cid": true, "mc_eid": true,
+	"spm": true, "affiliate_id": true,
+}
+
+// amazonTrackingParams are the referral and search parameters Amazon adds to product links,
+// some of them are meaningful on other sites so they are only removed from Amazon urls.
+var amazonTrackingParams = map[string]bool{
+	"ref": true, "ref_": true, "tag": true, "linkcode": true, "linkid": true, "camp": true, "creative": true,
+	"creativeasin": true, "ascsubtag": true, "psc": true, "qid": true, "sr": true, "crid": true, "sprefix": true,
+	"keywords": true, "dib": true, "dib_tag": true, "th": true, "_encoding": true, "ie": true, "content-id": true,
+}
+
+// trackingPrefixes are query parameter prefixes used only for tracking, such as utm_source.
+var trackingPrefixes = []string{"utm_", "pd_rd_", "pf_rd_"}
+
+// amazonProduct finds the product id in the many forms of Amazon product url.
+var amazonProduct = regexp.MustCompile(`/(?:dp|gp/product|gp/aw/d|exec/obidos/ASIN)/([A-Za-z0-9]{10})(?:[/?]|$)`)
+
+// normalizeLinkURL cleans up the full urls stored for the amz, ebj, raw and engtl links so
+// the same page is always mapped by the same string: https, a lower case canonical host, no
+// fragment and no tracking parameters. Amazon product pages are reduced to /dp/{id}.
+func normalizeLinkURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid url %q: unsupported scheme %s", raw, u.Scheme)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" || !strings.Contains(host, ".") {
+		return "", fmt.Errorf("invalid url %q: missing host", raw)
+	}
+
+	u.Scheme = "https"
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Host = canonicalHost(host)
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		u.Host += ":" + port
+	}
+
+	if isAmazonHost(host) {
+		if match := amazonProduct.FindStringSubmatch(u.Path); match != nil {
+			u.Path = "/dp/" + strings.ToUpper(match[1])
+			u.RawPath = ""
+			u.RawQuery = ""
+		}
+		// Amazon appends the referral to the path too, /ref=sr_1_1
+		if i := strings.Index(u.Path, "/ref="); i >= 0 {
+			u.Path = u.Path[:i]
+			u.RawPath = ""
+		}
+	}
+
+	query := u.Query()
+	for key := range query {
+		if isTrackingParam(key) || (isAmazonHost(host) && amazonTrackingParams[strings.ToLower(key)]) {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+	if u.Path == "/" && u.RawQuery == "" {
+		u.Path = ""
+	}
+	return u.String(), nil
+}
+
+// canonicalHost drops the prefixes which serve the same pages as the main host.
+func canonicalHost(host string) string {
+	if isAmazonHost(host) {
+		// smile. and m. serve the same store, www. is the canonical one
+		for _, prefix := range []string{"smile.", "m.", "www."} {
+			host = strings.TrimPrefix(host, prefix)
+		}
+		return "www." + host
+	}
+	return strings.TrimPrefix(host, "m.")
+}
+
+func isAmazonHost(host string) bool {
+	for _, part := range strings.Split(host, ".") {
+		if part == "amazon" {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	if trackingParams[key] {
+		return true
+	}
+	for _, prefix := range trackingPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
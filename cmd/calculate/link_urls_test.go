@@ -0,0 +1,36 @@
+package calculate
+
+import "testing"
+
+func TestNormalizeLinkURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://www.amazon.co.jp/Some-Title-Vol-1/dp/4088725093/ref=sr_1_1?keywords=title&qid=1600000000&sr=8-1", "https://www.amazon.co.jp/dp/4088725093"},
+		{"http://amazon.com/gp/product/b00abcdefg?tag=affiliate-20", "https://www.amazon.com/dp/B00ABCDEFG"},
+		{"https://smile.amazon.com/s?k=manga&ref=nb_sb_noss", "https://www.amazon.com/s?k=manga"},
+		{"https://ebookjapan.yahoo.co.jp/books/123456/?utm_source=twitter&utm_medium=social#top", "https://ebookjapan.yahoo.co.jp/books/123456/"},
+		{"HTTPS://Comic-Walker.com:443/contents/detail/KDCW_AM01000001010000_68/?fbclid=abc", "https://comic-walker.com/contents/detail/KDCW_AM01000001010000_68/"},
+		{"m.shonenjumpplus.com/episode/13933686331623812157", "https://shonenjumpplus.com/episode/13933686331623812157"},
+		{"https://www.viz.com/shonenjump/chapters/one-piece?tag=keep", "https://www.viz.com/shonenjump/chapters/one-piece?tag=keep"},
+		{"https://example.com/?b=2&a=1", "https://example.com/?a=1&b=2"},
+		{"https://example.com/", "https://example.com"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeLinkURL(tt.raw)
+		if err != nil {
+			t.Errorf("normalizeLinkURL(%q) returned %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeLinkURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+
+	for _, raw := range []string{"", "not a url", "ftp://example.com/file", "https://localhost/path"} {
+		if got, err := normalizeLinkURL(raw); err == nil {
+			t.Errorf("expected normalizeLinkURL(%q) to fail, got %q", raw, got)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package calculate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/similar-manga/similar/internal"
+)
+
+// mangaupdates
+// https://www.mangaupdates.com/series.html?id=`{id}`
+// https://api.mangaupdates.com/#operation/retrieveSeries
+// https://api.mangaupdates.com/v1/series/(base38 encoding of 7char ids)
+// https://api.mangaupdates.com/v1/series/66788345008/rss
+const (
+	MangaUpdatesApiUrl  = "https://api.mangaupdates.com/v1"
+	MangaUpdatesSiteUrl = "https://www.mangaupdates.com"
+)
+
+// legacyMuId finds the numeric id in a legacy link.
+// For our ID conversion
+// https://www.unitconverters.net/numbers/base-36-to-decimal.htm
+var legacyMuId = regexp.MustCompile(`[-]?\d[\d,]*[\.]?[\d{2}]*`)
+
+func init() {
+	registerResolver(newMangaUpdatesResolver)
+}
+
+// mangaUpdatesResolver resolves MangaUpdates links, either base36 encoded new ids or legacy
+// numeric ids, to the numeric ids of the MangaUpdates api.
+type mangaUpdatesResolver struct {
+	client  *resolverClient
+	apiUrl  string
+	siteUrl string
+}
+
+// newMangaUpdatesResolver allows one request a second, to the api and the site together.
+func newMangaUpdatesResolver(opts resolverOptions) Resolver {
+	r := &mangaUpdatesResolver{client: opts.client, apiUrl: MangaUpdatesApiUrl, siteUrl: MangaUpdatesSiteUrl}
+	opts.client.setRate(1, hostOf(r.apiUrl), hostOf(r.siteUrl))
+	return r
+}
+
+func (r *mangaUpdatesResolver) Site() internal.MappingSite {
+	site, _ := internal.MappingSiteByKey("mu_new")
+	return site
+}
+
+func (r *mangaUpdatesResolver) LinkKey() string { return "mu" }
+
+func (r *mangaUpdatesResolver) FileName() string { return "mangaupdates_new2mdex" }
+
+// Resolve tries the link as a base36 encoded new id first, then as a legacy id.
+func (r *mangaUpdatesResolver) Resolve(ctx context.Context, uuid string, link string) (string, error) {
+	id, err := r.resolveNewId(ctx, link)
+	if err == nil || ctx.Err() != nil {
+		return id, err
+	}
+	legacyId, legacyErr := r.resolveLegacyId(ctx, uuid, link)
+	// A network error on either lookup means the link may still resolve
+	if outcomeOf(legacyErr) == outcomeNotFound && outcomeOf(err) == outcomeNetworkError {
+		return "", err
+	}
+	return legacyId, legacyErr
+}
+
+// resolveNewId checks whether a 7 character link is a base36 encoded new id.
+func (r *mangaUpdatesResolver) resolveNewId(ctx context.Context, link string) (string, error) {
+	if len(link) != 7 {
+		return "", resolveFailure(outcomeNotFound, "%q is not a new id", link)
+	}
+	// Encode from base36 format
+	base10Id := strconv.FormatInt(int64(internal.Decode(link)), 10)
+
+	resp, err := r.client.get(ctx, r.apiUrl+"/series/"+base10Id, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", resolveFailure(outcomeNetworkError, "get new id %s: %w", base10Id, err)
+	}
+	defer drainAndClose(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", resolveFailure(outcomeForStatus(resp.StatusCode), "new id %s: http code %d", base10Id, resp.StatusCode)
+	}
+	return checkNewMuId(base10Id)
+}
+
+// resolveLegacyId resolves a legacy numeric id, falling back to scraping the series page.
+func (r *mangaUpdatesResolver) resolveLegacyId(ctx context.Context, uuid string, link string) (string, error) {
+	ints := legacyMuId.FindAllString(link, -1)
+	if len(ints) < 1 {
+		return "", resolveFailure(outcomeNotFound, "%q has no legacy id", link)
+	}
+	idOriginal, err := strconv.Atoi(ints[0])
+	if err != nil {
+		return "", resolveFailure(outcomeNotFound, "%q has no legacy id", link)
+	}
+	convertedId := strconv.Itoa(idOriginal)
+
+	// Try the existing as the id (not likely since mangadex won't have updated..)
+	resp, err := r.client.get(ctx, r.apiUrl+"/series/"+convertedId, nil)
+	drainAndClose(resp)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err == nil && resp.StatusCode == http.StatusOK {
+		return checkNewMuId(convertedId)
+	}
+	failure := resolveFailure(outcomeNotFound, "legacy id %s not found", convertedId)
+	if err != nil {
+		failure = resolveFailure(outcomeNetworkError, "get legacy id %s: %w", convertedId, err)
+	}
+
+	// If invalid, then try to get the page and parse it!
+	// Query and get our html... (no api to get this...)
+	url := r.siteUrl + "/series.html?id=" + convertedId
+	header := http.Header{"User-Agent": {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Safari/537.36"}}
+
+	// We have a couple retires here
+	counterMax := 5
+	for counter := 1; counter < counterMax; counter++ {
+		resp, err := r.client.get(ctx, url, header)
+		if ctx.Err() != nil {
+			drainAndClose(resp)
+			return "", ctx.Err()
+		}
+
+		switch {
+		case err != nil:
+			failure = resolveFailure(outcomeNetworkError, "get %s (try %d of %d): %w", url, counter, counterMax, err)
+		case resp.StatusCode == http.StatusOK:
+			// Load the HTML document
+			// Logic found using google chrome (right click in inspector and copy "selector")
+			doc, err := goquery.NewDocumentFromReader(resp.Body)
+			drainAndClose(resp)
+			if err != nil {
+				failure = resolveFailure(outcomeNotFound, "parse %s: %w", url, err)
+				continue
+			}
+			// The RSS link of the legacy page points at the new id, .../series/<id>/rss
+			rssUrl := doc.Find("#main_content > div:nth-child(2) > div.row.no-gutters > div.col-12.p-2 > a").AttrOr("href", "")
+			paths := strings.Split(rssUrl, "/")
+			if len(paths) > 3 {
+				return checkNewMuId(paths[len(paths)-2])
+			}
+			failure = resolveFailure(outcomeNotFound, "no rss link on %s", url)
+		case resp.StatusCode == http.StatusServiceUnavailable:
+			// this is a bad id on Dex's side write to debug file
+			drainAndClose(resp)
+			return "", errors.Join(resolveFailure(outcomeBadId, "%s: http code %d", url, resp.StatusCode),
+				WriteLineToDebugFile("BadMUIds", "https://mangadex.org/title/"+uuid))
+		default:
+			drainAndClose(resp)
+			failure = resolveFailure(outcomeForStatus(resp.StatusCode), "%s: http code %d (try %d of %d)", url, resp.StatusCode, counter, counterMax)
+			// Sleep if we get a warning before trying again
+			if err := sleepContext(ctx, 2*time.Second); err != nil {
+				return "", err
+			}
+		}
+	}
+	return "", failure
+}
+
+// checkNewMuId checks a resolved id, the api only knows numeric ones.
+func checkNewMuId(id string) (string, error) {
+	id, err := normalizeNewMuId(id)
+	if err != nil {
+		return "", &resolveError{outcome: outcomeBadId, err: err}
+	}
+	return id, nil
+}
@@ -1,176 +1,91 @@
 package calculate
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"github.com/PuerkitoBio/goquery"
 	"github.com/similar-manga/similar/internal"
 	"go.uber.org/ratelimit"
 	"io"
 	"net/http"
-	"regexp"
-	"strconv"
-	"strings"
 	"time"
 )
 
 var httpClient = &http.Client{
-	Timeout: 30 * time.Second,
+	Timeout:   30 * time.Second,
+	Transport: internal.HTTPTransport,
 }
 
-func muEntryExistsInNewIDDatabase(uuid string) bool {
-	rows, err := internal.DB.Query("SELECT UUID FROM "+internal.TableMangaupdatesNewId+" WHERE UUID= ?", uuid)
-	internal.CheckErr(err)
-	defer rows.Close()
-	return rows.Next()
+// upsertResolvedId stores the id a resolver resolved the link of a manga to.
+func upsertResolvedId(table string, uuid string, id string, run internal.MappingRun) error {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin %s %s: %w", table, uuid, err)
+	}
+	defer tx.Rollback()
+	if err := UpsertGeneric(tx, table, uuid, id, run); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit %s %s: %w", table, uuid, err)
+	}
+	return nil
 }
 
-func upsertNewMuId(uuid string, id string) {
-	_, err := internal.DB.Exec("INSERT INTO "+internal.TableMangaupdatesNewId+" (UUID, ID) VALUES (?, ?) ON CONFLICT (UUID) DO UPDATE SET ID=excluded.ID", uuid, id)
-	internal.CheckErr(err)
+// deleteResolvedId closes the id a resolver stored for a manga whose link is gone or no longer
+// resolves.
+func deleteResolvedId(table string, uuid string, run internal.MappingRun) error {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin %s %s: %w", table, uuid, err)
+	}
+	defer tx.Rollback()
+	if err := DeleteGeneric(tx, table, uuid, run); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit %s %s: %w", table, uuid, err)
+	}
+	return nil
 }
 
-func UpsertGeneric(tx *sql.Tx, table string, uuid string, id string) {
-	_, err := tx.Exec("INSERT INTO "+table+" (UUID, ID) VALUES (?, ?) ON CONFLICT (UUID) DO UPDATE SET ID=excluded.ID", uuid, id)
-	internal.CheckErr(err)
+// DeleteGeneric removes the mapping of a manga, closing its history.
+func DeleteGeneric(tx *sql.Tx, table string, uuid string, run internal.MappingRun) error {
+	site, err := mappingSiteByTable(table)
+	if err != nil {
+		return err
+	}
+	return internal.DeleteMapping(tx, site, uuid, run)
 }
 
-func AddAlreadyConvertedId(index int, total int, uuid string, muLink string, rateLimiter ratelimit.Limiter) bool {
-	if len(muLink) == 7 {
-		// Encode from base36 format
-		idEncoded := int64(internal.Decode(muLink))
-		base10Id := strconv.FormatInt(idEncoded, 10)
-
-		if muEntryExistsInNewIDDatabase(uuid) {
-			return true
-		}
-
-		// Try the new id!
-		rateLimiter.Take()
-		resp2, err := httpClient.Get("https://api.mangaupdates.com/v1/series/" + base10Id)
-		if err != nil {
-			fmt.Printf("\u001B[1;31m %s EXTERNAL MU: failed to get new id %s: %v\u001B[0m\n", uuid, base10Id, err)
-			return false
-		}
-		defer drainAndClose(resp2)
-
-		// Save if good!
-		if resp2.StatusCode == 200 {
-			fmt.Printf("%d/%d manga %s -> mu id %s encoded into %s -> is new MU id!\n", index+1, total, uuid, muLink, base10Id)
-			upsertNewMuId(uuid, base10Id)
-			return true
-		}
+// UpsertGeneric stores the mapping of a manga, recording it in the history when it changed.
+func UpsertGeneric(tx *sql.Tx, table string, uuid string, id string, run internal.MappingRun) error {
+	site, err := mappingSiteByTable(table)
+	if err != nil {
+		return err
 	}
-	return false
+	return internal.SetMapping(tx, site, uuid, id, run)
 }
 
-func CheckAndAddLegacyId(index int, total int, uuid string, muLink string, rateLimiter ratelimit.Limiter) bool {
-	// For our ID conversion
-	// https://www.unitconverters.net/numbers/base-36-to-decimal.htm
-	re := regexp.MustCompile(`[-]?\d[\d,]*[\.]?[\d{2}]*`)
-
-	ints := re.FindAllString(muLink, -1)
-	if len(ints) < 1 {
-		return false
+// takeContext waits for the rate limiter, which can't be interrupted, then reports whether ctx
+// was cancelled in the meantime.
+func takeContext(ctx context.Context, rateLimiter ratelimit.Limiter) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	idOriginal, err := strconv.Atoi(ints[0])
-	if err == nil {
-		convertedId := strconv.Itoa(idOriginal)
-
-		if muEntryExistsInNewIDDatabase(uuid) {
-			return true
-		}
-
-		rateLimiter.Take()
-		// Try the existing as the id (not likely since mangadex won't have updated..)
-		resp1, err1 := httpClient.Get("https://api.mangaupdates.com/v1/series/" + convertedId)
-
-		if err1 == nil && resp1.StatusCode == 200 {
-			drainAndClose(resp1)
-
-			fmt.Printf("%d/%d manga %s -> mu id of %d -> is old MU id...\n", index+1, total, uuid, idOriginal)
-			upsertNewMuId(uuid, convertedId)
-
-		} else {
-			if err1 != nil {
-				fmt.Printf("\u001B[1;31m %s EXTERNAL MU: failed to get legacy id %s: %v\u001B[0m\n", uuid, convertedId, err1)
-			}
-			if resp1 != nil {
-				drainAndClose(resp1)
-			}
-
-			// We have a couple retires here
-			counterMax := 5
-			for counter := 1; counter < counterMax; counter++ {
-				rateLimiter.Take()
-
-				// If invalid, then try to get the page and parse it!
-				// Query and get our html... (no api to get this...)
-				url := "https://www.mangaupdates.com/series.html?id=" + convertedId
-				req, err := http.NewRequest("GET", url, nil)
-				if err != nil {
-					fmt.Printf("\u001B[1;31m %s EXTERNAL MU: failed to create request for %s: %v\u001B[0m\n", uuid, url, err)
-					return false
-				}
-				req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Safari/537.36")
-				resp, err := httpClient.Do(req)
-
-				// Sleep if we get a warning, otherwise we don't retry again!
-				if err == nil && resp.StatusCode == 429 {
-					fmt.Printf("\u001B[1;31m %s EXTERNAL MU: http code %d (try %d of %d)\u001B[0m\n", uuid, resp.StatusCode, counter, counterMax)
-
-					drainAndClose(resp)
-
-					time.Sleep(2.0 * time.Second)
-				} else if err == nil && resp.StatusCode != 200 {
-					if resp.StatusCode == 503 {
-						//this is a bad id on Dex's side write to debug file
-						WriteLineToDebugFile("BadMUIds", "https://mangadex.org/title/"+uuid)
-
-						drainAndClose(resp)
-
-						return false
-					} else {
-						fmt.Printf("\u001B[1;31m %s EXTERNAL MU %s: http code %d (try %d of %d)\u001B[0m\n", uuid, url, resp.StatusCode, counter, counterMax)
-
-						drainAndClose(resp)
-
-						time.Sleep(2.0 * time.Second)
-					}
-
-				} else if err == nil && resp.StatusCode == 200 {
-					// Load the HTML document
-					// Logic found using google chrome (right click in inspector and copy "selector")
-					doc, err := goquery.NewDocumentFromReader(resp.Body)
-					drainAndClose(resp)
-
-					if err != nil {
-						fmt.Printf("\u001B[1;31m %s EXTERNAL MU: failed to parse HTML for %s: %v\u001B[0m\n", uuid, url, err)
-						continue
-					}
+	rateLimiter.Take()
+	return ctx.Err()
+}
 
-					rssUrl := doc.Find("#main_content > div:nth-child(2) > div.row.no-gutters > div.col-12.p-2 > a").AttrOr("href", "")
-					paths := strings.Split(rssUrl, "/")
-					if len(paths) > 3 {
-						rssId := paths[len(paths)-2]
-						fmt.Printf("%d/%d manga %s -> mu id of %d | RSS URL IS %s | %s id found\n", index+1, total, uuid, idOriginal, rssUrl, rssId)
-						upsertNewMuId(uuid, convertedId)
-						return true
-					}
-				} else {
-					if err != nil {
-						fmt.Printf("\u001B[1;31m %s EXTERNAL MU: request failed for %s (try %d of %d): %v\u001B[0m\n", uuid, url, counter, counterMax, err)
-					}
-					// Catch all case to ensure body is closed
-					if resp != nil {
-						drainAndClose(resp)
-					}
-				}
-			}
-		}
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return false
-
 }
 
 func drainAndClose(resp *http.Response) {
@@ -1,12 +1,10 @@
 package calculate
 
 import (
+	"errors"
 	"fmt"
 	"github.com/similar-manga/similar/internal"
-	"iter"
 	"github.com/spf13/cobra"
-	"go.uber.org/ratelimit"
-	"sync"
 	"time"
 )
 
@@ -14,112 +12,145 @@ var mappingsCmd = &cobra.Command{
 	Use:   "mappings",
 	Short: "This updates the external website mapping ids to MangaDex uuids",
 	Long:  "This updates the external website mapping ids to MangaDex uuids",
-	Run:   runMappings,
+	RunE:  runMappings,
 }
 
 func init() {
 	calculateCmd.AddCommand(mappingsCmd)
+	mappingsCmd.Flags().String("kitsu-url", KitsuBaseUrl, "Kitsu api used to resolve slugs, such as a local stub")
 }
 
-func runMappings(cmd *cobra.Command, args []string) {
+func runMappings(cmd *cobra.Command, args []string) error {
 	initialStart := time.Now()
-
-	mangaStream := internal.StreamAllManga()
+	ctx := cmd.Context()
 
 	type mappingInfo struct {
 		name      string
 		linkKey   string
 		tableName string
 		fileName  string
+		// normalize cleans up the stored link, nil keeps it as is
+		normalize func(string) (string, error)
 	}
 
 	mappings := []mappingInfo{
-		{"AniList", "al", internal.TableAnilist, "anilist2mdex"},
-		{"AnimePlanet", "ap", internal.TableAnimePlanet, "animeplanet2mdex"},
-		{"BookWalker", "bw", internal.TableBookWalker, "bookwalker2mdex"},
-		{"NovelUpdates", "nu", internal.TableNovelUpdates, "novelupdates2mdex"},
-		{"Kitsu", "kt", internal.TableKitsu, "kitsu2mdex"},
-		{"MyAnimeList", "mal", internal.TableMyanimelist, "myanimelist2mdex"},
-		{"MangaUpdates", "mu", internal.TableMangaupdates, "mangaupdates2mdex"},
+		{"AniList", "al", internal.TableAnilist, "anilist2mdex", normalizeNumericId("manga")},
+		{"AnimePlanet", "ap", internal.TableAnimePlanet, "animeplanet2mdex", normalizeSlugId("manga")},
+		{"BookWalker", "bw", internal.TableBookWalker, "bookwalker2mdex", normalizeBookWalkerId},
+		{"NovelUpdates", "nu", internal.TableNovelUpdates, "novelupdates2mdex", normalizeSlugId("series")},
+		{"Kitsu", "kt", internal.TableKitsu, "kitsu2mdex", normalizeKitsuId},
+		{"MyAnimeList", "mal", internal.TableMyanimelist, "myanimelist2mdex", normalizeNumericId("manga")},
+		{"MangaUpdates", "mu", internal.TableMangaupdates, "mangaupdates2mdex", nil},
+		{"Amazon", "amz", internal.TableAmazon, "amazon2mdex", normalizeLinkURL},
+		{"eBookJapan", "ebj", internal.TableEbookJapan, "ebookjapan2mdex", normalizeLinkURL},
+		{"Raw", "raw", internal.TableRaw, "raw2mdex", normalizeLinkURL},
+		{"Official English", "engtl", internal.TableEnglishTl, "engtl2mdex", normalizeLinkURL},
+	}
+
+	// Every change this run makes to the mappings is recorded in their history
+	run := internal.NewMappingRun("calculate mappings")
+	if err := internal.BackfillMappingHistory(run); err != nil {
+		return err
 	}
 
 	fmt.Println("Calculating mappings...")
+	// Only the links are kept, read up front since the stream holds the only database
+	// connection while it is open
+	var mangaLinks []internal.Manga
+	for manga := range internal.StreamAllManga() {
+		mangaLinks = append(mangaLinks, internal.Manga{Id: manga.Id, Links: manga.Links})
+	}
+
+	// Mappings stored by an earlier run, those whose link is gone are deleted below
+	stored := make(map[string]map[string]bool, len(mappings))
+	for _, m := range mappings {
+		entries, err := getAllGenericFromTable(m.tableName)
+		if err != nil {
+			return err
+		}
+		stored[m.tableName] = make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			stored[m.tableName][entry.UUID] = true
+		}
+	}
+
 	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("begin mappings: %w", err)
+	}
 	defer tx.Rollback()
 
-	for manga := range mangaStream {
+	processed := 0
+	var issues []linkIssue
+	for _, manga := range mangaLinks {
+		// Keep the mappings read so far, they are committed and exported below
+		if ctx.Err() != nil {
+			break
+		}
+		processed++
 		for _, m := range mappings {
-			id := manga.Links[m.linkKey]
-			if id != "" {
-				UpsertGeneric(tx, m.tableName, manga.Id, id)
+			value := manga.Links[m.linkKey]
+			if value == "" {
+				// The link was removed from MangaDex since the mapping was stored
+				if stored[m.tableName][manga.Id] {
+					if err := DeleteGeneric(tx, m.tableName, manga.Id, run); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			id := value
+			if m.normalize != nil {
+				var err error
+				if id, err = m.normalize(value); err != nil {
+					issues = append(issues, linkIssue{Site: m.linkKey, UUID: manga.Id, Value: value, Error: err.Error()})
+					// Drop whatever an earlier run stored for the link
+					if err := DeleteGeneric(tx, m.tableName, manga.Id, run); err != nil {
+						return err
+					}
+					continue
+				}
+				if id != value {
+					issues = append(issues, linkIssue{Site: m.linkKey, UUID: manga.Id, Value: value, Fixed: id})
+				}
+			}
+			if err := UpsertGeneric(tx, m.tableName, manga.Id, id, run); err != nil {
+				return err
 			}
 		}
 	}
 
-	err = tx.Commit()
-	internal.CheckErr(err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit mappings: %w", err)
+	}
+	if err := reportLinkIssues(issues); err != nil {
+		return err
+	}
 
 	fmt.Println("Exporting mapping files...")
 	for _, m := range mappings {
 		fmt.Printf("Exporting %s mapping file\n", m.name)
-		exportMapping(m.tableName, m.fileName)
+		if err := exportMapping(m.tableName, m.fileName); err != nil {
+			return err
+		}
 	}
-
-	totalManga, err := internal.GetMangaCount()
-	internal.CheckErr(err)
-	calculateMangaUpdatesNewIdMapping(internal.StreamAllManga(), totalManga)
-
-	fmt.Printf("Finished all mappings in %s\n", time.Since(initialStart))
-
-}
-
-func calculateMangaUpdatesNewIdMapping(mangaList iter.Seq[internal.Manga], totalManga int) {
-	fmt.Println("Calculating MangaUpdates New Id Mapping")
-	rateLimiter := ratelimit.New(1)
-
-	// mangaupdates
-	// https://www.mangaupdates.com/series.html?id=`{id}`
-	// https://api.mangaupdates.com/#operation/retrieveSeries
-	// https://api.mangaupdates.com/v1/series/(base38 encoding of 7char ids)
-	// https://api.mangaupdates.com/v1/series/66788345008/rss
-
-	// Loop through all manga and try to get their chapter information for each
-	start := time.Now()
-	var wg sync.WaitGroup
-	maxGoroutines := 1000
-	guard := make(chan struct{}, maxGoroutines)
-
-	index := 0
-	for manga := range mangaList {
-		muLink := manga.Links["mu"]
-
-		// would block if guard channel is already filled
-		guard <- struct{}{}
-
-		wg.Add(1)
-		go func(index int, totalManga int, uuid string, muLink string, limiter ratelimit.Limiter) {
-			defer func() {
-				if r := recover(); r != nil {
-					fmt.Println("goroutine paniqued: ", r)
-				}
-			}()
-			// Our search file
-			defer wg.Done()
-			if muLink != "" {
-				if !AddAlreadyConvertedId(index, totalManga, uuid, muLink, rateLimiter) && !CheckAndAddLegacyId(index, totalManga, uuid, muLink, rateLimiter) {
-					fmt.Printf("%d/%d manga %s -> mu invalid %s\n", index+1, totalManga, uuid, muLink)
-				}
-			}
-			<-guard
-		}(index, totalManga, manga.Id, muLink, rateLimiter)
-		index++
+	if err := reportMappingConflicts(); err != nil {
+		return err
 	}
 
-	wg.Wait()
-
-	fmt.Println("Exporting MangaUpdates New Ids file")
-	exportMapping(internal.TableMangaupdatesNewId, "mangaupdates_new2mdex")
+	totalManga := len(mangaLinks)
+	if ctx.Err() != nil {
+		fmt.Printf("Interrupted, mapped %d of %d manga and skipped resolving the external ids\n", processed, totalManga)
+		return fmt.Errorf("calculate mappings: %w", ctx.Err())
+	}
+	kitsuUrl, _ := cmd.Flags().GetString("kitsu-url")
+	resolvers := newResolvers(resolverOptions{client: newResolverClient(), kitsuUrl: kitsuUrl})
+	err = runResolvers(ctx, resolvers, mangaLinks, run)
+	// The resolved ids have conflicts of their own
+	if err := errors.Join(err, reportMappingConflicts()); err != nil {
+		return err
+	}
 
-	fmt.Printf("done processing MangaUpdates New Ids (%.2f seconds)!\n", time.Since(start).Seconds())
+	fmt.Printf("Finished all mappings in %s\n", time.Since(initialStart))
+	return nil
 }
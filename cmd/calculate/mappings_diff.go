@@ -0,0 +1,149 @@
+package calculate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var mappingsDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Export the mappings added, changed and removed since a date",
+	Long:  "Export the mappings added, changed and removed since a date, per site, from the mapping history",
+	RunE:  runMappingsDiff,
+}
+
+func init() {
+	mappingsCmd.AddCommand(mappingsDiffCmd)
+	mappingsDiffCmd.Flags().String("since", "", "Date, or RFC3339 time, to list the changes from")
+	mappingsDiffCmd.Flags().StringP("output", "o", "data/mappings/mappings_diff.json", "File the changes are written to")
+}
+
+// mappingDiff lists the changes to the mappings of each site between two times.
+type mappingDiff struct {
+	Since string              `json:"since"`
+	Until string              `json:"until"`
+	Sites map[string]siteDiff `json:"sites"`
+}
+
+type siteDiff struct {
+	Added   []mappingChange `json:"added"`
+	Changed []mappingChange `json:"changed"`
+	Removed []mappingChange `json:"removed"`
+}
+
+// mappingChange is a mapping of a manga which changed, Id is empty for a removed mapping and
+// PreviousId for an added one.
+type mappingChange struct {
+	UUID       string `json:"uuid"`
+	Id         string `json:"id,omitempty"`
+	PreviousId string `json:"previousId,omitempty"`
+}
+
+func runMappingsDiff(command *cobra.Command, args []string) error {
+	sinceFlag, _ := command.Flags().GetString("since")
+	output, _ := command.Flags().GetString("output")
+	since, err := parseSince(sinceFlag)
+	if err != nil {
+		return cmd.UsageErrorf("invalid --since %q: expected a date such as 2024-01-31 or an RFC3339 time", sinceFlag)
+	}
+
+	diff, err := getMappingDiff(since, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, site := range internal.MappingSites {
+		changes := diff.Sites[site.Key]
+		if len(changes.Added)+len(changes.Changed)+len(changes.Removed) > 0 {
+			fmt.Printf("%s: %d added, %d changed, %d removed\n", site.Name, len(changes.Added), len(changes.Changed), len(changes.Removed))
+		}
+	}
+
+	data, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode mappings diff: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("create mappings diff dir: %w", err)
+	}
+	if err := os.WriteFile(output, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("write mappings diff: %w", err)
+	}
+	fmt.Printf("Wrote the mapping changes since %s to %s\n", diff.Since, output)
+	return nil
+}
+
+// parseSince accepts a date, taken as midnight UTC, or an RFC3339 time.
+func parseSince(value string) (time.Time, error) {
+	if since, err := time.Parse(time.DateOnly, value); err == nil {
+		return since, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// getMappingDiff compares the mappings valid at since with the current ones. A mapping which
+// changed and then changed back is not listed.
+func getMappingDiff(since time.Time, until time.Time) (mappingDiff, error) {
+	sinceText := since.UTC().Format(internal.MappingTimeFormat)
+	diff := mappingDiff{Since: sinceText, Until: until.UTC().Format(internal.MappingTimeFormat), Sites: make(map[string]siteDiff)}
+	for _, site := range internal.MappingSites {
+		diff.Sites[site.Key] = siteDiff{Added: []mappingChange{}, Changed: []mappingChange{}, Removed: []mappingChange{}}
+	}
+
+	// Only intervals still open at since can tell what was mapped then
+	rows, err := internal.DB.Query("SELECT SITE, UUID, ID, VALID_FROM, VALID_TO FROM "+internal.TableMappingHistory+
+		" WHERE VALID_TO IS NULL OR VALID_TO > ? ORDER BY SITE, UUID", sinceText)
+	if err != nil {
+		return diff, fmt.Errorf("query mapping history: %w", err)
+	}
+	defer rows.Close()
+
+	type state struct{ site, uuid, before, after string }
+	var current *state
+	flush := func() {
+		if current == nil || current.before == current.after {
+			return
+		}
+		changes := diff.Sites[current.site]
+		change := mappingChange{UUID: current.uuid, Id: current.after, PreviousId: current.before}
+		switch {
+		case current.before == "":
+			changes.Added = append(changes.Added, change)
+		case current.after == "":
+			changes.Removed = append(changes.Removed, change)
+		default:
+			changes.Changed = append(changes.Changed, change)
+		}
+		diff.Sites[current.site] = changes
+	}
+	for rows.Next() {
+		var site, uuid, id string
+		var validFrom, validTo sql.NullString
+		if err := rows.Scan(&site, &uuid, &id, &validFrom, &validTo); err != nil {
+			return diff, fmt.Errorf("scan mapping history: %w", err)
+		}
+		if current == nil || current.site != site || current.uuid != uuid {
+			flush()
+			current = &state{site: site, uuid: uuid}
+		}
+		// Intervals from before history was kept have no start
+		if !validFrom.Valid || validFrom.String <= sinceText {
+			current.before = id
+		}
+		if !validTo.Valid {
+			current.after = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return diff, fmt.Errorf("iterate mapping history: %w", err)
+	}
+	flush()
+	return diff, nil
+}
@@ -0,0 +1,112 @@
+package calculate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+func TestGetMappingDiff(t *testing.T) {
+	setupMappingsTest(t)
+	anilist, _ := internal.MappingSiteByKey("al")
+	january := internal.MappingRun{Id: "january", At: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	march := internal.MappingRun{Id: "march", At: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+
+	steps := []struct {
+		run  internal.MappingRun
+		uuid string
+		id   string
+	}{
+		{january, "uuid-changed", "1"},
+		{january, "uuid-removed", "2"},
+		{january, "uuid-reverted", "3"},
+		{march, "uuid-added", "4"},
+		{march, "uuid-changed", "5"},
+		{march, "uuid-removed", ""},
+		{march, "uuid-reverted", "6"},
+		{march, "uuid-reverted", "3"},
+	}
+	for _, step := range steps {
+		var err error
+		if step.id == "" {
+			err = internal.DeleteMapping(internal.DB, anilist, step.uuid, step.run)
+		} else {
+			err = internal.SetMapping(internal.DB, anilist, step.uuid, step.id, step.run)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff, err := getMappingDiff(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), march.At)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := diff.Sites["al"]
+	if len(changes.Added) != 1 || changes.Added[0] != (mappingChange{UUID: "uuid-added", Id: "4"}) {
+		t.Errorf("unexpected added %+v", changes.Added)
+	}
+	if len(changes.Changed) != 1 || changes.Changed[0] != (mappingChange{UUID: "uuid-changed", Id: "5", PreviousId: "1"}) {
+		t.Errorf("unexpected changed %+v", changes.Changed)
+	}
+	if len(changes.Removed) != 1 || changes.Removed[0] != (mappingChange{UUID: "uuid-removed", PreviousId: "2"}) {
+		t.Errorf("unexpected removed %+v", changes.Removed)
+	}
+
+	// Everything happened after the first run
+	diff, err = getMappingDiff(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), march.At)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := diff.Sites["al"]; len(changes.Added) != 3 || len(changes.Changed) != 0 || len(changes.Removed) != 0 {
+		t.Errorf("unexpected changes since 2023 %+v", changes)
+	}
+}
+
+func TestRunMappingsRemovesDroppedLinks(t *testing.T) {
+	setupMappingsTest(t, internal.Manga{Id: "uuid-1", Links: map[string]string{"al": "30013", "mal": "13"}})
+	mappingsCmd.SetContext(context.Background())
+	if err := runMappings(mappingsCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A MangaUpdates id resolved from a link removed since
+	if err := upsertResolvedId(internal.TableMangaupdatesNewId, "uuid-1", "55099564912", internal.NewMappingRun("test")); err != nil {
+		t.Fatal(err)
+	}
+	// Move the first run back so the second one falls after since
+	if _, err := internal.DB.Exec("UPDATE " + internal.TableMappingHistory + " SET VALID_FROM = '2024-01-01T00:00:00Z'"); err != nil {
+		t.Fatal(err)
+	}
+
+	// An editor removed the AniList link
+	jsonManga, _ := json.Marshal(internal.Manga{Id: "uuid-1", Links: map[string]string{"mal": "13"}})
+	if _, err := internal.DB.Exec("UPDATE "+internal.TableManga+" SET JSON = ? WHERE UUID = ?", jsonManga, "uuid-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runMappings(mappingsCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readMappingFile(t, "anilist2mdex"); len(got) != 0 {
+		t.Errorf("expected the dropped anilist link to be removed, got %v", got)
+	}
+	if got := readMappingFile(t, "mangaupdates_new2mdex"); len(got) != 0 {
+		t.Errorf("expected the resolved MangaUpdates id to be removed, got %v", got)
+	}
+	diff, err := getMappingDiff(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed := diff.Sites["al"].Removed; len(removed) != 1 || removed[0] != (mappingChange{UUID: "uuid-1", PreviousId: "30013"}) {
+		t.Errorf("unexpected removed anilist mappings %+v", removed)
+	}
+	if removed := diff.Sites["mu_new"].Removed; len(removed) != 1 || removed[0] != (mappingChange{UUID: "uuid-1", PreviousId: "55099564912"}) {
+		t.Errorf("unexpected removed MangaUpdates ids %+v", removed)
+	}
+	if removed := diff.Sites["mal"].Removed; len(removed) != 0 {
+		t.Errorf("expected the myanimelist mapping kept, got %+v", removed)
+	}
+}
@@ -0,0 +1,261 @@
+package calculate
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var mappingsImportCmd = &cobra.Command{
+	Use:   "import <dump.json>",
+	Short: "Infer missing mappings from a cross-reference dump",
+	Long: `Read a JSON list of {anilist, mal, kitsu, mangaupdates} id tuples and infer the mappings a manga is missing
+from the ids it already has, following the tuples transitively. Inferred mappings are kept apart from the links set
+on MangaDex and exported to their own *_inferred2mdex files.`,
+	Args: cmd.UsageArgs(cobra.ExactArgs(1)),
+	RunE: runMappingsImport,
+}
+
+func init() {
+	mappingsCmd.AddCommand(mappingsImportCmd)
+}
+
+// crossReferenceSite is a field of the dump and the mapping site its ids belong to.
+type crossReferenceSite struct {
+	field    string
+	siteKey  string
+	fileName string
+}
+
+var crossReferenceSites = []crossReferenceSite{
+	{"anilist", "al", "anilist_inferred2mdex"},
+	{"mal", "mal", "myanimelist_inferred2mdex"},
+	{"kitsu", "kt_id", "kitsu_inferred2mdex"},
+	{"mangaupdates", "mu_new", "mangaupdates_new_inferred2mdex"},
+}
+
+// inferredMapping is a mapping found through the dump, Via is the site and id the manga was
+// already mapped to.
+type inferredMapping struct {
+	Site string
+	UUID string
+	Id   string
+	Via  string
+}
+
+// importResult counts what an import found.
+type importResult struct {
+	Tuples   int
+	Invalid  int
+	Inferred []inferredMapping
+	// Ambiguous counts the mappings left out because the dump offered several ids
+	Ambiguous int
+}
+
+func runMappingsImport(command *cobra.Command, args []string) error {
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("open cross-reference dump: %w", err)
+	}
+	defer file.Close()
+	var tuples []map[string]any
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+	if err := decoder.Decode(&tuples); err != nil {
+		return fmt.Errorf("decode cross-reference dump %s: %w", args[0], err)
+	}
+
+	source := filepath.Base(args[0])
+	result, err := inferMappings(tuples)
+	if err != nil {
+		return err
+	}
+	if err := saveInferredMappings(source, result.Inferred); err != nil {
+		return err
+	}
+	fmt.Printf("Read %d tuples from %s, skipped %d invalid ids\n", result.Tuples, source, result.Invalid)
+	fmt.Printf("Inferred %d mappings, left out %d with several candidate ids\n", len(result.Inferred), result.Ambiguous)
+	return exportInferredMappings()
+}
+
+// inferMappings joins the tuples sharing an id into groups, then gives every manga mapped to
+// one id of a group the ids of the other sites it is missing. Sites with more than one id in
+// a group, or with different ids offered through different groups, are left out.
+func inferMappings(tuples []map[string]any) (importResult, error) {
+	result := importResult{Tuples: len(tuples)}
+
+	// Union find over "site id" nodes
+	parent := make(map[string]string)
+	var find func(node string) string
+	find = func(node string) string {
+		if next, ok := parent[node]; !ok || next == node {
+			parent[node] = node
+			return node
+		}
+		root := find(parent[node])
+		parent[node] = root
+		return root
+	}
+	for _, tuple := range tuples {
+		var first string
+		for _, site := range crossReferenceSites {
+			id, ok := crossReferenceId(tuple[site.field])
+			if !ok {
+				if tuple[site.field] != nil {
+					result.Invalid++
+				}
+				continue
+			}
+			node := site.siteKey + " " + id
+			if first == "" {
+				first = find(node)
+				continue
+			}
+			parent[find(node)] = first
+		}
+	}
+
+	// The ids of each site in every group
+	groups := make(map[string]map[string][]string)
+	for node := range parent {
+		root := find(node)
+		if groups[root] == nil {
+			groups[root] = make(map[string][]string)
+		}
+		siteKey, id, _ := strings.Cut(node, " ")
+		groups[root][siteKey] = append(groups[root][siteKey], id)
+	}
+
+	// The mappings set on MangaDex, by node and by manga
+	mapped := make(map[string][]string)
+	known := make(map[string]map[string]bool)
+	for _, site := range crossReferenceSites {
+		mappingSite, _ := internal.MappingSiteByKey(site.siteKey)
+		genericList, err := getAllGenericFromTable(mappingSite.Table)
+		if err != nil {
+			return result, err
+		}
+		for _, entry := range genericList {
+			node := site.siteKey + " " + entry.ID
+			mapped[node] = append(mapped[node], entry.UUID)
+			if known[entry.UUID] == nil {
+				known[entry.UUID] = make(map[string]bool)
+			}
+			known[entry.UUID][site.siteKey] = true
+		}
+	}
+
+	// Candidates are keyed by site and uuid
+	candidates := make(map[string]inferredMapping)
+	ambiguous := make(map[string]bool)
+	nodes := slices.Sorted(maps.Keys(mapped))
+	for _, node := range nodes {
+		if _, inDump := parent[node]; !inDump {
+			continue
+		}
+		group := groups[find(node)]
+		for _, uuid := range mapped[node] {
+			for siteKey, ids := range group {
+				if known[uuid][siteKey] {
+					continue
+				}
+				key := siteKey + " " + uuid
+				previous, seen := candidates[key]
+				switch {
+				case len(ids) > 1 || (seen && previous.Id != ids[0]):
+					ambiguous[key] = true
+				case !seen:
+					candidates[key] = inferredMapping{Site: siteKey, UUID: uuid, Id: ids[0], Via: node}
+				}
+			}
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(candidates)) {
+		if !ambiguous[key] {
+			result.Inferred = append(result.Inferred, candidates[key])
+		}
+	}
+	result.Ambiguous = len(ambiguous)
+	return result, nil
+}
+
+// crossReferenceId returns a dump value as a numeric id, the dumps store them either as
+// numbers or strings.
+func crossReferenceId(value any) (string, bool) {
+	var id string
+	switch value := value.(type) {
+	case json.Number:
+		id = value.String()
+	case string:
+		id = value
+	default:
+		return "", false
+	}
+	return id, numericId.MatchString(id)
+}
+
+// saveInferredMappings replaces the mappings inferred from an earlier import of the same dump.
+func saveInferredMappings(source string, inferred []inferredMapping) error {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin inferred mappings: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM "+internal.TableMappingInferred+" WHERE SOURCE = ?", source); err != nil {
+		return fmt.Errorf("clear inferred mappings: %w", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO " + internal.TableMappingInferred + " (SITE, UUID, ID, VIA, SOURCE) VALUES (?, ?, ?, ?, ?) " +
+		"ON CONFLICT (SITE, UUID) DO UPDATE SET ID=excluded.ID, VIA=excluded.VIA, SOURCE=excluded.SOURCE")
+	if err != nil {
+		return fmt.Errorf("prepare inferred mappings: %w", err)
+	}
+	defer stmt.Close()
+	for _, mapping := range inferred {
+		if _, err := stmt.Exec(mapping.Site, mapping.UUID, mapping.Id, mapping.Via, source); err != nil {
+			return fmt.Errorf("insert inferred %s mapping %s: %w", mapping.Site, mapping.UUID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit inferred mappings: %w", err)
+	}
+	return nil
+}
+
+// exportInferredMappings writes the inferred mappings of each site to their own file, leaving
+// out the manga which got a mapping on MangaDex since.
+func exportInferredMappings() error {
+	for _, site := range crossReferenceSites {
+		mappingSite, _ := internal.MappingSiteByKey(site.siteKey)
+		rows, err := internal.DB.Query("SELECT UUID, ID FROM "+internal.TableMappingInferred+" WHERE SITE = ? AND UUID NOT IN (SELECT UUID FROM "+mappingSite.Table+") ORDER BY UUID ASC", site.siteKey)
+		if err != nil {
+			return fmt.Errorf("query inferred %s mappings: %w", site.siteKey, err)
+		}
+		var genericList []internal.DbGeneric
+		for rows.Next() {
+			var generic internal.DbGeneric
+			if err := rows.Scan(&generic.UUID, &generic.ID); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan inferred %s mappings: %w", site.siteKey, err)
+			}
+			genericList = append(genericList, generic)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("iterate inferred %s mappings: %w", site.siteKey, err)
+		}
+		if err := exportGeneric(site.fileName, genericList, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d inferred %s mappings\n", len(genericList), mappingSite.Name)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package calculate
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+func TestRunMappingsImport(t *testing.T) {
+	setupMappingsTest(t)
+	for _, insert := range []string{
+		"INSERT INTO " + internal.TableAnilist + " (UUID, ID) VALUES ('uuid-1', '1')",
+		"INSERT INTO " + internal.TableMyanimelist + " (UUID, ID) VALUES ('uuid-2', '10')",
+	} {
+		if _, err := internal.DB.Exec(insert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dump := `[
+		{"anilist": 1, "mal": "2"},
+		{"mal": 2, "kitsu": 3},
+		{"mal": 10, "anilist": 20},
+		{"anilist": 20, "mangaupdates": 30},
+		{"anilist": 21, "mal": 10},
+		{"anilist": "abc", "mal": 99}
+	]`
+	if err := os.WriteFile("dump.json", []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runMappingsImport(mappingsImportCmd, []string{"dump.json"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The MangaDex mappings are left alone
+	if got := readMappingFile(t, "anilist_inferred2mdex"); len(got) != 0 {
+		t.Errorf("expected no anilist id to be inferred for uuid-2 with two candidates, got %v", got)
+	}
+	if got := readMappingFile(t, "myanimelist_inferred2mdex"); strings.Join(got, " ") != "2:::||@!@||:::uuid-1" {
+		t.Errorf("unexpected inferred mal mappings %v", got)
+	}
+	if got := readMappingFile(t, "kitsu_inferred2mdex"); strings.Join(got, " ") != "3:::||@!@||:::uuid-1" {
+		t.Errorf("expected the kitsu id to be inferred through the mal id, got %v", got)
+	}
+	if got := readMappingFile(t, "mangaupdates_new_inferred2mdex"); strings.Join(got, " ") != "30:::||@!@||:::uuid-2" {
+		t.Errorf("unexpected inferred mangaupdates mappings %v", got)
+	}
+
+	var via, source string
+	err := internal.DB.QueryRow("SELECT VIA, SOURCE FROM "+internal.TableMappingInferred+" WHERE SITE = 'kt_id' AND UUID = 'uuid-1'").Scan(&via, &source)
+	if err != nil || via != "al 1" || source != "dump.json" {
+		t.Errorf("unexpected inferred source %q %q (%v)", via, source, err)
+	}
+	var count int
+	if err := internal.DB.QueryRow("SELECT COUNT(*) FROM " + internal.TableAnilist).Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected the anilist table to be left alone, got %d rows (%v)", count, err)
+	}
+}
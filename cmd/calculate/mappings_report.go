@@ -0,0 +1,278 @@
+package calculate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var mappingsReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report how complete the mappings are",
+	Long: `Report, per site, how many manga have a mapping, broken down by original language and content rating,
+along with the MangaUpdates links still unresolved, the conflicting ids and the malformed links.`,
+	RunE: runMappingsReport,
+}
+
+func init() {
+	mappingsCmd.AddCommand(mappingsReportCmd)
+	mappingsReportCmd.Flags().StringP("format", "f", "text", "Output format: text, json or markdown")
+	mappingsReportCmd.Flags().StringP("output", "o", "", "File the report is written to instead of stdout")
+}
+
+// mappingReport is the coverage of every mapping site.
+type mappingReport struct {
+	GeneratedAt string         `json:"generatedAt"`
+	TotalManga  int            `json:"totalManga"`
+	Sites       []siteCoverage `json:"sites"`
+	// UnresolvedMangaUpdates counts the manga with a MangaUpdates link but no new id, by the
+	// outcome of their last lookup
+	UnresolvedMangaUpdates map[string]int `json:"unresolvedMangaUpdates"`
+}
+
+type siteCoverage struct {
+	Site            string              `json:"site"`
+	Name            string              `json:"name"`
+	Coverage        coverage            `json:"coverage"`
+	ByLanguage      map[string]coverage `json:"byLanguage"`
+	ByContentRating map[string]coverage `json:"byContentRating"`
+	Conflicts       int                 `json:"conflicts"`
+	Malformed       int                 `json:"malformed"`
+}
+
+// coverage is how many of a set of manga have a mapping.
+type coverage struct {
+	Mapped  int     `json:"mapped"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+func newCoverage(mapped int, total int) coverage {
+	c := coverage{Mapped: mapped, Total: total}
+	if total > 0 {
+		c.Percent = math.Round(float64(mapped)/float64(total)*10000) / 100
+	}
+	return c
+}
+
+func runMappingsReport(command *cobra.Command, args []string) error {
+	format, _ := command.Flags().GetString("format")
+	output, _ := command.Flags().GetString("output")
+	if !slices.Contains([]string{"text", "json", "markdown"}, format) {
+		return cmd.UsageErrorf("invalid --format %q: expected text, json or markdown", format)
+	}
+	report, err := getMappingReport(time.Now())
+	if err != nil {
+		return err
+	}
+
+	var content string
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encode mappings report: %w", err)
+		}
+		content = string(data) + "\n"
+	case "markdown":
+		content = report.markdown()
+	default:
+		content = report.text()
+	}
+
+	if output == "" {
+		_, err = io.WriteString(os.Stdout, content)
+	} else {
+		// WriteFile reports the error of closing the file as well
+		err = os.WriteFile(output, []byte(content), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("write mappings report: %w", err)
+	}
+	return nil
+}
+
+// getMappingReport reads the coverage of every site from the mapping tables and MANGA.
+func getMappingReport(now time.Time) (mappingReport, error) {
+	report := mappingReport{GeneratedAt: now.UTC().Format(time.RFC3339), UnresolvedMangaUpdates: make(map[string]int)}
+	if err := internal.DB.QueryRow("SELECT COUNT(*) FROM " + internal.TableManga).Scan(&report.TotalManga); err != nil {
+		return report, fmt.Errorf("count manga: %w", err)
+	}
+
+	for _, site := range internal.MappingSites {
+		siteReport := siteCoverage{Site: site.Key, Name: site.Name}
+		byLanguage, err := getCoverageBy(site, "$.originalLanguage")
+		if err != nil {
+			return report, err
+		}
+		byContentRating, err := getCoverageBy(site, "$.contentRating")
+		if err != nil {
+			return report, err
+		}
+		mapped := 0
+		for _, c := range byLanguage {
+			mapped += c.Mapped
+		}
+		siteReport.Coverage = newCoverage(mapped, report.TotalManga)
+		siteReport.ByLanguage = byLanguage
+		siteReport.ByContentRating = byContentRating
+
+		err = internal.DB.QueryRow("SELECT COUNT(DISTINCT ID) FROM "+internal.TableMappingConflicts+" WHERE SITE = ?", site.Key).Scan(&siteReport.Conflicts)
+		if err != nil {
+			return report, fmt.Errorf("count %s conflicts: %w", site.Name, err)
+		}
+		err = internal.DB.QueryRow("SELECT COUNT(*) FROM "+internal.TableLinkIssues+" WHERE SITE = ? AND ERROR != ''", site.Key).Scan(&siteReport.Malformed)
+		if err != nil {
+			return report, fmt.Errorf("count %s malformed links: %w", site.Name, err)
+		}
+		report.Sites = append(report.Sites, siteReport)
+	}
+
+	rows, err := internal.DB.Query("SELECT COALESCE(a.OUTCOME, 'never_attempted'), COUNT(*) FROM "+internal.TableManga+" AS m"+
+		" LEFT JOIN "+internal.TableResolveAttempts+" AS a ON a.SITE = ? AND a.UUID = m.UUID"+
+		" WHERE COALESCE(json_extract(m.JSON, '$.links.mu'), '') != '' AND m.UUID NOT IN (SELECT UUID FROM "+internal.TableMangaupdatesNewId+")"+
+		" GROUP BY 1", "mu_new")
+	if err != nil {
+		return report, fmt.Errorf("count unresolved mu links: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var outcome string
+		var count int
+		if err := rows.Scan(&outcome, &count); err != nil {
+			return report, fmt.Errorf("scan unresolved mu links: %w", err)
+		}
+		report.UnresolvedMangaUpdates[outcome] = count
+	}
+	return report, rows.Err()
+}
+
+// getCoverageBy counts the manga mapped to a site, grouped by a field of their json.
+func getCoverageBy(site internal.MappingSite, path string) (map[string]coverage, error) {
+	rows, err := internal.DB.Query("SELECT COALESCE(json_extract(m.JSON, ?), ''), COUNT(*), COUNT(s.UUID) FROM "+internal.TableManga+" AS m"+
+		" LEFT JOIN "+site.Table+" AS s ON s.UUID = m.UUID GROUP BY 1", path)
+	if err != nil {
+		return nil, fmt.Errorf("query %s coverage: %w", site.Name, err)
+	}
+	defer rows.Close()
+	coverages := make(map[string]coverage)
+	for rows.Next() {
+		var group string
+		var total, mapped int
+		if err := rows.Scan(&group, &total, &mapped); err != nil {
+			return nil, fmt.Errorf("scan %s coverage: %w", site.Name, err)
+		}
+		if group == "" {
+			group = "unknown"
+		}
+		coverages[group] = newCoverage(mapped, total)
+	}
+	return coverages, rows.Err()
+}
+
+// unresolvedTotal is the number of MangaUpdates links without a new id.
+func (report mappingReport) unresolvedTotal() int {
+	total := 0
+	for _, count := range report.UnresolvedMangaUpdates {
+		total += count
+	}
+	return total
+}
+
+func (report mappingReport) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Mapping coverage of %d manga\n", report.TotalManga)
+	for _, site := range report.Sites {
+		fmt.Fprintf(&b, "%-20s %7d %6.2f%%  %d conflicts, %d malformed\n", site.Name, site.Coverage.Mapped, site.Coverage.Percent, site.Conflicts, site.Malformed)
+	}
+	report.textBreakdown(&b, "By original language", func(site siteCoverage) map[string]coverage { return site.ByLanguage })
+	report.textBreakdown(&b, "By content rating", func(site siteCoverage) map[string]coverage { return site.ByContentRating })
+	fmt.Fprintf(&b, "Unresolved MangaUpdates links: %d\n", report.unresolvedTotal())
+	for _, outcome := range slices.Sorted(maps.Keys(report.UnresolvedMangaUpdates)) {
+		fmt.Fprintf(&b, "  %s: %d\n", outcome, report.UnresolvedMangaUpdates[outcome])
+	}
+	return b.String()
+}
+
+// textBreakdown writes a column of the coverage percentage per site for each group, the
+// groups with the most manga first.
+func (report mappingReport) textBreakdown(b *strings.Builder, title string, groupsOf func(siteCoverage) map[string]coverage) {
+	if len(report.Sites) == 0 {
+		return
+	}
+	groups, names := report.breakdownGroups(groupsOf)
+	fmt.Fprintf(b, "%s\n%-20s %7s", title, "", "manga")
+	for _, site := range report.Sites {
+		fmt.Fprintf(b, " %6s", site.Site)
+	}
+	b.WriteString("\n")
+	for _, name := range names {
+		fmt.Fprintf(b, "%-20s %7d", name, groups[name].Total)
+		for _, site := range report.Sites {
+			fmt.Fprintf(b, " %5.1f%%", groupsOf(site)[name].Percent)
+		}
+		b.WriteString("\n")
+	}
+}
+
+func (report mappingReport) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Mapping coverage\n\n%d manga, generated %s.\n\n", report.TotalManga, report.GeneratedAt)
+	b.WriteString("| Site | Mapped | Coverage | Conflicts | Malformed |\n|---|---:|---:|---:|---:|\n")
+	for _, site := range report.Sites {
+		fmt.Fprintf(&b, "| %s (`%s`) | %d | %.2f%% | %d | %d |\n", site.Name, site.Site, site.Coverage.Mapped, site.Coverage.Percent, site.Conflicts, site.Malformed)
+	}
+	report.markdownBreakdown(&b, "By original language", func(site siteCoverage) map[string]coverage { return site.ByLanguage })
+	report.markdownBreakdown(&b, "By content rating", func(site siteCoverage) map[string]coverage { return site.ByContentRating })
+
+	fmt.Fprintf(&b, "\n## Unresolved MangaUpdates links\n\n%d manga have a MangaUpdates link without a new id.\n\n", report.unresolvedTotal())
+	b.WriteString("| Last lookup | Manga |\n|---|---:|\n")
+	for _, outcome := range slices.Sorted(maps.Keys(report.UnresolvedMangaUpdates)) {
+		fmt.Fprintf(&b, "| %s | %d |\n", outcome, report.UnresolvedMangaUpdates[outcome])
+	}
+	return b.String()
+}
+
+// breakdownGroups returns the groups of a breakdown, which every site shares, and their names
+// with the most manga first. Only called with at least one site.
+func (report mappingReport) breakdownGroups(groupsOf func(siteCoverage) map[string]coverage) (map[string]coverage, []string) {
+	groups := groupsOf(report.Sites[0])
+	names := slices.SortedFunc(maps.Keys(groups), func(a, c string) int {
+		if groups[a].Total != groups[c].Total {
+			return groups[c].Total - groups[a].Total
+		}
+		return strings.Compare(a, c)
+	})
+	return groups, names
+}
+
+// markdownBreakdown writes a table of the coverage percentage of each group and site, the
+// groups with the most manga first.
+func (report mappingReport) markdownBreakdown(b *strings.Builder, title string, groupsOf func(siteCoverage) map[string]coverage) {
+	if len(report.Sites) == 0 {
+		return
+	}
+	groups, names := report.breakdownGroups(groupsOf)
+	fmt.Fprintf(b, "\n## %s\n\n| | Manga |", title)
+	for _, site := range report.Sites {
+		fmt.Fprintf(b, " %s |", site.Site)
+	}
+	b.WriteString("\n|---|---:|" + strings.Repeat("---:|", len(report.Sites)) + "\n")
+	for _, name := range names {
+		fmt.Fprintf(b, "| %s | %d |", name, groups[name].Total)
+		for _, site := range report.Sites {
+			fmt.Fprintf(b, " %.1f%% |", groupsOf(site)[name].Percent)
+		}
+		b.WriteString("\n")
+	}
+}
@@ -0,0 +1,73 @@
+package calculate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+func TestGetMappingReport(t *testing.T) {
+	setupMappingsTest(t,
+		internal.Manga{Id: "uuid-1", OriginalLanguage: "ja", ContentRating: "safe", Links: map[string]string{"mu": "abc"}},
+		internal.Manga{Id: "uuid-2", OriginalLanguage: "ja", ContentRating: "suggestive", Links: map[string]string{"mu": "def"}},
+		internal.Manga{Id: "uuid-3", OriginalLanguage: "ko", ContentRating: "safe"},
+		internal.Manga{Id: "uuid-4", ContentRating: "safe"},
+	)
+	for _, insert := range []string{
+		"INSERT INTO " + internal.TableAnilist + " (UUID, ID) VALUES ('uuid-1', '1'), ('uuid-3', '1')",
+		"INSERT INTO " + internal.TableMangaupdatesNewId + " (UUID, ID) VALUES ('uuid-1', '100')",
+		"INSERT INTO " + internal.TableMappingConflicts + " (SITE, ID, UUID, CANONICAL) VALUES ('al', '1', 'uuid-1', 1), ('al', '1', 'uuid-3', 0)",
+		"INSERT INTO " + internal.TableLinkIssues + " (SITE, UUID, VALUE, FIXED, ERROR) VALUES ('mal', 'uuid-4', 'x', '', 'invalid'), ('al', 'uuid-3', ' 1', '1', '')",
+	} {
+		if _, err := internal.DB.Exec(insert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordResolveAttempt("mu_new", "uuid-2", "def", outcomeNotFound, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := getMappingReport(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalManga != 4 {
+		t.Errorf("expected 4 manga, got %d", report.TotalManga)
+	}
+	sites := make(map[string]siteCoverage)
+	for _, site := range report.Sites {
+		sites[site.Site] = site
+	}
+	anilist := sites["al"]
+	if anilist.Coverage != (coverage{Mapped: 2, Total: 4, Percent: 50}) || anilist.Conflicts != 1 || anilist.Malformed != 0 {
+		t.Errorf("unexpected anilist coverage %+v", anilist)
+	}
+	if anilist.ByLanguage["ja"] != (coverage{Mapped: 1, Total: 2, Percent: 50}) || anilist.ByLanguage["unknown"].Total != 1 {
+		t.Errorf("unexpected anilist coverage by language %+v", anilist.ByLanguage)
+	}
+	if anilist.ByContentRating["safe"] != (coverage{Mapped: 2, Total: 3, Percent: 66.67}) {
+		t.Errorf("unexpected anilist coverage by content rating %+v", anilist.ByContentRating)
+	}
+	if sites["mal"].Malformed != 1 {
+		t.Errorf("expected one malformed mal link, got %d", sites["mal"].Malformed)
+	}
+	if len(report.UnresolvedMangaUpdates) != 1 || report.UnresolvedMangaUpdates["not_found"] != 1 {
+		t.Errorf("unexpected unresolved MangaUpdates links %v", report.UnresolvedMangaUpdates)
+	}
+
+	markdown := report.markdown()
+	for _, want := range []string{"| AniList (`al`) | 2 | 50.00% | 1 | 0 |", "| ja | 2 |", "| not_found | 1 |"} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("expected the markdown report to contain %q:\n%s", want, markdown)
+		}
+	}
+
+	text := report.text()
+	for _, want := range []string{"By original language", "By content rating", "ja                         2", " 50.0%"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected the text report to contain %q:\n%s", want, text)
+		}
+	}
+}
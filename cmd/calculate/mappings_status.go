@@ -0,0 +1,90 @@
+package calculate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var mappingsStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Summarise the external links still waiting to be resolved",
+	Long:  "Summarise the links of every resolver, such as MangaUpdates and Kitsu, by outcome of their last lookup and when they are retried",
+	RunE:  runMappingsStatus,
+}
+
+func init() {
+	mappingsCmd.AddCommand(mappingsStatusCmd)
+}
+
+// resolveBacklog counts the links of a resolver by the outcome of their last lookup.
+type resolveBacklog struct {
+	Resolved int
+	// Failed is the number of links per outcome of their last, failed, lookup
+	Failed map[resolveOutcome]int
+	// Due is how many failed links the next run looks up again, Waiting how many it skips
+	Due       int
+	Waiting   int
+	NextRetry time.Time
+	// NeverAttempted is the number of manga with a link which was never looked up
+	NeverAttempted int
+}
+
+func runMappingsStatus(cmd *cobra.Command, args []string) error {
+	now := time.Now()
+	for _, resolver := range newResolvers(resolverOptions{client: newResolverClient(), kitsuUrl: KitsuBaseUrl}) {
+		backlog, err := getResolveBacklog(resolver, now)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s\n", resolver.Site().Name)
+		fmt.Printf("  resolved: %d\n", backlog.Resolved)
+		fmt.Printf("  never looked up: %d\n", backlog.NeverAttempted)
+		for _, outcome := range []resolveOutcome{outcomeNotFound, outcomeBadId, outcomeNetworkError} {
+			fmt.Printf("  failed with %s: %d\n", outcome, backlog.Failed[outcome])
+		}
+		fmt.Printf("  failed links due for a retry: %d, waiting: %d\n", backlog.Due, backlog.Waiting)
+		if backlog.Waiting > 0 {
+			fmt.Printf("  next retry at %s\n", backlog.NextRetry.Local().Format(time.DateTime))
+		}
+	}
+	return nil
+}
+
+// getResolveBacklog counts the recorded lookups of a resolver and the manga whose link was
+// never looked up.
+func getResolveBacklog(resolver Resolver, now time.Time) (resolveBacklog, error) {
+	site := resolver.Site()
+	backlog := resolveBacklog{Failed: make(map[resolveOutcome]int)}
+	attempts, err := getResolveAttempts(site.Key)
+	if err != nil {
+		return backlog, err
+	}
+	for _, attempt := range attempts {
+		if attempt.Outcome == outcomeResolved {
+			backlog.Resolved++
+			continue
+		}
+		backlog.Failed[attempt.Outcome]++
+		if !attempt.waiting(now) {
+			backlog.Due++
+			continue
+		}
+		backlog.Waiting++
+		if backlog.NextRetry.IsZero() || attempt.NextRetry.Before(backlog.NextRetry) {
+			backlog.NextRetry = attempt.NextRetry
+		}
+	}
+
+	// Ids resolved before attempts were recorded are in the site table only
+	err = internal.DB.QueryRow("SELECT COUNT(*) FROM "+internal.TableManga+" WHERE COALESCE(json_extract(JSON, '$.links.' || ?), '') != ''"+
+		" AND UUID NOT IN (SELECT UUID FROM "+internal.TableResolveAttempts+" WHERE SITE = ?)"+
+		" AND UUID NOT IN (SELECT UUID FROM "+site.Table+")", resolver.LinkKey(), site.Key).Scan(&backlog.NeverAttempted)
+	if err != nil {
+		return backlog, fmt.Errorf("count unattempted %s links: %w", site.Name, err)
+	}
+	return backlog, nil
+}
@@ -0,0 +1,285 @@
+package calculate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
+)
+
+// setupMappingsTest stores the manga in an empty database and moves into a temporary
+// directory for the exported mapping files.
+func setupMappingsTest(t *testing.T, manga ...internal.Manga) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	originalDB := internal.DB
+	internal.DB = db
+	t.Cleanup(func() {
+		internal.DB = originalDB
+		db.Close()
+	})
+
+	if _, err := db.Exec("CREATE TABLE " + internal.TableManga + " (UUID TEXT PRIMARY KEY, JSON TEXT, DATE TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := internal.Migrate(db, internal.DataMigrations); err != nil {
+		t.Fatal(err)
+	}
+	for _, site := range internal.MappingSites {
+		if _, err := db.Exec("CREATE TABLE IF NOT EXISTS " + site.Table + " (UUID TEXT PRIMARY KEY, ID TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertMappingManga(t, "2024-01-01T00:00:00", manga...)
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(workDir) })
+}
+
+// insertMappingManga stores the manga as first seen at date.
+func insertMappingManga(t *testing.T, date string, manga ...internal.Manga) {
+	for _, m := range manga {
+		jsonManga, _ := json.Marshal(m)
+		if _, err := internal.DB.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?)", m.Id, jsonManga, date); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readMappingFile(t *testing.T, name string) []string {
+	data, err := os.ReadFile(filepath.Join("data", "mappings", name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
+
+func TestRunMappingsExportsLinkUrls(t *testing.T) {
+	setupMappingsTest(t,
+		internal.Manga{Id: "uuid-1", Links: map[string]string{"al": "30013", "amz": "https://amazon.co.jp/Title/dp/4088725093/ref=sr_1_1?qid=1"}},
+		internal.Manga{Id: "uuid-2", Links: map[string]string{"engtl": "https://www.viz.com/one-piece?utm_source=x", "raw": "not a url"}},
+	)
+	mappingsCmd.SetContext(context.Background())
+	if err := runMappings(mappingsCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readMappingFile(t, "anilist2mdex"); len(got) != 1 || got[0] != "30013:::||@!@||:::uuid-1" {
+		t.Errorf("unexpected anilist mappings %v", got)
+	}
+	if got := readMappingFile(t, "amazon2mdex"); len(got) != 1 || got[0] != "https://www.amazon.co.jp/dp/4088725093:::||@!@||:::uuid-1" {
+		t.Errorf("unexpected amazon mappings %v", got)
+	}
+	if got := readMappingFile(t, "engtl2mdex"); len(got) != 1 || got[0] != "https://www.viz.com/one-piece:::||@!@||:::uuid-2" {
+		t.Errorf("unexpected engtl mappings %v", got)
+	}
+	if got := readMappingFile(t, "raw2mdex"); len(got) != 0 {
+		t.Errorf("expected the invalid raw link to be skipped, got %v", got)
+	}
+}
+
+func TestRunMappingsResolvesConflicts(t *testing.T) {
+	title := func(en string) *map[string]string { return &map[string]string{"en": en} }
+	setupMappingsTest(t)
+	// The oldest entry is the colored variant, so it loses to the next oldest
+	insertMappingManga(t, "2023-01-01T00:00:00", internal.Manga{Id: "uuid-a", Title: title("Solo Leveling (Full Color)"), Links: map[string]string{"al": "105398"}, CreatedAt: "2019-05-01T10:00:00+00:00"})
+	// Stored in the opposite order of their creation on MangaDex
+	insertMappingManga(t, "2024-01-02T00:00:00", internal.Manga{Id: "uuid-b", Title: title("Solo Leveling"), Links: map[string]string{"al": "105398", "mal": "121496"}, CreatedAt: "2020-05-01T10:00:00+00:00"})
+	insertMappingManga(t, "2024-01-01T00:00:00", internal.Manga{Id: "uuid-c", Title: title("Solo Leveling (Re-upload)"), Links: map[string]string{"al": "105398"}, CreatedAt: "2021-05-01T10:00:00+00:00"})
+
+	mappingsCmd.SetContext(context.Background())
+	if err := runMappings(mappingsCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"105398:::||@!@||:::uuid-a:::||@!@||:::duplicate",
+		"105398:::||@!@||:::uuid-b",
+		"105398:::||@!@||:::uuid-c:::||@!@||:::duplicate",
+	}
+	if got := readMappingFile(t, "anilist2mdex"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected anilist mappings %v", got)
+	}
+	if got := readMappingFile(t, "myanimelist2mdex"); len(got) != 1 || strings.Contains(got[0], "duplicate") {
+		t.Errorf("expected the unique mapping to be left alone, got %v", got)
+	}
+
+	data, err := os.ReadFile(conflictsReportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report []mappingConflict
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || report[0].Site != "al" || report[0].Id != "105398" || report[0].Canonical != "uuid-b" || len(report[0].Entries) != 3 {
+		t.Fatalf("unexpected conflicts report %+v", report)
+	}
+	if !report[0].Entries[0].Variant || report[0].Entries[0].Title != "Solo Leveling (Full Color)" {
+		t.Errorf("expected the colored entry to be flagged as a variant, got %+v", report[0].Entries[0])
+	}
+}
+
+func TestChooseCanonical(t *testing.T) {
+	tests := []struct {
+		entries []conflictEntry
+		want    string
+	}{
+		{[]conflictEntry{{UUID: "b", CreatedAt: "2020"}, {UUID: "a", CreatedAt: "2021"}}, "b"},
+		{[]conflictEntry{{UUID: "b", CreatedAt: "2020"}, {UUID: "a", CreatedAt: "2020"}}, "a"},
+		{[]conflictEntry{{UUID: "a", CreatedAt: "2019", Variant: true}, {UUID: "b", CreatedAt: "2021"}}, "b"},
+		{[]conflictEntry{{UUID: "a"}, {UUID: "b", CreatedAt: "2021"}}, "b"},
+	}
+	for _, tt := range tests {
+		if got := chooseCanonical(tt.entries); got != tt.want {
+			t.Errorf("chooseCanonical(%+v) = %s, want %s", tt.entries, got, tt.want)
+		}
+	}
+}
+
+func TestRunMappingsReportsLinkIssues(t *testing.T) {
+	setupMappingsTest(t,
+		internal.Manga{Id: "uuid-1", Links: map[string]string{"mal": "https://myanimelist.net/manga/13/One_Piece", "bw": "12345"}},
+		internal.Manga{Id: "uuid-2", Links: map[string]string{"al": "not-an-id", "ap": "one-piece"}},
+	)
+	// A bad link stored by an earlier run is dropped once rejected
+	if _, err := internal.DB.Exec("INSERT INTO "+internal.TableAnilist+" (UUID, ID) VALUES (?, ?)", "uuid-2", "not-an-id"); err != nil {
+		t.Fatal(err)
+	}
+
+	mappingsCmd.SetContext(context.Background())
+	if err := runMappings(mappingsCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readMappingFile(t, "myanimelist2mdex"); len(got) != 1 || got[0] != "13:::||@!@||:::uuid-1" {
+		t.Errorf("unexpected myanimelist mappings %v", got)
+	}
+	if got := readMappingFile(t, "bookwalker2mdex"); len(got) != 1 || got[0] != "series/12345:::||@!@||:::uuid-1" {
+		t.Errorf("unexpected bookwalker mappings %v", got)
+	}
+	if got := readMappingFile(t, "anilist2mdex"); len(got) != 0 {
+		t.Errorf("expected the rejected anilist link to be removed, got %v", got)
+	}
+
+	data, err := os.ReadFile(linkIssuesReportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Rejected []linkIssue `json:"rejected"`
+		Fixed    []linkIssue `json:"fixed"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rejected) != 1 || report.Rejected[0].Site != "al" || report.Rejected[0].Value != "not-an-id" {
+		t.Errorf("unexpected rejected links %+v", report.Rejected)
+	}
+	if len(report.Fixed) != 2 {
+		t.Errorf("expected the mal and bw links to be fixed, got %+v", report.Fixed)
+	}
+	var count int
+	if err := internal.DB.QueryRow("SELECT COUNT(*) FROM " + internal.TableLinkIssues).Scan(&count); err != nil || count != 3 {
+		t.Errorf("expected 3 recorded link issues, got %d %v", count, err)
+	}
+}
+
+func TestLoadConflictEntryTitleFallback(t *testing.T) {
+	setupMappingsTest(t)
+	insertMappingManga(t, "2024-01-01T00:00:00", internal.Manga{Id: "uuid-a", Title: &map[string]string{"ko": "나 혼자만 레벨업", "ja": "俺だけレベルアップな件", "zh": ""}})
+
+	// Without an english title the first language code wins, whatever the map order
+	for range 10 {
+		entry := conflictEntry{UUID: "uuid-a"}
+		if err := loadConflictEntry(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Title != "俺だけレベルアップな件" {
+			t.Fatalf("expected the japanese title, got %q", entry.Title)
+		}
+	}
+}
+
+func TestPurgedMangaLeavesEveryExport(t *testing.T) {
+	setupMappingsTest(t,
+		internal.Manga{Id: "uuid-1", Title: &map[string]string{"en": "Removed"}, Links: map[string]string{"al": "1", "mal": "bad id"}},
+		internal.Manga{Id: "uuid-2", Title: &map[string]string{"en": "Kept"}, Links: map[string]string{"al": "1"}},
+	)
+	similarRows := map[string]internal.SimilarManga{
+		"uuid-1": {Id: "uuid-1", SimilarMatches: []internal.SimilarMatch{{Id: "uuid-2"}}},
+		"uuid-2": {Id: "uuid-2", SimilarMatches: []internal.SimilarMatch{{Id: "uuid-1"}, {Id: "uuid-3"}}},
+	}
+	for uuid, row := range similarRows {
+		data, _ := json.Marshal(row)
+		if _, err := internal.DB.Exec("INSERT INTO "+internal.TableSimilar+" (UUID, JSON) VALUES (?, ?)", uuid, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mappingsCmd.SetContext(context.Background())
+	if err := runMappings(mappingsCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := calculateInbound(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := internal.PurgeManga(tx, "uuid-1", internal.NewMappingRun("test")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Export everything again without recalculating the similar matches
+	if err := runMappings(mappingsCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportSimilar(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportInbound(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	exported := 0
+	err = filepath.WalkDir("data", func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		exported++
+		if strings.Contains(string(data), "uuid-1") {
+			t.Errorf("expected the purged manga to be gone from %s:\n%s", path, data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported == 0 {
+		t.Fatal("expected exported files")
+	}
+}
@@ -0,0 +1,95 @@
+package calculate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+// attemptTimeFormat is how attempt times are stored, sortable as text.
+const attemptTimeFormat = time.RFC3339
+
+// retryBackoff is the wait before the first retry of a failed lookup, doubled for each
+// failure in a row up to maxRetryBackoff. Network errors are retried sooner than answers
+// from the site, which are unlikely to change soon.
+var retryBackoff = map[resolveOutcome]time.Duration{
+	outcomeNotFound:     7 * 24 * time.Hour,
+	outcomeBadId:        7 * 24 * time.Hour,
+	outcomeNetworkError: time.Hour,
+}
+
+const maxRetryBackoff = 90 * 24 * time.Hour
+
+// resolveAttempt is the last lookup of a manga's link for a site.
+type resolveAttempt struct {
+	Link        string
+	Attempts    int
+	Outcome     resolveOutcome
+	LastAttempt time.Time
+	NextRetry   time.Time
+}
+
+// waiting reports whether a failed link is still waiting for its retry at now.
+func (a resolveAttempt) waiting(now time.Time) bool {
+	return a.Outcome != outcomeResolved && now.Before(a.NextRetry)
+}
+
+// nextRetry returns when a link which failed attempts times in a row is next looked up.
+func nextRetry(outcome resolveOutcome, attempts int, now time.Time) time.Time {
+	backoff, ok := retryBackoff[outcome]
+	if !ok {
+		return time.Time{}
+	}
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return now.Add(min(backoff, maxRetryBackoff))
+}
+
+// getResolveAttempts loads the last lookup of every manga for a site.
+func getResolveAttempts(site string) (map[string]resolveAttempt, error) {
+	rows, err := internal.DB.Query("SELECT UUID, LINK, ATTEMPTS, OUTCOME, LAST_ATTEMPT, NEXT_RETRY FROM "+internal.TableResolveAttempts+" WHERE SITE = ?", site)
+	if err != nil {
+		return nil, fmt.Errorf("query %s attempts: %w", site, err)
+	}
+	defer rows.Close()
+	attempts := make(map[string]resolveAttempt)
+	for rows.Next() {
+		var uuid, outcome, lastAttempt, nextRetry string
+		var attempt resolveAttempt
+		if err := rows.Scan(&uuid, &attempt.Link, &attempt.Attempts, &outcome, &lastAttempt, &nextRetry); err != nil {
+			return nil, fmt.Errorf("scan %s attempts: %w", site, err)
+		}
+		attempt.Outcome = resolveOutcome(outcome)
+		attempt.LastAttempt, _ = time.Parse(attemptTimeFormat, lastAttempt)
+		attempt.NextRetry, _ = time.Parse(attemptTimeFormat, nextRetry)
+		attempts[uuid] = attempt
+	}
+	return attempts, rows.Err()
+}
+
+// recordResolveAttempt stores the outcome of a lookup. Failures in a row of the same link push
+// the next retry further out, a success or a new link starts over.
+func recordResolveAttempt(site string, uuid string, link string, outcome resolveOutcome, now time.Time) error {
+	attempts := 1
+	var previousLink string
+	var previousAttempts int
+	var previousOutcome string
+	err := internal.DB.QueryRow("SELECT LINK, ATTEMPTS, OUTCOME FROM "+internal.TableResolveAttempts+" WHERE SITE = ? AND UUID = ?", site, uuid).Scan(&previousLink, &previousAttempts, &previousOutcome)
+	if err == nil && previousLink == link && resolveOutcome(previousOutcome) != outcomeResolved && outcome != outcomeResolved {
+		attempts = previousAttempts + 1
+	}
+
+	var retry string
+	if outcome != outcomeResolved {
+		retry = nextRetry(outcome, attempts, now).Format(attemptTimeFormat)
+	}
+	_, err = internal.DB.Exec("INSERT INTO "+internal.TableResolveAttempts+" (SITE, UUID, LINK, ATTEMPTS, OUTCOME, LAST_ATTEMPT, NEXT_RETRY) VALUES (?, ?, ?, ?, ?, ?, ?) "+
+		"ON CONFLICT (SITE, UUID) DO UPDATE SET LINK=excluded.LINK, ATTEMPTS=excluded.ATTEMPTS, OUTCOME=excluded.OUTCOME, LAST_ATTEMPT=excluded.LAST_ATTEMPT, NEXT_RETRY=excluded.NEXT_RETRY",
+		site, uuid, link, attempts, string(outcome), now.UTC().Format(attemptTimeFormat), retry)
+	if err != nil {
+		return fmt.Errorf("record %s attempt %s: %w", site, uuid, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package calculate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+func TestNextRetry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		outcome  resolveOutcome
+		attempts int
+		want     time.Duration
+	}{
+		{outcomeNetworkError, 1, time.Hour},
+		{outcomeNetworkError, 3, 4 * time.Hour},
+		{outcomeNotFound, 1, 7 * 24 * time.Hour},
+		{outcomeBadId, 2, 14 * 24 * time.Hour},
+		{outcomeNotFound, 10, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		if got := nextRetry(tt.outcome, tt.attempts, now).Sub(now); got != tt.want {
+			t.Errorf("nextRetry(%s, %d) waits %s, want %s", tt.outcome, tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRecordResolveAttemptBacksOff(t *testing.T) {
+	setupMappingsTest(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if err := recordResolveAttempt("mu_new", "uuid-1", "abc", outcomeNotFound, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Attempts of another site are counted apart
+	if err := recordResolveAttempt("kt_id", "uuid-1", "abc", outcomeNotFound, now); err != nil {
+		t.Fatal(err)
+	}
+	attempts, err := getResolveAttempts("mu_new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attempts["uuid-1"]; got.Attempts != 2 || !got.NextRetry.Equal(now.Add(14*24*time.Hour)) {
+		t.Errorf("unexpected attempt after two failures %+v", got)
+	}
+	if !attempts["uuid-1"].waiting(now.Add(24 * time.Hour)) {
+		t.Error("expected the failed link to wait for its retry")
+	}
+
+	// A new link starts over
+	if err := recordResolveAttempt("mu_new", "uuid-1", "def", outcomeNetworkError, now); err != nil {
+		t.Fatal(err)
+	}
+	attempts, err = getResolveAttempts("mu_new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attempts["uuid-1"]; got.Attempts != 1 || got.Outcome != outcomeNetworkError || !got.NextRetry.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected attempt after the link changed %+v", got)
+	}
+}
+
+func TestGetResolveBacklog(t *testing.T) {
+	setupMappingsTest(t,
+		internal.Manga{Id: "uuid-1", Links: map[string]string{"mu": "abc"}},
+		internal.Manga{Id: "uuid-2", Links: map[string]string{"mu": "def"}},
+		internal.Manga{Id: "uuid-3", Links: map[string]string{"mu": "ghi"}},
+		internal.Manga{Id: "uuid-4", Links: map[string]string{"mu": "jkl"}},
+		internal.Manga{Id: "uuid-5", Links: map[string]string{"al": "1"}},
+	)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for uuid, outcome := range map[string]resolveOutcome{"uuid-1": outcomeResolved, "uuid-2": outcomeNotFound, "uuid-3": outcomeNetworkError} {
+		if err := recordResolveAttempt("mu_new", uuid, "link", outcome, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backlog, err := getResolveBacklog(&mangaUpdatesResolver{}, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backlog.Resolved != 1 || backlog.NeverAttempted != 1 {
+		t.Errorf("expected 1 resolved and 1 never attempted, got %+v", backlog)
+	}
+	if backlog.Failed[outcomeNotFound] != 1 || backlog.Failed[outcomeNetworkError] != 1 {
+		t.Errorf("unexpected failures %v", backlog.Failed)
+	}
+	if backlog.Due != 1 || backlog.Waiting != 1 || !backlog.NextRetry.Equal(now.Add(7*24*time.Hour)) {
+		t.Errorf("expected the network error due and the not found waiting, got %+v", backlog)
+	}
+}
@@ -0,0 +1,378 @@
+package calculate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+	"go.uber.org/ratelimit"
+)
+
+// Resolver looks up the canonical id of an external site from the link a manga stores for it.
+// A failed lookup returns a *resolveError, its outcome decides when the link is retried. Any
+// other error is treated as a network error.
+type Resolver interface {
+	// Site is where the resolved ids are stored, one of internal.MappingSites
+	Site() internal.MappingSite
+	// LinkKey is the key of the manga link ids are resolved from
+	LinkKey() string
+	// FileName is the mapping file the resolved ids are exported to
+	FileName() string
+	// Resolve returns the canonical id the link of manga uuid points to
+	Resolve(ctx context.Context, uuid string, link string) (string, error)
+}
+
+// resolveOutcome is the result of resolving a link, recorded for every attempt.
+type resolveOutcome string
+
+const (
+	outcomeResolved resolveOutcome = "resolved"
+	// outcomeNotFound is a link the site has no entry for
+	outcomeNotFound resolveOutcome = "not_found"
+	// outcomeBadId is a link the site rejects outright, MangaUpdates answers these with a 503
+	outcomeBadId resolveOutcome = "bad_id"
+	// outcomeNetworkError is a lookup which failed before the site gave an answer
+	outcomeNetworkError resolveOutcome = "network_error"
+)
+
+// resolveError is a failed lookup.
+type resolveError struct {
+	outcome resolveOutcome
+	err     error
+}
+
+func (e *resolveError) Error() string { return e.err.Error() }
+
+func (e *resolveError) Unwrap() error { return e.err }
+
+// resolveFailure returns a failed lookup with the given outcome.
+func resolveFailure(outcome resolveOutcome, format string, args ...any) error {
+	return &resolveError{outcome: outcome, err: fmt.Errorf(format, args...)}
+}
+
+// outcomeOf returns the outcome of a lookup which returned err.
+func outcomeOf(err error) resolveOutcome {
+	if err == nil {
+		return outcomeResolved
+	}
+	var failure *resolveError
+	if errors.As(err, &failure) {
+		return failure.outcome
+	}
+	return outcomeNetworkError
+}
+
+// outcomeForStatus returns the outcome of an unexpected http status. Rate limits and server
+// errors are worth retrying soon, anything else means the site does not know the id.
+func outcomeForStatus(code int) resolveOutcome {
+	if code == http.StatusTooManyRequests || code >= 500 {
+		return outcomeNetworkError
+	}
+	return outcomeNotFound
+}
+
+// resolverOptions is what the resolvers of a run are created with.
+type resolverOptions struct {
+	client *resolverClient
+	// kitsuUrl is the Kitsu api slugs are resolved against
+	kitsuUrl string
+}
+
+var resolverRegistry []func(resolverOptions) Resolver
+
+// registerResolver adds a resolver to calculate mappings. Resolvers register themselves from
+// an init function and run in the order they were registered.
+func registerResolver(newResolver func(resolverOptions) Resolver) {
+	resolverRegistry = append(resolverRegistry, newResolver)
+}
+
+// newResolvers creates every registered resolver.
+func newResolvers(opts resolverOptions) []Resolver {
+	resolvers := make([]Resolver, 0, len(resolverRegistry))
+	for _, newResolver := range resolverRegistry {
+		resolvers = append(resolvers, newResolver(opts))
+	}
+	return resolvers
+}
+
+// defaultHostRate is the requests a second to hosts no resolver set a rate for.
+const defaultHostRate = 1
+
+// resolverClient sends the requests of every resolver, waiting on a rate limiter per host.
+type resolverClient struct {
+	mu       sync.Mutex
+	limiters map[string]ratelimit.Limiter
+}
+
+func newResolverClient() *resolverClient {
+	return &resolverClient{limiters: make(map[string]ratelimit.Limiter)}
+}
+
+// setRate allows perSecond requests a second to the hosts, shared between all of them.
+func (c *resolverClient) setRate(perSecond int, hosts ...string) {
+	limiter := newHostLimiter(perSecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, host := range hosts {
+		c.limiters[host] = limiter
+	}
+}
+
+func (c *resolverClient) limiter(host string) ratelimit.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	limiter, ok := c.limiters[host]
+	if !ok {
+		limiter = newHostLimiter(defaultHostRate)
+		c.limiters[host] = limiter
+	}
+	return limiter
+}
+
+// newHostLimiter allows perSecond requests a second, or any number when replaying.
+func newHostLimiter(perSecond int) ratelimit.Limiter {
+	if internal.HTTPTransport.Mode() == internal.HTTPModeReplay {
+		return ratelimit.NewUnlimited()
+	}
+	return ratelimit.New(perSecond)
+}
+
+// get waits for the rate limiter of the host then sends a GET request with the given headers.
+func (c *resolverClient) get(ctx context.Context, rawUrl string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if err := takeContext(ctx, c.limiter(req.URL.Host)); err != nil {
+		return nil, err
+	}
+	return httpClient.Do(req)
+}
+
+// hostOf returns the host of a base url, empty if it cannot be parsed.
+func hostOf(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// resolverWorkers is how many lookups run at once, across every resolver.
+const resolverWorkers = 16
+
+// resolverPool runs the lookups of every resolver on a bounded number of goroutines.
+type resolverPool struct {
+	guard chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newResolverPool(workers int) *resolverPool {
+	return &resolverPool{guard: make(chan struct{}, workers)}
+}
+
+// Go runs task once a worker is free. It reports false, without running the task, if ctx is
+// cancelled first.
+func (p *resolverPool) Go(ctx context.Context, task func()) bool {
+	select {
+	case p.guard <- struct{}{}:
+	case <-ctx.Done():
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.guard }()
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintf(os.Stderr, "Error: resolver task panicked: %v\n", r)
+			}
+		}()
+		task()
+	}()
+	return true
+}
+
+func (p *resolverPool) Wait() {
+	p.wg.Wait()
+}
+
+// resolverRun is the state of one resolver during a run.
+type resolverRun struct {
+	resolver Resolver
+	// mappingRun is recorded as the source of the ids resolved
+	mappingRun internal.MappingRun
+	attempts   map[string]resolveAttempt
+	// resolved holds the manga with a stored id
+	resolved map[string]bool
+
+	mu       sync.Mutex
+	outcomes map[resolveOutcome]int
+	deferred int
+	// dropped counts the stored ids closed because their link is gone or no longer resolves
+	dropped int
+	errs    []error
+}
+
+func newResolverRun(resolver Resolver, mappingRun internal.MappingRun) (*resolverRun, error) {
+	site := resolver.Site()
+	attempts, err := getResolveAttempts(site.Key)
+	if err != nil {
+		return nil, err
+	}
+	resolvedIds, err := getAllGenericFromTable(site.Table)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make(map[string]bool, len(resolvedIds))
+	for _, entry := range resolvedIds {
+		resolved[entry.UUID] = true
+	}
+	return &resolverRun{resolver: resolver, mappingRun: mappingRun, attempts: attempts, resolved: resolved, outcomes: make(map[resolveOutcome]int)}, nil
+}
+
+// due reports whether the link of a manga should be looked up, counting the failed links
+// which wait for their next retry.
+func (run *resolverRun) due(uuid string, link string, now time.Time) bool {
+	attempt, attempted := run.attempts[uuid]
+	if !attempted {
+		// Ids resolved before attempts were recorded are kept
+		return !run.resolved[uuid]
+	}
+	if attempt.Link != link {
+		return true
+	}
+	if attempt.Outcome == outcomeResolved {
+		return !run.resolved[uuid]
+	}
+	if attempt.waiting(now) {
+		run.deferred++
+		return false
+	}
+	return true
+}
+
+// resolve looks up one link, stores the id it resolved to and records the attempt.
+func (run *resolverRun) resolve(ctx context.Context, index int, total int, uuid string, link string) {
+	site := run.resolver.Site()
+	id, err := run.lookup(ctx, uuid, link)
+	// Cancellation is reported once for the whole run
+	if ctx.Err() != nil {
+		return
+	}
+	outcome := outcomeOf(err)
+	if err == nil {
+		fmt.Printf("%d/%d manga %s -> %s %s is %s\n", index+1, total, uuid, site.Name, link, id)
+		err = upsertResolvedId(site.Table, uuid, id, run.mappingRun)
+	} else {
+		fmt.Printf("%d/%d manga %s -> %s %s %s: %v\n", index+1, total, uuid, site.Name, link, outcome, err)
+		err = nil
+		// A stored id was resolved from an earlier link, it no longer holds
+		if run.resolved[uuid] {
+			run.drop(uuid)
+		}
+	}
+	if err == nil {
+		err = recordResolveAttempt(site.Key, uuid, link, outcome, time.Now())
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.outcomes[outcome]++
+	if err != nil {
+		run.errs = append(run.errs, err)
+	}
+}
+
+// drop closes the id stored for a manga, recording it in the mapping history.
+func (run *resolverRun) drop(uuid string) {
+	err := deleteResolvedId(run.resolver.Site().Table, uuid, run.mappingRun)
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if err != nil {
+		run.errs = append(run.errs, err)
+		return
+	}
+	run.dropped++
+}
+
+// lookup calls the resolver, turning a panic into a failed lookup so its attempt is still
+// recorded and retried like a network error.
+func (run *resolverRun) lookup(ctx context.Context, uuid string, link string) (id string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			id, err = "", fmt.Errorf("%s resolver panicked on %q: %v", run.resolver.Site().Name, link, r)
+		}
+	}()
+	return run.resolver.Resolve(ctx, uuid, link)
+}
+
+// runResolvers resolves the links of every manga with each resolver, sharing one pool of
+// workers, then exports the resolved ids. Once ctx is cancelled no more lookups are started,
+// ids resolved so far are kept and exported.
+func runResolvers(ctx context.Context, resolvers []Resolver, mangaList []internal.Manga, mappingRun internal.MappingRun) error {
+	start := time.Now()
+	runs := make([]*resolverRun, 0, len(resolvers))
+	for _, resolver := range resolvers {
+		run, err := newResolverRun(resolver, mappingRun)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+
+	fmt.Println("Resolving external ids")
+	pool := newResolverPool(resolverWorkers)
+	processed := 0
+	for index, manga := range mangaList {
+		if ctx.Err() != nil {
+			break
+		}
+		processed++
+		for _, run := range runs {
+			link := manga.Links[run.resolver.LinkKey()]
+			if link == "" {
+				// The link was removed from MangaDex since the id was resolved
+				if run.resolved[manga.Id] {
+					run.drop(manga.Id)
+				}
+				continue
+			}
+			if !run.due(manga.Id, link, start) {
+				continue
+			}
+			if !pool.Go(ctx, func() { run.resolve(ctx, index, len(mangaList), manga.Id, link) }) {
+				break
+			}
+		}
+	}
+	pool.Wait()
+
+	var errs []error
+	if ctx.Err() != nil {
+		fmt.Printf("Interrupted, looked up %d of %d manga, the rest were skipped\n", processed, len(mangaList))
+		errs = append(errs, fmt.Errorf("resolve external ids: %w", ctx.Err()))
+	}
+	// Export what was resolved even if some of the ids could not be saved
+	for _, run := range runs {
+		site := run.resolver.Site()
+		fmt.Printf("Exporting %s mapping file\n", site.Name)
+		errs = append(errs, run.errs...)
+		errs = append(errs, exportMapping(site.Table, run.resolver.FileName()))
+		fmt.Printf("%s: %d resolved, %d not found, %d bad ids, %d network errors, %d waiting for a retry, %d dropped\n", site.Name,
+			run.outcomes[outcomeResolved], run.outcomes[outcomeNotFound], run.outcomes[outcomeBadId], run.outcomes[outcomeNetworkError], run.deferred, run.dropped)
+	}
+	fmt.Printf("done resolving external ids (%.2f seconds)!\n", time.Since(start).Seconds())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,176 @@
+package calculate
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+// stubResolver resolves links found in ids, panics on the link "panic" and fails every other
+// link as not found.
+type stubResolver struct {
+	ids map[string]string
+
+	mu     sync.Mutex
+	looked []string
+}
+
+func (r *stubResolver) Site() internal.MappingSite {
+	site, _ := internal.MappingSiteByKey("mu_new")
+	return site
+}
+
+func (r *stubResolver) LinkKey() string { return "mu" }
+
+func (r *stubResolver) FileName() string { return "stub2mdex" }
+
+func (r *stubResolver) Resolve(ctx context.Context, uuid string, link string) (string, error) {
+	r.mu.Lock()
+	r.looked = append(r.looked, link)
+	r.mu.Unlock()
+	if link == "panic" {
+		panic("stub resolver broke")
+	}
+	if id, ok := r.ids[link]; ok {
+		return id, nil
+	}
+	return "", resolveFailure(outcomeNotFound, "%s not found", link)
+}
+
+func TestRunResolversSkipsResolvedAndWaitingLinks(t *testing.T) {
+	manga := []internal.Manga{
+		{Id: "uuid-1", Links: map[string]string{"mu": "new"}},
+		{Id: "uuid-2", Links: map[string]string{"mu": "waiting"}},
+		{Id: "uuid-3", Links: map[string]string{"mu": "resolved"}},
+		{Id: "uuid-4", Links: map[string]string{"mu": "missing"}},
+	}
+	setupMappingsTest(t, manga...)
+	if err := recordResolveAttempt("mu_new", "uuid-2", "waiting", outcomeNotFound, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// Resolved before attempts were recorded
+	if err := upsertResolvedId(internal.TableMangaupdatesNewId, "uuid-3", "3", internal.NewMappingRun("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &stubResolver{ids: map[string]string{"new": "1", "waiting": "2"}}
+	if err := runResolvers(context.Background(), []Resolver{resolver}, manga, internal.NewMappingRun("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(resolver.looked, ",") != "new,missing" && strings.Join(resolver.looked, ",") != "missing,new" {
+		t.Errorf("expected only the new and missing links to be looked up, got %v", resolver.looked)
+	}
+	if got := readMappingFile(t, "stub2mdex"); strings.Join(got, " ") != "1:::||@!@||:::uuid-1 3:::||@!@||:::uuid-3" {
+		t.Errorf("unexpected resolved mappings %v", got)
+	}
+	attempts, err := getResolveAttempts("mu_new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts["uuid-1"].Outcome != outcomeResolved || attempts["uuid-4"].Outcome != outcomeNotFound || attempts["uuid-2"].Attempts != 1 {
+		t.Errorf("unexpected attempts %+v", attempts)
+	}
+}
+
+func TestRunResolversDropsStaleIds(t *testing.T) {
+	manga := []internal.Manga{
+		{Id: "uuid-1", Links: map[string]string{}},
+		{Id: "uuid-2", Links: map[string]string{"mu": "changed"}},
+		{Id: "uuid-3", Links: map[string]string{"mu": "kept"}},
+	}
+	setupMappingsTest(t, manga...)
+	for _, stored := range []struct{ uuid, link, id string }{{"uuid-1", "removed", "1"}, {"uuid-2", "old", "2"}, {"uuid-3", "kept", "3"}} {
+		if err := upsertResolvedId(internal.TableMangaupdatesNewId, stored.uuid, stored.id, internal.NewMappingRun("test")); err != nil {
+			t.Fatal(err)
+		}
+		if err := recordResolveAttempt("mu_new", stored.uuid, stored.link, outcomeResolved, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The link of uuid-1 was removed and the new link of uuid-2 no longer resolves
+	resolver := &stubResolver{ids: map[string]string{}}
+	if err := runResolvers(context.Background(), []Resolver{resolver}, manga, internal.NewMappingRun("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readMappingFile(t, "stub2mdex"); strings.Join(got, " ") != "3:::||@!@||:::uuid-3" {
+		t.Errorf("expected only the id of the unchanged link kept, got %v", got)
+	}
+	var closed int
+	if err := internal.DB.QueryRow("SELECT COUNT(*) FROM "+internal.TableMappingHistory+" WHERE SITE = ? AND VALID_TO IS NOT NULL", "mu_new").Scan(&closed); err != nil {
+		t.Fatal(err)
+	}
+	if closed != 2 {
+		t.Errorf("expected the history of both dropped ids closed, got %d", closed)
+	}
+}
+
+func TestRunResolversRecordsPanics(t *testing.T) {
+	manga := []internal.Manga{
+		{Id: "uuid-1", Links: map[string]string{"mu": "panic"}},
+		{Id: "uuid-2", Links: map[string]string{"mu": "ok"}},
+	}
+	setupMappingsTest(t, manga...)
+	resolver := &stubResolver{ids: map[string]string{"ok": "2"}}
+	if err := runResolvers(context.Background(), []Resolver{resolver}, manga, internal.NewMappingRun("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, err := getResolveAttempts("mu_new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts["uuid-1"].Outcome != outcomeNetworkError || attempts["uuid-2"].Outcome != outcomeResolved {
+		t.Errorf("expected the panicking lookup to be recorded as a network error, got %+v", attempts)
+	}
+}
+
+func TestMangaUpdatesResolver(t *testing.T) {
+	setupMappingsTest(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path + "?" + r.URL.RawQuery {
+		// 1 is the new id of the base36 link "0000001"
+		case "/series/1?", "/series/1234?":
+			w.WriteHeader(http.StatusOK)
+		case "/series.html?id=503":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/series.html?id=77":
+			io.WriteString(w, `<div id="main_content"><div></div><div><div class="row no-gutters"><div class="col-12 p-2">`+
+				`<a href="https://api.mangaupdates.com/v1/series/4242/rss">RSS</a></div></div></div></div>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	resolver := &mangaUpdatesResolver{client: newResolverClient(), apiUrl: server.URL, siteUrl: server.URL}
+	resolver.client.setRate(1000, hostOf(server.URL))
+
+	tests := []struct {
+		link    string
+		id      string
+		outcome resolveOutcome
+	}{
+		{"0000001", "1", outcomeResolved},
+		{"1234", "1234", outcomeResolved},
+		{"series.html?id=1234", "1234", outcomeResolved},
+		// Unknown to the api, the legacy page links to the new id
+		{"series.html?id=77", "4242", outcomeResolved},
+		{"503", "", outcomeBadId},
+		{"no id", "", outcomeNotFound},
+	}
+	for _, tt := range tests {
+		id, err := resolver.Resolve(context.Background(), "uuid-1", tt.link)
+		if id != tt.id || outcomeOf(err) != tt.outcome {
+			t.Errorf("Resolve(%q) = %q, %s (%v), want %q, %s", tt.link, id, outcomeOf(err), err, tt.id, tt.outcome)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package calculate
 import (
 	"bufio"
 	"container/heap"
+	"context"
+	"errors"
 	"fmt"
 	"iter"
 	"log"
@@ -11,6 +13,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caneroj1/stemmer"
@@ -39,7 +42,7 @@ var (
 		Use:   "similar",
 		Short: "This updates the similar calculations",
 		Long:  `Calculate and update the similar generations for manga entries`,
-		Run:   runSimilar,
+		RunE:  runSimilar,
 	}
 	cachedStopWords []string
 )
@@ -60,7 +63,7 @@ func init() {
 	}
 }
 
-func runSimilar(cmd *cobra.Command, args []string) {
+func runSimilar(cmd *cobra.Command, args []string) error {
 	debugMode, _ := cmd.Flags().GetBool("debug")
 	skippedMode, _ := cmd.Flags().GetBool("skipped")
 	exportOnly, _ := cmd.Flags().GetBool("export")
@@ -69,29 +72,48 @@ func runSimilar(cmd *cobra.Command, args []string) {
 
 	if !exportOnly {
 		fmt.Printf("\nBegin calculating similars\n")
-		calculateSimilars(debugMode, skippedMode, threads, verbose)
+		if err := calculateSimilars(cmd.Context(), debugMode, skippedMode, threads, verbose); err != nil {
+			if errors.Is(err, context.Canceled) {
+				fmt.Println("Similar results calculated so far are kept in the database, export them with --export")
+			}
+			return err
+		}
 	}
 
 	if !debugMode {
 		startProcessing := time.Now()
 		fmt.Printf("Exporting All Similar to txt files\n")
-		exportSimilar()
+		if err := exportSimilar(); err != nil {
+			return err
+		}
 		fmt.Printf("Exporting similarities took %s\n\n", time.Since(startProcessing))
+
+		startProcessing = time.Now()
+		fmt.Printf("Building recommended from index\n")
+		if err := calculateInbound(); err != nil {
+			return err
+		}
+		if err := exportInbound(time.Now()); err != nil {
+			return err
+		}
+		fmt.Printf("Exporting recommended from index took %s\n\n", time.Since(startProcessing))
 	}
+	return nil
 }
 
-func calculateSimilars(debugMode bool, skippedMode bool, threads int, verbose bool) {
+func calculateSimilars(ctx context.Context, debugMode bool, skippedMode bool, threads int, verbose bool) error {
 	startProcessing := time.Now()
 	allManga := internal.StreamAllManga()
 
 	if !debugMode {
-		DeleteSimilarDB()
+		if err := DeleteSimilarDB(); err != nil {
+			return err
+		}
 	}
 
 	data, err := prepareSimilarityData(allManga)
 	if err != nil {
-		fmt.Printf("Preparation failed: %v\n", err)
-		return
+		return fmt.Errorf("prepare similarity data: %w", err)
 	}
 
 	config := processingConfig{
@@ -102,9 +124,12 @@ func calculateSimilars(debugMode bool, skippedMode bool, threads int, verbose bo
 		threads:       threads,
 	}
 
-	runConcurrentProcessing(data, config)
+	if err := runConcurrentProcessing(ctx, data, config); err != nil {
+		return err
+	}
 
 	fmt.Printf("\nCalculated similarities for %d Manga in %s\n\n", len(data.MangaList), time.Since(startProcessing))
+	return nil
 }
 
 func prepareSimilarityData(allManga iter.Seq[internal.Manga]) (*SimilarityData, error) {
@@ -343,23 +368,39 @@ type processingConfig struct {
 	threads       int
 }
 
-func runConcurrentProcessing(data *SimilarityData, config processingConfig) {
+// runConcurrentProcessing calculates every manga on a pool of workers. A failed manga does not
+// stop the others, all failures are returned together once the pool drains. Once ctx is
+// cancelled the workers finish the manga they are on and skip the rest.
+func runConcurrentProcessing(ctx context.Context, data *SimilarityData, config processingConfig) error {
 	mangaCount := len(data.MangaList)
 	jobs := make(chan int, mangaCount)
 	progressChan := make(chan struct{}, mangaCount)
 	var wg sync.WaitGroup
 
+	var errMu sync.Mutex
+	var errs []error
+	var skipped atomic.Int64
 	for w := 0; w < config.threads; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for idx := range jobs {
-				processManga(idx, data, config, progressChan)
+				if ctx.Err() != nil {
+					skipped.Add(1)
+					continue
+				}
+				if err := processManga(idx, data, config, progressChan); err != nil {
+					errMu.Lock()
+					errs = append(errs, err)
+					errMu.Unlock()
+				}
 			}
 		}()
 	}
 
+	progressDone := make(chan struct{})
 	go func() {
+		defer close(progressDone)
 		processed := 0
 		startTime := time.Now()
 		for range progressChan {
@@ -383,19 +424,26 @@ func runConcurrentProcessing(data *SimilarityData, config processingConfig) {
 	close(jobs)
 	wg.Wait()
 	close(progressChan)
+	<-progressDone
+
+	if ctx.Err() != nil {
+		fmt.Printf("Interrupted, skipped %d of %d manga\n", skipped.Load(), mangaCount)
+		errs = append(errs, fmt.Errorf("calculate similar: %w", ctx.Err()))
+	}
+	return errors.Join(errs...)
 }
 
-func processManga(idx int, data *SimilarityData, config processingConfig, progress chan<- struct{}) {
+func processManga(idx int, data *SimilarityData, config processingConfig, progress chan<- struct{}) error {
 	defer func() { progress <- struct{}{} }()
 
 	current := data.MangaList[idx]
 	if config.debugMode {
 		if _, ok := config.debugMangaIds[current.Id]; !ok {
-			return
+			return nil
 		}
 	}
 	if data.CorpusDescLength[idx] < MinDescriptionWords {
-		return
+		return nil
 	}
 
 	vTag := data.TagVectors[idx]
@@ -463,7 +511,7 @@ func processManga(idx int, data *SimilarityData, config processingConfig, progre
 	}
 
 	if h.Len() == 0 {
-		return
+		return nil
 	}
 
 	simData := internal.SimilarManga{
@@ -476,9 +524,11 @@ func processManga(idx int, data *SimilarityData, config processingConfig, progre
 		m := heap.Pop(h).(customMatch)
 		target := data.MangaList[m.ID]
 		match := internal.SimilarMatch{
-			Id:        target.Id,
-			Score:     float32(m.Distance / (TagScoreRatio + 1.0)),
-			Languages: target.AvailableTranslatedLanguages,
+			Id:            target.Id,
+			ContentRating: target.ContentRating,
+			Score:         float32(m.Distance / (TagScoreRatio + 1.0)),
+			Languages:     target.AvailableTranslatedLanguages,
+			CoverUrl:      target.CoverUrl(),
 		}
 		if target.Title != nil {
 			match.Title = *target.Title
@@ -487,8 +537,9 @@ func processManga(idx int, data *SimilarityData, config processingConfig, progre
 	}
 
 	if !config.debugMode {
-		InsertSimilarData(simData)
+		return InsertSimilarData(simData)
 	}
+	return nil
 }
 
 func invalidForProcessing(match customMatch, currentIdx int, current, target internal.Manga) (bool, string) {
@@ -540,66 +591,82 @@ func (h *MatchMinHeap) Pop() interface{} {
 	return x
 }
 
-func exportSimilar() {
-	if err := os.RemoveAll("data/similar/"); err != nil {
-		log.Printf("Warning: failed to remove similar dir: %v", err)
+func exportSimilar() error {
+	similarList, err := getDBSimilar()
+	if err != nil {
+		return err
+	}
+	return exportSharded("data/similar/", similarList)
+}
+
+// exportSharded writes UUID keyed json rows into two level folders (first two and three
+// characters of the UUID) so that a consumer only needs to fetch a small file per lookup.
+func exportSharded(baseDir string, rowList []internal.DbSimilar) error {
+	if err := os.RemoveAll(baseDir); err != nil {
+		log.Printf("Warning: failed to remove %s dir: %v", baseDir, err)
 	}
-	if err := os.MkdirAll("data/similar/", 0755); err != nil {
-		log.Fatal(err)
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return fmt.Errorf("create %s: %w", baseDir, err)
 	}
-	similarList := getDBSimilar()
 
 	var currentFile *os.File
 	var writer *bufio.Writer
 	var currentSuffix string
 	var currentFolder string
 
-	for _, sim := range similarList {
+	for _, sim := range rowList {
 		if len(sim.Id) < 3 {
 			continue
 		}
-		folder := "data/similar/" + sim.Id[0:2]
+		folder := baseDir + sim.Id[0:2]
 		suffix := sim.Id[0:3]
 
 		if folder != currentFolder {
 			if err := os.MkdirAll(folder, 0755); err != nil {
-				log.Fatal(err)
+				closeShard(currentFile)
+				return fmt.Errorf("create %s: %w", folder, err)
 			}
 			currentFolder = folder
 		}
 
 		if suffix != currentSuffix {
-			if writer != nil {
-				if err := writer.Flush(); err != nil {
-					log.Fatal(err)
-				}
-			}
-			if currentFile != nil {
-				if err := currentFile.Close(); err != nil {
-					log.Fatal(err)
-				}
+			if err := flushShard(writer, currentFile); err != nil {
+				return err
 			}
 			f, err := os.OpenFile(folder+"/"+suffix+".html", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 			if err != nil {
-				log.Fatal(err)
+				return fmt.Errorf("create shard %s: %w", suffix, err)
 			}
 			currentFile = f
 			writer = bufio.NewWriter(currentFile)
 			currentSuffix = suffix
 		}
 		if _, err := writer.WriteString(sim.Id + ":::||@!@||:::" + sim.JSON + "\n"); err != nil {
-			log.Fatal(err)
+			closeShard(currentFile)
+			return fmt.Errorf("write shard %s: %w", currentSuffix, err)
 		}
 	}
-	if writer != nil {
-		if err := writer.Flush(); err != nil {
-			log.Fatal(err)
-		}
+	return flushShard(writer, currentFile)
+}
+
+// flushShard flushes and closes the shard being written, if any.
+func flushShard(writer *bufio.Writer, file *os.File) error {
+	if file == nil {
+		return nil
 	}
-	if currentFile != nil {
-		if err := currentFile.Close(); err != nil {
-			log.Fatal(err)
-		}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("flush shard %s: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close shard %s: %w", file.Name(), err)
+	}
+	return nil
+}
+
+func closeShard(file *os.File) {
+	if file != nil {
+		file.Close()
 	}
 }
 
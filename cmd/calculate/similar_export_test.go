@@ -55,7 +55,9 @@ func TestExportSimilar(t *testing.T) {
 	}
 	stmt.Close()
 
-	exportSimilar()
+	if err := exportSimilar(); err != nil {
+		t.Fatal(err)
+	}
 
 	// Verify files
 	expectedFiles := map[string][]string{
@@ -133,7 +135,9 @@ func BenchmarkExportSimilar(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		exportSimilar()
+		if err := exportSimilar(); err != nil {
+			b.Fatal(err)
+		}
 	}
 	b.StopTimer()
 	os.RemoveAll("data/similar/")
@@ -0,0 +1,59 @@
+package inbound
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var inboundCmd = &cobra.Command{
+	Use:   "inbound <uuid>",
+	Short: "Print the manga which recommend the given manga",
+	Long:  `Print the seeds whose similar list contains the given manga and the rank it holds there`,
+	Args:  cmd.UsageArgs(cobra.ExactArgs(1)),
+	RunE:  runInbound,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(inboundCmd)
+	cmd.UsesDatabase(inboundCmd, cmd.DatabaseReadOnly)
+}
+
+func runInbound(command *cobra.Command, args []string) error {
+	uuid := args[0]
+	inbound, found, err := getInbound(uuid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		fmt.Printf("%s is not recommended from any manga\n", uuid)
+		return nil
+	}
+
+	fmt.Printf("%s is recommended from %d manga\n", uuid, len(inbound.Inbound))
+	for _, match := range inbound.Inbound {
+		fmt.Printf("  #%-2d %s (%.4f) %s\n", match.Rank, match.Id, match.Score, match.Title["en"])
+	}
+	return nil
+}
+
+func getInbound(uuid string) (internal.InboundManga, bool, error) {
+	inbound := internal.InboundManga{}
+	var jsonInbound []byte
+	err := internal.DB.QueryRow("SELECT JSON FROM "+internal.TableSimilarInbound+" WHERE UUID = ?", uuid).Scan(&jsonInbound)
+	if errors.Is(err, sql.ErrNoRows) {
+		return inbound, false, nil
+	}
+	if err != nil {
+		return inbound, false, fmt.Errorf("query inbound %s: %w", uuid, err)
+	}
+	if err := json.Unmarshal(jsonInbound, &inbound); err != nil {
+		return inbound, false, fmt.Errorf("decode inbound %s: %w", uuid, err)
+	}
+	return inbound, true, nil
+}
@@ -2,12 +2,11 @@ package calculate
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/similar-manga/similar/cmd"
 	"github.com/similar-manga/similar/internal"
 	"github.com/spf13/cobra"
-	"io"
-	"log"
 	"os"
 	"strings"
 	"time"
@@ -17,100 +16,123 @@ var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the database data",
 	Long:  `Initializes the database with the stored data in the repository`,
-	Run:   runInit,
+	RunE:  runInit,
 }
 
 func init() {
 	cmd.RootCmd.AddCommand(initCmd)
+	cmd.UsesDatabase(initCmd, cmd.DatabaseReadWrite)
 }
 
-func runInit(cmd *cobra.Command, args []string) {
+func runInit(cmd *cobra.Command, args []string) error {
 	fmt.Println("Begin init")
 	startProcessing := time.Now()
 
-	createMangaDB()
-	populateMangaDB()
-	populateMangaUpdatesMappingDB()
+	if err := createMangaDB(); err != nil {
+		return err
+	}
+	if err := populateMangaDB(); err != nil {
+		return err
+	}
+	if err := populateMangaUpdatesMappingDB(); err != nil {
+		return err
+	}
 	fmt.Printf("Initialized in %s\n\n", time.Since(startProcessing))
-
+	return nil
 }
 
-func createMangaDB() {
+// createMangaDB replaces data.db with an empty database built by the migrations.
+func createMangaDB() error {
 	fmt.Println("Creating manga.db")
-	src, err := os.Open("data/default_empty_data.db")
-	internal.CheckErr(err)
-	defer src.Close()
-	dst, err := os.Create("data/data.db")
-	internal.CheckErr(err)
-	defer dst.Close()
-
-	buf := make([]byte, 1024)
-	for {
-		n, err := src.Read(buf)
-		if err != nil && err != io.EOF {
-			internal.CheckErr(err)
-			break
-		}
-
-		if n == 0 {
-			break
-		}
-
-		if _, err := dst.Write(buf[:n]); err != nil {
-			internal.CheckErr(err)
-			break
+	if internal.DB != nil {
+		if err := internal.DB.Close(); err != nil {
+			return fmt.Errorf("close database: %w", err)
 		}
 	}
+	if err := os.Remove("data/data.db"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove database: %w", err)
+	}
+	return internal.ConnectDB()
 }
 
-func populateMangaUpdatesMappingDB() {
-	file, err := os.Open("data/mappings/mangaupdates_new2mdex.txt")
+func populateMangaUpdatesMappingDB() error {
 	fmt.Printf("Populating from  %s\n", "mangaupdates_new2mdex.txt")
+	file, err := os.Open("data/mappings/mangaupdates_new2mdex.txt")
+	if err != nil {
+		return fmt.Errorf("open mangaupdates mappings: %w", err)
+	}
 	defer file.Close()
-	internal.CheckErr(err)
 	scanner := bufio.NewScanner(file)
 	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("begin mangaupdates mappings: %w", err)
+	}
+	defer tx.Rollback()
 	for scanner.Scan() {
 		line := scanner.Text()
 		split := strings.Split(line, ":::||@!@||:::")
-		if len(split) > 0 && len(line) > 0 {
-			_, err := tx.Exec("INSERT INTO MANGAUPDATES_NEW(UUID, ID) VALUES (?,?) ON CONFLICT (UUID) DO UPDATE SET ID=excluded.ID", split[1], split[0])
-			internal.CheckErr(err)
+		if len(split) < 2 {
+			continue
+		}
+		_, err := tx.Exec("INSERT INTO MANGAUPDATES_NEW(UUID, ID) VALUES (?,?) ON CONFLICT (UUID) DO UPDATE SET ID=excluded.ID", split[1], split[0])
+		if err != nil {
+			return fmt.Errorf("insert mangaupdates mapping %s: %w", split[1], err)
 		}
 	}
-	err = tx.Commit()
-	internal.CheckErr(err)
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read mangaupdates mappings: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit mangaupdates mappings: %w", err)
+	}
+	return nil
 }
 
-func populateMangaDB() {
+func populateMangaDB() error {
 	files, err := os.ReadDir("data/manga/")
-	fmt.Printf("Populating manga.db manga table from %d files\n", len(files))
-
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("read manga dump: %w", err)
 	}
+	fmt.Printf("Populating manga.db manga table from %d files\n", len(files))
 
 	for _, fileInfo := range files {
 		fmt.Printf("Populating from  %s\n", fileInfo.Name())
-		openFileAndProcess(fileInfo)
+		if err := openFileAndProcess(fileInfo); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func openFileAndProcess(fileInfo os.DirEntry) {
+func openFileAndProcess(fileInfo os.DirEntry) error {
 	file, err := os.Open("data/manga/" + fileInfo.Name())
+	if err != nil {
+		return fmt.Errorf("open %s: %w", fileInfo.Name(), err)
+	}
 	defer file.Close()
-	internal.CheckErr(err)
 	scanner := bufio.NewScanner(file)
+	// Manga json lines can be longer than the default 64KB token limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
 	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("begin %s: %w", fileInfo.Name(), err)
+	}
+	defer tx.Rollback()
 	for scanner.Scan() {
 		split := strings.Split(scanner.Text(), ":::||@!@||:::")
-		if len(split) > 0 {
-			_, err := tx.Exec("INSERT INTO MANGA(UUID, DATE, JSON) VALUES (?,?,?) ON CONFLICT (UUID) DO UPDATE SET JSON=excluded.JSON", split[0], split[1], split[2])
-			internal.CheckErr(err)
+		if len(split) < 3 {
+			continue
 		}
+		_, err := tx.Exec("INSERT INTO MANGA(UUID, DATE, JSON) VALUES (?,?,?) ON CONFLICT (UUID) DO UPDATE SET JSON=excluded.JSON", split[0], split[1], split[2])
+		if err != nil {
+			return fmt.Errorf("insert manga %s: %w", split[0], err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", fileInfo.Name(), err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit %s: %w", fileInfo.Name(), err)
 	}
-	err = tx.Commit()
-	internal.CheckErr(err)
+	return nil
 }
@@ -0,0 +1,190 @@
+package lookup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var lookupCmd = &cobra.Command{
+	Use:   "lookup",
+	Short: "Find the MangaDex entry of an external id, or the external ids of an entry",
+	Long: `Look up the mapping tables, either from an external site id to the MangaDex entries linking to it
+(--site al --id 30013) or from a MangaDex uuid (--uuid). Every known external id and the title are printed
+for each match.`,
+	Args: cmd.UsageArgs(cobra.NoArgs),
+	RunE: runLookup,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(lookupCmd)
+	cmd.UsesDatabase(lookupCmd, cmd.DatabaseReadOnly)
+	lookupCmd.Flags().StringP("site", "s", "", "Site key of the external id, such as al, mal or mu_new")
+	lookupCmd.Flags().StringP("id", "i", "", "External id to look up, used with --site")
+	lookupCmd.Flags().StringP("uuid", "u", "", "MangaDex uuid to look up instead of an external id")
+	lookupCmd.Flags().BoolP("json", "j", false, "Print the matches as json")
+}
+
+// Match is a MangaDex entry with every external id mapped to it, keyed by site key.
+type Match struct {
+	Id       string            `json:"id"`
+	Title    string            `json:"title"`
+	Mappings map[string]string `json:"mappings"`
+}
+
+func runLookup(command *cobra.Command, args []string) error {
+	siteKey, _ := command.Flags().GetString("site")
+	externalId, _ := command.Flags().GetString("id")
+	uuid, _ := command.Flags().GetString("uuid")
+	asJson, _ := command.Flags().GetBool("json")
+
+	var matches []Match
+	var err error
+	switch {
+	case uuid != "" && (siteKey != "" || externalId != ""):
+		return cmd.UsageErrorf("--uuid cannot be used with --site or --id")
+	case uuid != "":
+		matches, err = LookupMangaDex(uuid)
+	case siteKey == "" || externalId == "":
+		return cmd.UsageErrorf("either --uuid or both --site and --id are required")
+	default:
+		if _, ok := internal.MappingSiteByKey(siteKey); !ok {
+			return cmd.UsageErrorf("unknown site %q, expected one of %s", siteKey, strings.Join(siteKeys(), ", "))
+		}
+		matches, err = LookupExternal(siteKey, externalId)
+	}
+	if err != nil {
+		return err
+	}
+
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(matches)
+	}
+	if len(matches) == 0 {
+		fmt.Println("No matches found")
+		return nil
+	}
+	for _, match := range matches {
+		fmt.Printf("%s  %s\n", match.Id, match.Title)
+		for _, site := range internal.MappingSites {
+			if id, ok := match.Mappings[site.Key]; ok {
+				fmt.Printf("  %-6s %s\n", site.Key, id)
+			}
+		}
+	}
+	return nil
+}
+
+// LookupExternal returns the MangaDex entries mapped to the id of a site, ordered by uuid.
+func LookupExternal(siteKey string, externalId string) ([]Match, error) {
+	site, ok := internal.MappingSiteByKey(siteKey)
+	if !ok {
+		return nil, fmt.Errorf("unknown site %s", siteKey)
+	}
+	rows, err := internal.DB.Query("SELECT UUID FROM "+site.Table+" WHERE ID = ? ORDER BY UUID ASC", externalId)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", site.Name, err)
+	}
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan %s: %w", site.Name, err)
+		}
+		uuids = append(uuids, uuid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", site.Name, err)
+	}
+
+	matches := []Match{}
+	for _, uuid := range uuids {
+		match, err := loadMatch(uuid)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// LookupMangaDex returns the entry with the given uuid, or no match when it is neither stored
+// nor mapped to any external id.
+func LookupMangaDex(uuid string) ([]Match, error) {
+	match, err := loadMatch(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if match.Title == "" && len(match.Mappings) == 0 {
+		return []Match{}, nil
+	}
+	return []Match{match}, nil
+}
+
+// loadMatch reads the title and every external id of an entry.
+func loadMatch(uuid string) (Match, error) {
+	match := Match{Id: uuid, Mappings: make(map[string]string)}
+	for _, site := range internal.MappingSites {
+		var id string
+		err := internal.DB.QueryRow("SELECT ID FROM "+site.Table+" WHERE UUID = ?", uuid).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return match, fmt.Errorf("query %s of %s: %w", site.Name, uuid, err)
+		}
+		match.Mappings[site.Key] = id
+	}
+
+	var jsonManga []byte
+	err := internal.DB.QueryRow("SELECT JSON FROM "+internal.TableManga+" WHERE UUID = ?", uuid).Scan(&jsonManga)
+	if errors.Is(err, sql.ErrNoRows) {
+		return match, nil
+	} else if err != nil {
+		return match, fmt.Errorf("query manga %s: %w", uuid, err)
+	}
+	var manga internal.Manga
+	if err := json.Unmarshal(jsonManga, &manga); err != nil {
+		return match, fmt.Errorf("decode manga %s: %w", uuid, err)
+	}
+	match.Title = mangaTitle(manga)
+	return match, nil
+}
+
+// mangaTitle returns the english title, or the first other title by language code.
+func mangaTitle(manga internal.Manga) string {
+	if manga.Title == nil {
+		return ""
+	}
+	titles := *manga.Title
+	if title := titles["en"]; title != "" {
+		return title
+	}
+	for _, lang := range slices.Sorted(maps.Keys(titles)) {
+		if titles[lang] != "" {
+			return titles[lang]
+		}
+	}
+	return ""
+}
+
+// siteKeys lists the keys --site accepts.
+func siteKeys() []string {
+	keys := make([]string, 0, len(internal.MappingSites))
+	for _, site := range internal.MappingSites {
+		keys = append(keys, site.Key)
+	}
+	return keys
+}
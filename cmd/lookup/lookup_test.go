@@ -0,0 +1,98 @@
+package lookup
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
+)
+
+func setupLookupDB(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	originalDB := internal.DB
+	internal.DB = db
+	t.Cleanup(func() {
+		internal.DB = originalDB
+		db.Close()
+	})
+
+	if _, err := db.Exec("CREATE TABLE " + internal.TableManga + " (UUID TEXT PRIMARY KEY, JSON TEXT, DATE TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, site := range internal.MappingSites {
+		if _, err := db.Exec("CREATE TABLE " + site.Table + " (UUID TEXT PRIMARY KEY, ID TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?), (?, ?, ?)",
+		"uuid-1", `{"id":"uuid-1","title":{"en":"Pink to Habanero"}}`, "2023-01-01",
+		"uuid-2", `{"id":"uuid-2","title":{"ko":"핑크","ja":"ピンク"}}`, "2023-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, insert := range []string{
+		"INSERT INTO " + internal.TableAnilist + " (UUID, ID) VALUES ('uuid-1', '30013'), ('uuid-2', '30013')",
+		"INSERT INTO " + internal.TableMyanimelist + " (UUID, ID) VALUES ('uuid-1', '13')",
+		"INSERT INTO " + internal.TableMangaupdatesNewId + " (UUID, ID) VALUES ('uuid-1', '55099564912')",
+	} {
+		if _, err := db.Exec(insert); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLookupExternal(t *testing.T) {
+	setupLookupDB(t)
+
+	matches, err := LookupExternal("al", "30013")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("expected both entries linking to al 30013, got %+v", matches)
+	}
+	first := matches[0]
+	if first.Id != "uuid-1" || first.Title != "Pink to Habanero" || first.Mappings["mal"] != "13" || first.Mappings["mu_new"] != "55099564912" {
+		t.Errorf("unexpected first match %+v", first)
+	}
+	// Without an english title the first language code wins
+	if matches[1].Id != "uuid-2" || matches[1].Title != "ピンク" {
+		t.Errorf("unexpected second match %+v", matches[1])
+	}
+
+	matches, err = LookupExternal("mal", "404")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches == nil || len(matches) != 0 {
+		t.Errorf("expected an empty list for an unmapped id, got %v", matches)
+	}
+	if _, err := LookupExternal("xx", "1"); err == nil {
+		t.Error("expected an error for an unknown site")
+	}
+}
+
+func TestLookupMangaDex(t *testing.T) {
+	setupLookupDB(t)
+
+	matches, err := LookupMangaDex("uuid-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || len(matches[0].Mappings) != 3 || matches[0].Mappings["al"] != "30013" {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+
+	matches, err = LookupMangaDex("uuid-404")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("expected no match for an unknown uuid, got %+v", matches)
+	}
+}
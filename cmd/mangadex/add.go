@@ -2,12 +2,13 @@ package mangadex
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/antihax/optional"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
 	"github.com/similar-manga/similar/mangadex"
 	"github.com/spf13/cobra"
-	"go.uber.org/ratelimit"
 	"time"
 )
 
@@ -15,57 +16,82 @@ import (
 var addCmd = &cobra.Command{
 	Use:   "add",
 	Short: "queries and adds all the new manga UUID's to txt",
-	Long:  `This searches for manga ordered by date added (newest first) and adds the Manga UUID to the txt until an existing UUID is found`,
-	Run:   runAdd,
+	Long:  `This searches for manga ordered by date added, starting shortly before the last time manga were inserted, and adds every new Manga UUID to the txt`,
+	RunE:  runAdd,
 }
 
+// addLookback is how far before our last insert we start looking, to catch manga which were
+// created before the last run but only became visible after it
+const addLookback = 7 * 24 * time.Hour
+
 func init() {
 	mangadexCmd.AddCommand(addCmd)
+	addCmd.Flags().StringP("since", "s", "", "only look at manga created since this time (2006-01-02T15:04:05), defaults to a week before the last insert")
 }
 
-func runAdd(cmd *cobra.Command, args []string) {
-
-	rateLimiter := ratelimit.New(1, ratelimit.Per(2*time.Second))
+func runAdd(cmd *cobra.Command, args []string) error {
+	client := newCommandClient(cmd, 2*time.Second)
+	ctx := cmd.Context()
 
-	client := CreateMangaDexClient()
-	ctx := context.Background()
+	since, _ := cmd.Flags().GetString("since")
+	if since == "" {
+		var err error
+		if since, err = defaultAddSince(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Getting manga created since %s\n", since)
 
-	currentLimit := int32(100)
-	maxOffset := int32(10000)
-	done := false
 	count := 0
-
-	for currentOffset := int32(0); currentOffset < maxOffset && done == false; currentOffset += currentLimit {
-
-		opts := mangadex.MangaApiGetSearchMangaOpts{}
-		opts.OrderCreatedAt = optional.NewString("desc")
-		opts.Limit = optional.NewInt32(currentLimit)
-		opts.Offset = optional.NewInt32(currentOffset)
-
-		mangaList := SearchMangaDex(rateLimiter, client, ctx, opts)
-
-		uuids := make([]string, len(mangaList.Data))
-		for i, apiManga := range mangaList.Data {
+	cursor := mangaCursor{field: cursorCreatedAt, since: since, limit: 100}
+	_, crawlErr := cursor.crawl(func(opts mangadex.MangaApiGetSearchMangaOpts) (mangadex.MangaList, error) {
+		return SearchMangaDex(client, ctx, opts)
+	}, func(mangaList []mangadex.Manga) error {
+		uuids := make([]string, len(mangaList))
+		for i, apiManga := range mangaList {
 			uuids[i] = apiManga.Id
 		}
-
-		existingUUIDs := GetExistingMangaUUIDs(uuids)
+		existingUUIDs, err := GetExistingMangaUUIDs(uuids)
+		if err != nil {
+			return err
+		}
 
 		var toUpsert []mangadex.Manga
-		for _, apiManga := range mangaList.Data {
+		for _, apiManga := range mangaList {
 			if !existingUUIDs[apiManga.Id] {
 				count++
 				toUpsert = append(toUpsert, apiManga)
 				fmt.Printf("Inserting manga with ID: %s\n", apiManga.Id)
-			} else {
-				done = true
-				break
 			}
 		}
-		BatchUpsertManga(toUpsert)
-	}
+		return BatchUpsertManga(toUpsert)
+	})
+
 	fmt.Printf("Inserted %d manga\n", count)
+	if errors.Is(crawlErr, context.Canceled) {
+		fmt.Println("Interrupted, manga inserted so far are kept and the rest will be picked up by the next run")
+	}
+	printRateLimitMetrics(client)
 
-	ExportManga()
+	// Export whatever was inserted even if the crawl failed part way
+	return errors.Join(crawlErr, ExportManga())
+}
 
+// defaultAddSince returns a week before the last manga was inserted into the database, or an
+// empty string to crawl everything when the database is empty. DATE is when UpsertManga
+// stored the manga, not when it was created on MangaDex.
+func defaultAddSince() (string, error) {
+	var lastInsert sql.NullString
+	err := internal.DB.QueryRow("SELECT MAX(DATE) FROM " + internal.TableManga).Scan(&lastInsert)
+	if err != nil {
+		return "", fmt.Errorf("query last manga insert: %w", err)
+	}
+	if !lastInsert.Valid {
+		return "", nil
+	}
+	lastInsertTime, err := time.Parse(mangaDexTimeFormat, lastInsert.String)
+	if err != nil {
+		return "", fmt.Errorf("parse last manga insert date, pass --since instead: %w", err)
+	}
+	return lastInsertTime.Add(-addLookback).Format(mangaDexTimeFormat), nil
 }
@@ -0,0 +1,73 @@
+package mangadex
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+// metadataCheckpoint is the progress of a full metadata refresh, saved after every batch
+// so an interrupted run continues where it stopped.
+type metadataCheckpoint struct {
+	RunId     string
+	StartedAt string
+	LastBatch int
+	LastUUID  string
+	Checked   int
+	Missing   []string
+}
+
+func newMetadataCheckpoint(start time.Time) (metadataCheckpoint, error) {
+	runId := make([]byte, 8)
+	if _, err := rand.Read(runId); err != nil {
+		return metadataCheckpoint{}, fmt.Errorf("generate run id: %w", err)
+	}
+	return metadataCheckpoint{
+		RunId:     hex.EncodeToString(runId),
+		StartedAt: strings.Split(start.UTC().Format(time.RFC3339), "Z")[0],
+	}, nil
+}
+
+func loadMetadataCheckpoint() (metadataCheckpoint, bool, error) {
+	checkpoint := metadataCheckpoint{}
+	var missing string
+	err := internal.DB.QueryRow("SELECT RUN_ID, STARTED_AT, LAST_BATCH, LAST_UUID, CHECKED, MISSING FROM "+internal.TableMetadataCheckpoint+" WHERE ID = 1").
+		Scan(&checkpoint.RunId, &checkpoint.StartedAt, &checkpoint.LastBatch, &checkpoint.LastUUID, &checkpoint.Checked, &missing)
+	if errors.Is(err, sql.ErrNoRows) {
+		return checkpoint, false, nil
+	} else if err != nil {
+		return checkpoint, false, fmt.Errorf("load metadata checkpoint: %w", err)
+	}
+	if err := json.Unmarshal([]byte(missing), &checkpoint.Missing); err != nil {
+		return checkpoint, false, fmt.Errorf("decode metadata checkpoint: %w", err)
+	}
+	return checkpoint, true, nil
+}
+
+func saveMetadataCheckpoint(checkpoint metadataCheckpoint) error {
+	missing, err := json.Marshal(checkpoint.Missing)
+	if err != nil {
+		return fmt.Errorf("encode metadata checkpoint: %w", err)
+	}
+	_, err = internal.DB.Exec("INSERT INTO "+internal.TableMetadataCheckpoint+" (ID, RUN_ID, STARTED_AT, LAST_BATCH, LAST_UUID, CHECKED, MISSING) VALUES (1, ?, ?, ?, ?, ?, ?) "+
+		"ON CONFLICT (ID) DO UPDATE SET RUN_ID=excluded.RUN_ID, STARTED_AT=excluded.STARTED_AT, LAST_BATCH=excluded.LAST_BATCH, LAST_UUID=excluded.LAST_UUID, CHECKED=excluded.CHECKED, MISSING=excluded.MISSING",
+		checkpoint.RunId, checkpoint.StartedAt, checkpoint.LastBatch, checkpoint.LastUUID, checkpoint.Checked, missing)
+	if err != nil {
+		return fmt.Errorf("save metadata checkpoint: %w", err)
+	}
+	return nil
+}
+
+func clearMetadataCheckpoint() error {
+	if _, err := internal.DB.Exec("DELETE FROM " + internal.TableMetadataCheckpoint); err != nil {
+		return fmt.Errorf("clear metadata checkpoint: %w", err)
+	}
+	return nil
+}
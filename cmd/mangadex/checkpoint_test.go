@@ -0,0 +1,83 @@
+package mangadex
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+func TestMetadataCheckpointRoundTrip(t *testing.T) {
+	db := setupTestDB()
+	defer db.Close()
+	internal.DB = db
+	if err := internal.Migrate(db, internal.DataMigrations); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, err := loadMetadataCheckpoint(); err != nil || found {
+		t.Fatal("expected no checkpoint in a fresh database")
+	}
+
+	checkpoint, err := newMetadataCheckpoint(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.StartedAt != "2024-05-01T12:00:00" || checkpoint.RunId == "" {
+		t.Fatalf("unexpected new checkpoint %+v", checkpoint)
+	}
+	checkpoint.LastBatch = 3
+	checkpoint.LastUUID = "uuid-302"
+	checkpoint.Checked = 300
+	checkpoint.Missing = []string{"uuid-7"}
+	if err := saveMetadataCheckpoint(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint.LastBatch = 4
+	if err := saveMetadataCheckpoint(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, found, err := loadMetadataCheckpoint()
+	if err != nil || !found {
+		t.Fatal("expected the saved checkpoint to be found")
+	}
+	if loaded.RunId != checkpoint.RunId || loaded.LastBatch != 4 || loaded.LastUUID != "uuid-302" ||
+		loaded.Checked != 300 || !slices.Equal(loaded.Missing, []string{"uuid-7"}) {
+		t.Errorf("loaded checkpoint %+v does not match saved %+v", loaded, checkpoint)
+	}
+
+	if err := clearMetadataCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := loadMetadataCheckpoint(); found {
+		t.Error("expected the checkpoint to be cleared")
+	}
+}
+
+func TestCollectAllMangaIdsResumesAfterUUID(t *testing.T) {
+	db := setupTestDB()
+	defer db.Close()
+	internal.DB = db
+
+	all, err := collectAllMangaIds("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 10 || len(all[0]) != 100 {
+		t.Fatalf("expected 10 batches of 100, got %d batches", len(all))
+	}
+
+	resumed, err := collectAllMangaIds(all[3][99])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resumed) != 6 {
+		t.Fatalf("expected 6 remaining batches, got %d", len(resumed))
+	}
+	if resumed[0][0] != all[4][0] {
+		t.Errorf("expected resume to start at %s, got %s", all[4][0], resumed[0][0])
+	}
+}
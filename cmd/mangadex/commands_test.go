@@ -0,0 +1,262 @@
+package mangadex
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex"
+	"github.com/similar-manga/similar/mangadex/mangadextest"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// setupCommandTest loads the fixture dump, swaps in an empty database and moves into a
+// temporary directory for the files the commands export.
+func setupCommandTest(t *testing.T) []mangadex.Manga {
+	fixtures, err := mangadextest.LoadDump("testdata/manga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) != 6 {
+		t.Fatalf("expected 6 fixture manga, got %d", len(fixtures))
+	}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	originalDB := internal.DB
+	internal.DB = db
+	t.Cleanup(func() {
+		internal.DB = originalDB
+		db.Close()
+	})
+
+	schemas := []string{
+		"CREATE TABLE " + internal.TableManga + " (UUID TEXT PRIMARY KEY, JSON TEXT, DATE TEXT)",
+		"CREATE TABLE " + internal.TableSimilar + " (UUID TEXT PRIMARY KEY, JSON BLOB)",
+	}
+	for _, site := range internal.MappingSites {
+		schemas = append(schemas, "CREATE TABLE "+site.Table+" (UUID TEXT PRIMARY KEY, ID TEXT)")
+	}
+	for _, schema := range schemas {
+		if _, err := db.Exec(schema); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := internal.Migrate(db, internal.DataMigrations); err != nil {
+		t.Fatal(err)
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(workDir) })
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	return fixtures
+}
+
+// runCommand runs a subcommand against the fake server, resetting its flags afterwards.
+func runCommand(t *testing.T, command *cobra.Command, run func(*cobra.Command, []string) error, server *mangadextest.Server, args ...string) error {
+	t.Cleanup(func() {
+		command.Flags().VisitAll(func(flag *pflag.Flag) {
+			_ = flag.Value.Set(flag.DefValue)
+			flag.Changed = false
+		})
+	})
+	args = append([]string{"--base-url", server.URL, "--request-interval", "0"}, args...)
+	if err := command.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	command.SetContext(context.Background())
+	return run(command, nil)
+}
+
+func insertStoredManga(t *testing.T, manga []mangadex.Manga, date string, title string) {
+	for _, m := range manga {
+		jsonManga := `{"id":"` + m.Id + `","title":{"en":"` + title + `"}}`
+		if _, err := internal.DB.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?)", m.Id, jsonManga, date); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func storedTitles(t *testing.T) map[string]string {
+	rows, err := internal.DB.Query("SELECT UUID, JSON FROM " + internal.TableManga)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	titles := make(map[string]string)
+	for rows.Next() {
+		var uuid, jsonManga string
+		if err := rows.Scan(&uuid, &jsonManga); err != nil {
+			t.Fatal(err)
+		}
+		var manga internal.Manga
+		if err := json.Unmarshal([]byte(jsonManga), &manga); err != nil {
+			t.Fatal(err)
+		}
+		titles[uuid] = ""
+		if manga.Title != nil {
+			titles[uuid] = (*manga.Title)["en"]
+		}
+	}
+	return titles
+}
+
+func exportedLines(t *testing.T) int {
+	data, err := os.ReadFile(filepath.Join("data", "manga", "manga_0001.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestRunAddAgainstFakeServer(t *testing.T) {
+	fixtures := setupCommandTest(t)
+	insertStoredManga(t, fixtures[:2], "2024-01-08T10:00:00", "stored")
+
+	server := mangadextest.NewServer(fixtures...)
+	defer server.Close()
+	server.FailNext(mangadextest.FaultSoftLimit)
+
+	if err := runCommand(t, addCmd, runAdd, server); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := storedTitles(t)
+	if len(titles) != len(fixtures) {
+		t.Fatalf("expected %d manga after add, got %d", len(fixtures), len(titles))
+	}
+	// Existing manga are left alone, new ones are stored from the API
+	if titles[fixtures[0].Id] != "stored" || titles[fixtures[5].Id] != (*fixtures[5].Attributes.Title)["en"] {
+		t.Errorf("unexpected titles %v", titles)
+	}
+	if lines := exportedLines(t); lines != len(fixtures) {
+		t.Errorf("expected %d exported manga, got %d", len(fixtures), lines)
+	}
+
+	requests := server.Requests()
+	if len(requests) < 2 {
+		t.Fatalf("expected the soft limited request to be retried, got %d requests", len(requests))
+	}
+	last := requests[len(requests)-1]
+	if last.Get("createdAtSince") != "2024-01-01T10:00:00" || last.Get("order[createdAt]") != "asc" {
+		t.Errorf("expected to crawl from a week before the last insert, got %v", last)
+	}
+}
+
+func TestRunAddRefusesUnparsableInsertDate(t *testing.T) {
+	fixtures := setupCommandTest(t)
+	insertStoredManga(t, fixtures[:1], "2024-01-08", "stored")
+
+	server := mangadextest.NewServer(fixtures...)
+	defer server.Close()
+
+	// Falling back to a full crawl would hide the broken date
+	if err := runCommand(t, addCmd, runAdd, server); err == nil || !strings.Contains(err.Error(), "parse last manga insert date") {
+		t.Fatalf("expected the insert date to be refused, got %v", err)
+	}
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("expected no request to MangaDex, got %d", len(requests))
+	}
+}
+
+func TestRunMetadataAllAgainstFakeServer(t *testing.T) {
+	fixtures := setupCommandTest(t)
+	insertStoredManga(t, fixtures, "2024-01-01T00:00:00", "stale")
+
+	// The last fixture has been taken down
+	server := mangadextest.NewServer(fixtures[:5]...)
+	defer server.Close()
+	server.FailNext(mangadextest.FaultServerError)
+
+	if err := runCommand(t, metadataCmd, runMetadata, server, "--all", "--max-removed", "0.5"); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := storedTitles(t)
+	if len(titles) != 5 {
+		t.Fatalf("expected the removed manga to be purged, got %d manga", len(titles))
+	}
+	for _, m := range fixtures[:5] {
+		if titles[m.Id] != (*m.Attributes.Title)["en"] {
+			t.Errorf("manga %s was not refreshed: %q", m.Id, titles[m.Id])
+		}
+	}
+	if countRows(t, internal.TableMangaRemoved, fixtures[5].Id) != 1 {
+		t.Error("expected the removed manga to be tombstoned")
+	}
+	if _, found, err := loadMetadataCheckpoint(); err != nil || found {
+		t.Errorf("expected the checkpoint to be cleared, found %v err %v", found, err)
+	}
+	if _, err := os.Stat(filepath.Join("data", "last_metadata_update.txt")); err != nil {
+		t.Errorf("expected the last update time to be written: %v", err)
+	}
+}
+
+func TestRunMetadataAllKeepsCheckpointOverRemovalThreshold(t *testing.T) {
+	fixtures := setupCommandTest(t)
+	insertStoredManga(t, fixtures, "2024-01-01T00:00:00", "stale")
+
+	// One of six manga missing is over the default 5% threshold
+	server := mangadextest.NewServer(fixtures[:5]...)
+	defer server.Close()
+
+	if err := runCommand(t, metadataCmd, runMetadata, server, "--all"); err == nil {
+		t.Fatal("expected refusing to purge to fail the command")
+	}
+	if len(storedTitles(t)) != len(fixtures) {
+		t.Error("expected no manga to be purged")
+	}
+	checkpoint, found, err := loadMetadataCheckpoint()
+	if err != nil || !found || len(checkpoint.Missing) != 1 {
+		t.Errorf("expected the checkpoint kept with the missing manga, found %v %+v err %v", found, checkpoint, err)
+	}
+	if _, err := os.Stat(filepath.Join("data", "last_metadata_update.txt")); err == nil {
+		t.Error("expected the last update time not to move")
+	}
+}
+
+func TestRunMetadataSinceLastUpdateAgainstFakeServer(t *testing.T) {
+	fixtures := setupCommandTest(t)
+	insertStoredManga(t, fixtures, "2024-01-01T00:00:00", "stale")
+	if err := os.WriteFile(filepath.Join("data", "last_metadata_update.txt"), []byte("2024-01-04T00:00:00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := mangadextest.NewServer(fixtures...)
+	defer server.Close()
+
+	if err := runCommand(t, metadataCmd, runMetadata, server); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := storedTitles(t)
+	for i, m := range fixtures {
+		refreshed := titles[m.Id] != "stale"
+		if refreshed != (i >= 3) {
+			t.Errorf("manga %d updated %s: refreshed = %v", i, m.Attributes.UpdatedAt, refreshed)
+		}
+	}
+	for _, query := range server.Requests() {
+		if query.Get("updatedAtSince") != "2024-01-04T00:00:00" || query.Get("order[updatedAt]") != "asc" {
+			t.Errorf("unexpected incremental query %v", query)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package mangadex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex"
+	"github.com/spf13/cobra"
+)
+
+var coversCmd = &cobra.Command{
+	Use:   "covers",
+	Short: "downloads the cover thumbnails into a local cache",
+	Long: `Downloads the cover of every manga with a known cover file into a local cache. Images are
+stored under the sha256 of their content, so a cover shared by several manga is kept once,
+and a manga is only downloaded again once its cover changes. Run mangadex add or metadata
+first so the cover file names are known.`,
+	RunE: runCovers,
+}
+
+func init() {
+	mangadexCmd.AddCommand(coversCmd)
+	coversCmd.Flags().String("size", "256", "cover width to download, 256, 512 or original")
+	coversCmd.Flags().String("cover-url", internal.CoverBaseUrl, "host serving the cover images")
+	coversCmd.Flags().String("dir", "data/covers", "directory of the cover cache")
+}
+
+// coverCache downloads covers into a content addressed directory, with a rate limiter of
+// its own since the images are not served by the API.
+type coverCache struct {
+	dir     string
+	baseUrl string
+	size    string
+	client  *http.Client
+	limiter *mangadex.RateLimiter
+}
+
+// cachedCover is the cover last downloaded for a manga.
+type cachedCover struct {
+	fileName string
+	size     string
+}
+
+func runCovers(command *cobra.Command, args []string) error {
+	size, _ := command.Flags().GetString("size")
+	switch size {
+	case "256", "512":
+	case "original":
+		size = ""
+	default:
+		return cmd.UsageErrorf("invalid cover size %q, expected 256, 512 or original", size)
+	}
+	interval := 200 * time.Millisecond
+	if command.Flags().Changed("request-interval") {
+		interval, _ = command.Flags().GetDuration("request-interval")
+	}
+	if internal.HTTPTransport.Mode() == internal.HTTPModeReplay {
+		interval = 0
+	}
+	baseUrl, _ := command.Flags().GetString("cover-url")
+	dir, _ := command.Flags().GetString("dir")
+	cache := &coverCache{
+		dir:     dir,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		size:    size,
+		client:  &http.Client{Timeout: 30 * time.Second, Transport: internal.HTTPTransport},
+		limiter: mangadex.NewRateLimiter(interval),
+	}
+
+	cached, err := loadCachedCovers()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	ctx := command.Context()
+	unchanged, missing := 0, 0
+	// Collected up front, the stream holds the only database connection while it is open
+	var pending []internal.Manga
+	for manga := range internal.StreamAllManga() {
+		if manga.CoverFileName == "" {
+			missing++
+			continue
+		}
+		if cached[manga.Id] == (cachedCover{manga.CoverFileName, size}) {
+			unchanged++
+			continue
+		}
+		pending = append(pending, internal.Manga{Id: manga.Id, CoverFileName: manga.CoverFileName})
+	}
+
+	downloaded := 0
+	var errs []error
+	for _, manga := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := cache.fetch(ctx, manga.Id, manga.CoverFileName); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				fmt.Printf("\u001B[1;31mCOVER ERROR %s: %v\u001B[0m\n", manga.Id, err)
+				errs = append(errs, err)
+			}
+			continue
+		}
+		downloaded++
+		fmt.Printf("\rDownloaded %d of %d covers", downloaded, len(pending))
+	}
+	fmt.Println()
+
+	fmt.Printf("Covers downloaded: %d, unchanged: %d, without a cover: %d, failed: %d\n", downloaded, unchanged, missing, len(errs))
+	if ctx.Err() != nil {
+		fmt.Println("Interrupted, rerun to download the remaining covers")
+		errs = append(errs, ctx.Err())
+	}
+	fmt.Printf("\t- Finished in %s\n", time.Since(start))
+	return errors.Join(errs...)
+}
+
+func loadCachedCovers() (map[string]cachedCover, error) {
+	rows, err := internal.DB.Query("SELECT UUID, FILE_NAME, SIZE FROM " + internal.TableCoverCache)
+	if err != nil {
+		return nil, fmt.Errorf("query cover cache: %w", err)
+	}
+	defer rows.Close()
+	cached := make(map[string]cachedCover)
+	for rows.Next() {
+		var uuid string
+		var cover cachedCover
+		if err := rows.Scan(&uuid, &cover.fileName, &cover.size); err != nil {
+			return nil, fmt.Errorf("scan cover cache: %w", err)
+		}
+		cached[uuid] = cover
+	}
+	return cached, rows.Err()
+}
+
+// fetch downloads the cover of a manga, stores it under its checksum and records it.
+func (c *coverCache) fetch(ctx context.Context, uuid string, fileName string) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	url := internal.CoverFileUrl(c.baseUrl, uuid, fileName, c.size)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("create cover request: %w", err)
+	}
+	resp, err := c.client.Do(req)
+	c.limiter.Observe(resp, err)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: unexpected http code %d", url, resp.StatusCode)
+	}
+	image, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", url, err)
+	}
+
+	sum, err := c.store(image)
+	if err != nil {
+		return err
+	}
+	_, err = internal.DB.Exec("INSERT OR REPLACE INTO "+internal.TableCoverCache+" (UUID, FILE_NAME, SIZE, SHA256, FETCHED_AT) VALUES (?, ?, ?, ?, ?)",
+		uuid, fileName, c.size, sum, time.Now().UTC().Format("2006-01-02T15:04:05"))
+	if err != nil {
+		return fmt.Errorf("record cover of %s: %w", uuid, err)
+	}
+	return nil
+}
+
+// store writes the image to dir/<first two hex digits>/<sha256>.jpg unless it is already
+// there, and returns its checksum.
+func (c *coverCache) store(image []byte) (string, error) {
+	hash := sha256.Sum256(image)
+	sum := hex.EncodeToString(hash[:])
+	path := coverPath(c.dir, sum)
+	if _, err := os.Stat(path); err == nil {
+		return sum, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("create cover dir: %w", err)
+	}
+	// Written next to its final name first so an interrupted write never looks cached
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, image, 0644); err != nil {
+		return "", fmt.Errorf("write cover: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("write cover: %w", err)
+	}
+	return sum, nil
+}
+
+// coverPath is where the cover with the given checksum is kept.
+func coverPath(dir string, sum string) string {
+	return filepath.Join(dir, sum[:2], sum+".jpg")
+}
@@ -0,0 +1,145 @@
+package mangadex
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex/mangadextest"
+)
+
+// coverServer serves an image per cover file name, the same bytes for every file name
+// sharing the part before the first dash.
+type coverServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	paths []string
+}
+
+func newCoverServer(t *testing.T) *coverServer {
+	s := &coverServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.paths = append(s.paths, r.URL.Path)
+		s.mu.Unlock()
+		file := filepath.Base(r.URL.Path)
+		if strings.HasPrefix(file, "missing") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("image " + strings.SplitN(file, "-", 2)[0]))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *coverServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.paths...)
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRunAddStoresCoverFileName(t *testing.T) {
+	fixtures := setupCommandTest(t)
+	manga := internal.Manga{Id: fixtures[0].Id, Title: &map[string]string{"en": "Covered"}, CoverFileName: "front.jpg"}
+
+	server := mangadextest.NewServer(mangadextest.FromManga(manga, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	defer server.Close()
+
+	if err := runCommand(t, addCmd, runAdd, server, "--since", "2024-01-01T00:00:00"); err != nil {
+		t.Fatal(err)
+	}
+	var jsonManga string
+	if err := internal.DB.QueryRow("SELECT JSON FROM "+internal.TableManga+" WHERE UUID = ?", manga.Id).Scan(&jsonManga); err != nil {
+		t.Fatal(err)
+	}
+	var stored internal.Manga
+	if err := json.Unmarshal([]byte(jsonManga), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.CoverFileName != "front.jpg" {
+		t.Errorf("expected the expanded cover file name to be stored, got %q", stored.CoverFileName)
+	}
+	if stored.CoverUrl() != internal.CoverBaseUrl+"/covers/"+manga.Id+"/front.jpg.256.jpg" {
+		t.Errorf("unexpected cover url %s", stored.CoverUrl())
+	}
+	if includes := server.Requests()[0]["includes[]"]; len(includes) != 1 || includes[0] != "cover_art" {
+		t.Errorf("expected the search to include cover_art, got %v", includes)
+	}
+}
+
+func TestRunCoversCachesByContent(t *testing.T) {
+	setupCommandTest(t)
+	covers := map[string]string{"uuid-1": "a-1.jpg", "uuid-2": "a-2.jpg", "uuid-3": "b-1.jpg", "uuid-4": "missing.jpg", "uuid-5": ""}
+	for uuid, file := range covers {
+		jsonManga, _ := json.Marshal(internal.Manga{Id: uuid, CoverFileName: file})
+		if _, err := internal.DB.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?)", uuid, jsonManga, "2024-01-01T00:00:00"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newCoverServer(t)
+	api := mangadextest.NewServer()
+	defer api.Close()
+
+	if err := runCommand(t, coversCmd, runCovers, api, "--cover-url", server.URL); err == nil {
+		t.Error("expected the missing cover to be reported")
+	}
+	if got := len(server.requests()); got != 4 {
+		t.Errorf("expected 4 downloads, got %d", got)
+	}
+	if path := server.requests()[0]; !strings.HasPrefix(path, "/covers/uuid-") || !strings.HasSuffix(path, ".256.jpg") {
+		t.Errorf("unexpected cover path %s", path)
+	}
+
+	// Covers with the same content are stored once
+	for _, content := range []string{"image a", "image b"} {
+		sum := checksum(content)
+		data, err := os.ReadFile(filepath.Join("data", "covers", sum[:2], sum+".jpg"))
+		if err != nil || string(data) != content {
+			t.Errorf("expected %q in the cache, got %q %v", content, data, err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join("data", "covers", "*", "*"))
+	if len(files) != 2 {
+		t.Errorf("expected 2 cached images, got %v", files)
+	}
+	var sum string
+	if err := internal.DB.QueryRow("SELECT SHA256 FROM "+internal.TableCoverCache+" WHERE UUID = ?", "uuid-2").Scan(&sum); err != nil || sum != checksum("image a") {
+		t.Errorf("unexpected checksum recorded for uuid-2: %s %v", sum, err)
+	}
+
+	// Only the failed and changed covers are fetched again
+	if _, err := internal.DB.Exec("UPDATE "+internal.TableManga+" SET JSON = ? WHERE UUID = ?", `{"id":"uuid-3","coverFileName":"c-1.jpg"}`, "uuid-3"); err != nil {
+		t.Fatal(err)
+	}
+	before := len(server.requests())
+	runCommand(t, coversCmd, runCovers, api, "--cover-url", server.URL)
+	again := server.requests()[before:]
+	if len(again) != 2 || !strings.Contains(strings.Join(again, ","), "/covers/uuid-3/c-1.jpg.256.jpg") {
+		t.Errorf("expected only the changed and missing covers to be fetched, got %v", again)
+	}
+}
+
+func TestRunCoversRejectsSize(t *testing.T) {
+	setupCommandTest(t)
+	api := mangadextest.NewServer()
+	defer api.Close()
+	if err := runCommand(t, coversCmd, runCovers, api, "--size", "1024"); err == nil {
+		t.Error("expected an invalid size to be rejected")
+	}
+}
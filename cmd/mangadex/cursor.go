@@ -0,0 +1,117 @@
+package mangadex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/similar-manga/similar/mangadex"
+)
+
+// MangaDex refuses any search where offset + limit is over 10,000
+const maxSearchWindow = int32(10000)
+
+// mangaDexTimeFormat is the format accepted by the createdAtSince and updatedAtSince filters
+const mangaDexTimeFormat = "2006-01-02T15:04:05"
+
+const (
+	cursorCreatedAt = "createdAt"
+	cursorUpdatedAt = "updatedAt"
+)
+
+// mangaCursor walks a search ordered ascending by createdAt or updatedAt. Offsets are only
+// used within a window; once a window reaches the offset ceiling the cursor moves to the
+// timestamp of the last manga seen and starts a new window from offset zero.
+type mangaCursor struct {
+	field string
+	since string
+	limit int32
+}
+
+// crawl pages through every window until the search is exhausted, passing each page of
+// manga not seen in a previous window to handle. It returns the number of manga handled,
+// stopping at the first error from search or handle.
+func (c mangaCursor) crawl(search func(opts mangadex.MangaApiGetSearchMangaOpts) (mangadex.MangaList, error), handle func([]mangadex.Manga) error) (int, error) {
+	seen := make(map[string]bool)
+	since := c.since
+	count := 0
+	for {
+		var last string
+		exhausted := false
+		for offset := int32(0); offset+c.limit <= maxSearchWindow; offset += c.limit {
+			mangaList, err := search(c.searchOpts(since, offset))
+			if err != nil {
+				return count, err
+			}
+			page := make([]mangadex.Manga, 0, len(mangaList.Data))
+			for _, apiManga := range mangaList.Data {
+				if !seen[apiManga.Id] {
+					seen[apiManga.Id] = true
+					page = append(page, apiManga)
+				}
+				if timestamp := c.timestamp(apiManga); timestamp != "" {
+					last = timestamp
+				}
+			}
+			if len(page) > 0 {
+				if err := handle(page); err != nil {
+					return count, err
+				}
+				count += len(page)
+			}
+			if int32(len(mangaList.Data)) < c.limit {
+				exhausted = true
+				break
+			}
+		}
+		if exhausted || last == "" {
+			return count, nil
+		}
+
+		// Every manga in the window shares a timestamp, step past it so we can't loop forever
+		if last <= since {
+			lastTime, err := time.Parse(mangaDexTimeFormat, last)
+			if err != nil {
+				return count, fmt.Errorf("parse cursor %s: %w", last, err)
+			}
+			fmt.Printf("\nWarning: window at %s is full, skipping ahead one second\n", last)
+			last = lastTime.Add(time.Second).Format(mangaDexTimeFormat)
+		}
+		since = last
+	}
+}
+
+func (c mangaCursor) searchOpts(since string, offset int32) mangadex.MangaApiGetSearchMangaOpts {
+	opts := mangadex.MangaApiGetSearchMangaOpts{}
+	opts.Limit = optional.NewInt32(c.limit)
+	opts.Offset = optional.NewInt32(offset)
+	switch c.field {
+	case cursorCreatedAt:
+		opts.OrderCreatedAt = optional.NewString("asc")
+		if since != "" {
+			opts.CreatedAtSince = optional.NewString(since)
+		}
+	case cursorUpdatedAt:
+		opts.OrderUpdatedAt = optional.NewString("asc")
+		if since != "" {
+			opts.UpdatedAtSince = optional.NewString(since)
+		}
+	}
+	return opts
+}
+
+// timestamp returns the cursor field of the manga in the format the API filters accept
+func (c mangaCursor) timestamp(apiManga mangadex.Manga) string {
+	if apiManga.Attributes == nil {
+		return ""
+	}
+	value := apiManga.Attributes.CreatedAt
+	if c.field == cursorUpdatedAt {
+		value = apiManga.Attributes.UpdatedAt
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return ""
+	}
+	return parsed.UTC().Format(mangaDexTimeFormat)
+}
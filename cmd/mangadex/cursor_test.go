@@ -0,0 +1,76 @@
+package mangadex
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/similar-manga/similar/mangadex"
+	"github.com/similar-manga/similar/mangadex/mangadextest"
+)
+
+func TestMangaCursorCrawlsPastOffsetCeiling(t *testing.T) {
+	const total = 12345
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	allManga := make([]mangadex.Manga, total)
+	for i := range allManga {
+		allManga[i] = mangadex.Manga{
+			Id: fmt.Sprintf("uuid-%05d", i),
+			Attributes: &mangadex.MangaAttributes{
+				CreatedAt: start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339),
+			},
+		}
+	}
+	server := mangadextest.NewServer(allManga...)
+	defer server.Close()
+
+	client := CreateMangaDexClient(0)
+	client.ChangeBasePath(server.URL)
+
+	collected := make(map[string]int)
+	cursor := mangaCursor{field: cursorCreatedAt, limit: 100}
+	count, err := cursor.crawl(func(opts mangadex.MangaApiGetSearchMangaOpts) (mangadex.MangaList, error) {
+		return SearchMangaDex(client, context.Background(), opts)
+	}, func(mangaList []mangadex.Manga) error {
+		for _, m := range mangaList {
+			collected[m.Id]++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range server.Requests() {
+		if query.Get("order[createdAt]") != "asc" {
+			t.Fatalf("expected ascending createdAt order, got %q", query.Get("order[createdAt]"))
+		}
+	}
+	if count != total || len(collected) != total {
+		t.Fatalf("expected %d manga to be collected, got %d (%d unique)", total, count, len(collected))
+	}
+	for id, seen := range collected {
+		if seen != 1 {
+			t.Errorf("manga %s handled %d times", id, seen)
+		}
+	}
+}
+
+func TestMangaCursorSearchOpts(t *testing.T) {
+	created := mangaCursor{field: cursorCreatedAt, limit: 50}.searchOpts("2024-01-01T00:00:00", 100)
+	if created.OrderCreatedAt.Value() != "asc" || created.CreatedAtSince.Value() != "2024-01-01T00:00:00" {
+		t.Errorf("unexpected createdAt opts %+v", created)
+	}
+	if created.UpdatedAtSince.IsSet() || created.OrderUpdatedAt.IsSet() {
+		t.Error("createdAt cursor should not set updatedAt options")
+	}
+	if created.Limit.Value() != 50 || created.Offset.Value() != 100 {
+		t.Errorf("unexpected paging %d/%d", created.Limit.Value(), created.Offset.Value())
+	}
+
+	updated := mangaCursor{field: cursorUpdatedAt, limit: 50}.searchOpts("", 0)
+	if updated.OrderUpdatedAt.Value() != "asc" || updated.UpdatedAtSince.IsSet() {
+		t.Errorf("unexpected updatedAt opts %+v", updated)
+	}
+}
@@ -6,18 +6,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/antihax/optional"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/similar-manga/similar/internal"
 	"github.com/similar-manga/similar/mangadex"
-	"go.uber.org/ratelimit"
-	"log"
+	"github.com/spf13/cobra"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 )
 
-func ApiMangaToJson(apiManga mangadex.Manga) []byte {
+func ApiMangaToJson(apiManga mangadex.Manga) ([]byte, error) {
 	tags := make([]internal.Tag, 0, len(apiManga.Attributes.Tags))
 	for _, r := range apiManga.Attributes.Tags {
 		tags = append(tags, internal.Tag{
@@ -45,72 +45,114 @@ func ApiMangaToJson(apiManga mangadex.Manga) []byte {
 		PublicationDemographic:       apiManga.Attributes.PublicationDemographic,
 		ContentRating:                apiManga.Attributes.ContentRating,
 		Tags:                         tags,
+		CoverFileName:                coverFileName(apiManga.Relationships),
+		CreatedAt:                    apiManga.Attributes.CreatedAt,
 	}
 
 	dst := &bytes.Buffer{}
-	jsonManga, _ := json.Marshal(manga)
-	err := json.Compact(dst, jsonManga)
-	internal.CheckErr(err)
-	return dst.Bytes()
+	jsonManga, err := json.Marshal(manga)
+	if err != nil {
+		return nil, fmt.Errorf("marshal manga %s: %w", apiManga.Id, err)
+	}
+	if err := json.Compact(dst, jsonManga); err != nil {
+		return nil, fmt.Errorf("compact manga %s: %w", apiManga.Id, err)
+	}
+	return dst.Bytes(), nil
+}
+
+// coverFileName returns the file name of the main cover, only known when the search
+// expanded the cover_art relationship.
+func coverFileName(relationships []mangadex.Relationship) string {
+	for _, r := range relationships {
+		if r.Type_ != "cover_art" || r.Attributes == nil {
+			continue
+		}
+		if attributes, ok := (*r.Attributes).(map[string]interface{}); ok {
+			if fileName, ok := attributes["fileName"].(string); ok {
+				return fileName
+			}
+		}
+	}
+	return ""
 }
 
-func CreateMangaDexClient() *mangadex.APIClient {
+// CreateMangaDexClient creates a client allowing one request per interval. Replayed
+// responses are not rate limited.
+func CreateMangaDexClient(interval time.Duration) *mangadex.APIClient {
 	config := mangadex.NewConfiguration()
 	config.UserAgent = "similar-manga v3.0"
 	config.HTTPClient = &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout:   30 * time.Second,
+		Transport: internal.HTTPTransport,
 	}
+	if internal.HTTPTransport.Mode() == internal.HTTPModeReplay {
+		interval = 0
+	}
+	config.RateLimitInterval = interval
 	return mangadex.NewAPIClient(config)
 }
 
-func SearchMangaDex(rateLimiter ratelimit.Limiter, client *mangadex.APIClient, ctx context.Context, opts mangadex.MangaApiGetSearchMangaOpts) mangadex.MangaList {
-	maxRetries := 10
-	mangaList := mangadex.MangaList{}
-	resp := &http.Response{}
-	err := errors.New("startup")
+// newCommandClient creates the client for a mangadex subcommand, honouring the --base-url
+// and --request-interval flags.
+func newCommandClient(cmd *cobra.Command, interval time.Duration) *mangadex.APIClient {
+	if cmd.Flags().Changed("request-interval") {
+		interval, _ = cmd.Flags().GetDuration("request-interval")
+	}
+	client := CreateMangaDexClient(interval)
+	if baseURL, _ := cmd.Flags().GetString("base-url"); baseURL != "" {
+		client.ChangeBasePath(strings.TrimSuffix(baseURL, "/"))
+	}
+	return client
+}
 
-	for retryCount := 0; retryCount <= maxRetries && err != nil; retryCount++ {
-		rateLimiter.Take()
+// SearchMangaDex retries the search until it succeeds, the client's rate limiter takes care
+// of backing off when MangaDex throttles us. Errors MangaDex won't recover from, like a bad
+// request, are returned straight away. The cover art is always expanded so its file name is
+// stored with the manga.
+func SearchMangaDex(client *mangadex.APIClient, ctx context.Context, opts mangadex.MangaApiGetSearchMangaOpts) (mangadex.MangaList, error) {
+	if !opts.Includes.IsSet() {
+		opts.Includes = optional.NewInterface([]string{"cover_art"})
+	}
+	maxRetries := 10
+	var err error
+	for retryCount := 0; retryCount <= maxRetries; retryCount++ {
+		var mangaList mangadex.MangaList
+		var resp *http.Response
 		mangaList, resp, err = client.MangaApi.GetSearchManga(ctx, &opts)
-		if err != nil {
-			fmt.Printf("\u001B[1;31mMANGA ERROR (%d of %d): Status Code %v : %v\u001B[0m\n", retryCount, maxRetries, resp.StatusCode, err)
-			if err.Error() == "undefined response type text/html; charset=utf-8" {
-				fmt.Println("Sleeping 5 secs since we likely hit the soft rate limit")
-				time.Sleep(5 * time.Second)
-			}
-		} else if resp == nil {
-			err = errors.New("invalid response object")
-			fmt.Printf("\u001B[1;31mMANGA ERROR (%d of %d): respose object is nil\u001B[0m\n", retryCount, maxRetries)
-			continue
-		} else if resp.StatusCode != 200 && resp.StatusCode != 204 {
-			err = errors.New("invalid http error code")
-			fmt.Printf("\u001B[1;31mMANGA ERROR (%d of %d): http code %d\u001B[0m\n", retryCount, maxRetries, resp.StatusCode)
-		} else if resp.StatusCode == 429 {
-			err = errors.New("rate limited")
-			fmt.Printf("\u001B[1;31mRate Limited!! Sleeping. (%d of %d): http code %d\u001B[0m\n", retryCount, maxRetries, resp.StatusCode)
-			time.Sleep(time.Duration(int64(500)) * time.Millisecond)
-
+		if err == nil && resp.StatusCode != 200 && resp.StatusCode != 204 {
+			err = fmt.Errorf("unexpected http code %d", resp.StatusCode)
 		}
-
 		if err == nil {
-			//ignore the error if it fails to close
-			_ = resp.Body.Close()
+			return mangaList, nil
+		}
+		fmt.Printf("\u001B[1;31mMANGA ERROR (%d of %d): %v\u001B[0m\n", retryCount, maxRetries, err)
+
+		var apiErr *mangadex.APIError
+		if ctx.Err() != nil || (errors.As(err, &apiErr) && !apiErr.Temporary()) {
+			break
 		}
 	}
-	return mangaList
+	return mangadex.MangaList{}, fmt.Errorf("search manga: %w", err)
+}
 
+// printRateLimitMetrics reports how much the client was throttled during a run.
+func printRateLimitMetrics(client *mangadex.APIClient) {
+	metrics := client.RateLimiter().Metrics()
+	fmt.Printf("MangaDex requests: %d, throttled: %d, backoffs: %d, waited %s\n", metrics.Requests, metrics.Throttled, metrics.Backoffs, metrics.TotalWait.Round(time.Millisecond))
 }
 
-func ExistsInDatabase(uuid string) bool {
+func ExistsInDatabase(uuid string) (bool, error) {
 	rows, err := internal.DB.Query("SELECT 1 FROM "+internal.TableManga+" WHERE UUID= ?", uuid)
-	internal.CheckErr(err)
+	if err != nil {
+		return false, fmt.Errorf("query manga %s: %w", uuid, err)
+	}
 	defer rows.Close()
-	return rows.Next()
+	return rows.Next(), rows.Err()
 }
 
-func GetExistingMangaUUIDs(uuids []string) map[string]bool {
+func GetExistingMangaUUIDs(uuids []string) (map[string]bool, error) {
 	if len(uuids) == 0 {
-		return make(map[string]bool)
+		return make(map[string]bool), nil
 	}
 
 	existing := make(map[string]bool)
@@ -135,96 +177,142 @@ func GetExistingMangaUUIDs(uuids []string) map[string]bool {
 		}
 
 		query := fmt.Sprintf("SELECT UUID FROM %s WHERE UUID IN (%s)", internal.TableManga, strings.Join(placeholders, ","))
-		rows, err := internal.DB.Query(query, args...)
-		internal.CheckErr(err)
-
-		func() {
+		err := func() error {
+			rows, err := internal.DB.Query(query, args...)
+			if err != nil {
+				return err
+			}
 			defer rows.Close()
 			for rows.Next() {
 				var uuid string
-				err := rows.Scan(&uuid)
-				internal.CheckErr(err)
+				if err := rows.Scan(&uuid); err != nil {
+					return err
+				}
 				existing[uuid] = true
 			}
-			internal.CheckErr(rows.Err())
+			return rows.Err()
 		}()
+		if err != nil {
+			return nil, fmt.Errorf("query existing manga: %w", err)
+		}
 	}
-	return existing
+	return existing, nil
 }
 
-func UpsertManga(apiManga mangadex.Manga) {
-	jsonManga := ApiMangaToJson(apiManga)
+func UpsertManga(apiManga mangadex.Manga) error {
+	jsonManga, err := ApiMangaToJson(apiManga)
+	if err != nil {
+		return err
+	}
 	currentDate := strings.Split(time.Now().UTC().Format(time.RFC3339), "Z")[0]
-	_, err := internal.DB.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?) ON CONFLICT (UUID) DO UPDATE SET JSON=excluded.JSON", apiManga.Id, jsonManga, currentDate)
-	internal.CheckErr(err)
+	_, err = internal.DB.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?) ON CONFLICT (UUID) DO UPDATE SET JSON=excluded.JSON", apiManga.Id, jsonManga, currentDate)
+	if err != nil {
+		return fmt.Errorf("upsert manga %s: %w", apiManga.Id, err)
+	}
+	return nil
 }
 
-func BatchUpsertManga(mangas []mangadex.Manga) {
+func BatchUpsertManga(mangas []mangadex.Manga) error {
 	if len(mangas) == 0 {
-		return
+		return nil
 	}
 
 	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("begin manga upsert: %w", err)
+	}
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare("INSERT INTO " + internal.TableManga + " (UUID, JSON, DATE) VALUES (?, ?, ?) ON CONFLICT (UUID) DO UPDATE SET JSON=excluded.JSON")
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("prepare manga upsert: %w", err)
+	}
 	defer stmt.Close()
 
 	currentDate := strings.Split(time.Now().UTC().Format(time.RFC3339), "Z")[0]
 	for _, apiManga := range mangas {
-		jsonManga := ApiMangaToJson(apiManga)
-		_, err = stmt.Exec(apiManga.Id, jsonManga, currentDate)
-		internal.CheckErr(err)
+		jsonManga, err := ApiMangaToJson(apiManga)
+		if err != nil {
+			return err
+		}
+		if _, err = stmt.Exec(apiManga.Id, jsonManga, currentDate); err != nil {
+			return fmt.Errorf("upsert manga %s: %w", apiManga.Id, err)
+		}
 	}
 
-	err = tx.Commit()
-	internal.CheckErr(err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit manga upsert: %w", err)
+	}
+	return nil
 }
 
-func getDBManga() []internal.DbManga {
+func getDBManga() ([]internal.DbManga, error) {
 	rows, err := internal.DB.Query("SELECT UUID, JSON, DATE FROM " + internal.TableManga + " ORDER BY DATE ASC")
-	internal.CheckErr(err)
+	if err != nil {
+		return nil, fmt.Errorf("query manga: %w", err)
+	}
 	defer rows.Close()
 
 	var mangaList []internal.DbManga
 	for rows.Next() {
 		manga := internal.DbManga{}
-		err = rows.Scan(&manga.Id, &manga.JSON, &manga.DATE)
-		internal.CheckErr(err)
+		if err := rows.Scan(&manga.Id, &manga.JSON, &manga.DATE); err != nil {
+			return nil, fmt.Errorf("scan manga: %w", err)
+		}
 		mangaList = append(mangaList, manga)
 	}
-	internal.CheckErr(rows.Err())
-	return mangaList
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate manga: %w", err)
+	}
+	return mangaList, nil
 }
 
-func ExportManga() {
+func ExportManga() error {
 	fmt.Printf("Exporting All Manga to txt files\n")
-	os.RemoveAll("data/manga/")
-	os.MkdirAll("data/manga/", 0777)
-	mangaList := getDBManga()
+	mangaList, err := getDBManga()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll("data/manga/"); err != nil {
+		return fmt.Errorf("remove manga export: %w", err)
+	}
+	if err := os.MkdirAll("data/manga/", 0777); err != nil {
+		return fmt.Errorf("create manga export: %w", err)
+	}
+
 	suffix := 1
-	file := createMangaFile(suffix)
+	file, err := createMangaFile(suffix)
+	if err != nil {
+		return err
+	}
 	for index, manga := range mangaList {
 		if index > 0 && index%1000 == 0 {
 			suffix++
-			file.Close()
-			file = createMangaFile(suffix)
+			if err := file.Close(); err != nil {
+				return fmt.Errorf("close manga export: %w", err)
+			}
+			if file, err = createMangaFile(suffix); err != nil {
+				return err
+			}
 		}
 
-		file.WriteString(manga.Id + ":::||@!@||:::" + manga.DATE + ":::||@!@||:::" + manga.JSON + "\n")
+		if _, err := file.WriteString(manga.Id + ":::||@!@||:::" + manga.DATE + ":::||@!@||:::" + manga.JSON + "\n"); err != nil {
+			file.Close()
+			return fmt.Errorf("write manga export: %w", err)
+		}
 
 	}
 
-	file.Close()
-
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close manga export: %w", err)
+	}
+	return nil
 }
 
-func createMangaFile(number int) *os.File {
+func createMangaFile(number int) (*os.File, error) {
 	file, err := os.Create("data/manga/manga_" + fmt.Sprintf("%04d", number) + ".txt")
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("create manga export: %w", err)
 	}
-	return file
+	return file, nil
 }
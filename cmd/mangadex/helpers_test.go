@@ -78,12 +78,12 @@ func TestExistsInDatabase(t *testing.T) {
 	internal.DB = db
 
 	// Test existing
-	if !ExistsInDatabase("uuid-1") {
+	if exists, err := ExistsInDatabase("uuid-1"); err != nil || !exists {
 		t.Error("uuid-1 should exist")
 	}
 
 	// Test non-existing
-	if ExistsInDatabase("uuid-9999") {
+	if exists, err := ExistsInDatabase("uuid-9999"); err != nil || exists {
 		t.Error("uuid-9999 should not exist")
 	}
 }
@@ -94,7 +94,10 @@ func TestGetExistingMangaUUIDs(t *testing.T) {
 	internal.DB = db
 
 	uuids := []string{"uuid-1", "uuid-2", "uuid-9999"}
-	existing := GetExistingMangaUUIDs(uuids)
+	existing, err := GetExistingMangaUUIDs(uuids)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if !existing["uuid-1"] {
 		t.Error("uuid-1 should exist")
@@ -122,7 +125,10 @@ func TestGetExistingMangaUUIDs_Chunking(t *testing.T) {
 		uuids[i] = fmt.Sprintf("uuid-%d", i)
 	}
 
-	existing := GetExistingMangaUUIDs(uuids)
+	existing, err := GetExistingMangaUUIDs(uuids)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// uuid-0 to uuid-999 should exist (1000)
 	// uuid-1000 to uuid-1099 should not exist (100)
@@ -21,4 +21,7 @@ Actions related to the querying, and parsing of the MangaDex api.`,
 
 func init() {
 	cmd.RootCmd.AddCommand(mangadexCmd)
+	cmd.UsesDatabase(mangadexCmd, cmd.DatabaseReadWrite)
+	mangadexCmd.PersistentFlags().String("base-url", "", "MangaDex API to query instead of https://api.mangadex.org, such as a local mirror or test server")
+	mangadexCmd.PersistentFlags().Duration("request-interval", 0, "Minimum time between two requests, overriding the default of the command")
 }
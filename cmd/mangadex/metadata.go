@@ -3,13 +3,13 @@ package mangadex
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/antihax/optional"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/similar-manga/similar/internal"
 	"github.com/similar-manga/similar/mangadex"
 	"github.com/spf13/cobra"
-	"go.uber.org/ratelimit"
 	"os"
 	"strings"
 	"time"
@@ -19,109 +19,183 @@ var metadataCmd = &cobra.Command{
 	Use:   "metadata",
 	Short: "This queries every manga uuid and updates the metadata",
 	Long:  `Query MangaDex for every given manga and mangadex the json metadata in the database`,
-	Run:   runMetadata,
+	RunE:  runMetadata,
 }
 
 func init() {
 	mangadexCmd.AddCommand(metadataCmd)
 	metadataCmd.Flags().BoolP("all", "a", false, "queries and updates the entire database")
 	metadataCmd.Flags().StringP("id", "i", "", "update metadata for a specific uuid in the database")
+	metadataCmd.Flags().Bool("restart", false, "with --all, discard any saved checkpoint and start from the first batch")
+	metadataCmd.Flags().Float64("max-removed", 0.05, "with --all, abort purging removed manga if more than this fraction of the database is missing")
 
 }
 
-func runMetadata(cmd *cobra.Command, args []string) {
+func runMetadata(cmd *cobra.Command, args []string) error {
 	start := time.Now()
 
 	updateAll, _ := cmd.Flags().GetBool("all")
 	updateId, _ := cmd.Flags().GetString("id")
+	restart, _ := cmd.Flags().GetBool("restart")
+	maxRemoved, _ := cmd.Flags().GetFloat64("max-removed")
 
-	client := CreateMangaDexClient()
-	ctx := context.Background()
+	// Full and single refreshes run at one request per second, incremental at one per two
+	interval := time.Second
+	if !updateAll && updateId == "" {
+		interval = 2 * time.Second
+	}
+	client := newCommandClient(cmd, interval)
+	ctx := cmd.Context()
 
+	// Only a completed full or incremental pass may move the last update time forward
+	var updatedThrough string
+	var err error
 	if updateAll {
-		fmt.Printf("Getting mangadex metadata for all entries\n")
-
-		rateLimiter := ratelimit.New(1)
-
-		mangaIdArray := collectAllMangaIds()
-
-		for index, ids := range mangaIdArray {
+		updatedThrough, err = refreshAllMetadata(ctx, client, start, restart, maxRemoved)
+	} else if updateId != "" {
+		err = refreshMetadataById(ctx, client, updateId)
+	} else {
+		updatedThrough, err = refreshMetadataSinceLastUpdate(ctx, client, start)
+	}
 
-			opts := mangadex.MangaApiGetSearchMangaOpts{}
-			opts.OrderCreatedAt = optional.NewString("desc")
-			opts.Limit = optional.NewInt32(100)
-			opts.Ids = optional.NewInterface(ids)
+	if err == nil && updatedThrough != "" {
+		err = os.WriteFile("data/last_metadata_update.txt", []byte(updatedThrough), 0755)
+		if err != nil {
+			err = fmt.Errorf("write last metadata update: %w", err)
+		}
+	}
 
-			printProgress(index+1, len(mangaIdArray))
+	// Export whatever was updated even if the refresh failed part way
+	err = errors.Join(err, ExportManga())
 
-			mangaList := SearchMangaDex(rateLimiter, client, ctx, opts)
+	printRateLimitMetrics(client)
+	fmt.Printf("\t- Finished in %s\n", time.Since(start))
+	return err
+}
 
-			BatchUpsertManga(mangaList.Data)
+// refreshAllMetadata updates every manga in the database, resuming from the saved checkpoint
+// if a previous run stopped part way. It returns the start time of the pass once complete.
+func refreshAllMetadata(ctx context.Context, client *mangadex.APIClient, start time.Time, restart bool, maxRemoved float64) (string, error) {
+	if restart {
+		fmt.Println("Discarding any saved checkpoint")
+		if err := clearMetadataCheckpoint(); err != nil {
+			return "", err
 		}
-		fmt.Println()
+	}
+	checkpoint, resumed, err := loadMetadataCheckpoint()
+	if err != nil {
+		return "", err
+	}
+	if resumed {
+		fmt.Printf("Resuming run %s started %s after batch %d (%s)\n", checkpoint.RunId, checkpoint.StartedAt, checkpoint.LastBatch, checkpoint.LastUUID)
+	} else {
+		if checkpoint, err = newMetadataCheckpoint(start); err != nil {
+			return "", err
+		}
+		if err := saveMetadataCheckpoint(checkpoint); err != nil {
+			return "", err
+		}
+	}
+	fmt.Printf("Getting mangadex metadata for all entries\n")
 
-	} else if updateId != "" {
-		fmt.Printf("Updating MangaDex metadata for %s\n", updateId)
-		rateLimiter := ratelimit.New(1)
+	mangaIdArray, err := collectAllMangaIds(checkpoint.LastUUID)
+	if err != nil {
+		return "", err
+	}
+	totalBatches := checkpoint.LastBatch + len(mangaIdArray)
+
+	for _, ids := range mangaIdArray {
 
 		opts := mangadex.MangaApiGetSearchMangaOpts{}
 		opts.OrderCreatedAt = optional.NewString("desc")
-		opts.Limit = optional.NewInt32(1)
-		opts.Ids = optional.NewInterface([]string{updateId})
-		mangaList := SearchMangaDex(rateLimiter, client, ctx, opts)
-		BatchUpsertManga(mangaList.Data)
-
-	} else {
-		rateLimiter := ratelimit.New(1, ratelimit.Per(2*time.Second))
+		opts.Limit = optional.NewInt32(100)
+		opts.Ids = optional.NewInterface(ids)
 
-		readFile, err := os.Open("data/last_metadata_update.txt")
-		internal.CheckErr(err)
-		fileScanner := bufio.NewScanner(readFile)
+		printProgress(checkpoint.LastBatch+1, totalBatches)
 
-		fileScanner.Split(bufio.ScanLines)
-
-		var lastUpdatedTime string
-		for fileScanner.Scan() {
-			lastUpdatedTime = fileScanner.Text()
+		mangaList, err := SearchMangaDex(client, ctx, opts)
+		if err != nil {
+			fmt.Println()
+			if errors.Is(err, context.Canceled) {
+				fmt.Printf("Interrupted, %d of %d batches left, rerun with --all to resume\n", totalBatches-checkpoint.LastBatch, totalBatches)
+			}
+			return "", fmt.Errorf("batch %d: %w", checkpoint.LastBatch+1, err)
+		}
+		if err := BatchUpsertManga(mangaList.Data); err != nil {
+			return "", err
 		}
 
-		fmt.Printf("Getting mangadex metadata since last updated time -> %s\n", lastUpdatedTime)
+		checkpoint.Checked += len(ids)
+		checkpoint.Missing = append(checkpoint.Missing, findMissingIds(ids, mangaList.Data)...)
+		checkpoint.LastBatch++
+		checkpoint.LastUUID = ids[len(ids)-1]
+		if err := saveMetadataCheckpoint(checkpoint); err != nil {
+			return "", err
+		}
+	}
+	fmt.Println()
 
-		readFile.Close()
+	if err := purgeRemovedManga(checkpoint.Missing, checkpoint.Checked, maxRemoved); err != nil {
+		return "", err
+	}
+	if err := clearMetadataCheckpoint(); err != nil {
+		return "", err
+	}
+	return checkpoint.StartedAt, nil
+}
 
-		currentLimit := int32(100)
-		maxOffset := int32(10000)
-		done := false
+func refreshMetadataById(ctx context.Context, client *mangadex.APIClient, updateId string) error {
+	fmt.Printf("Updating MangaDex metadata for %s\n", updateId)
+	opts := mangadex.MangaApiGetSearchMangaOpts{}
+	opts.OrderCreatedAt = optional.NewString("desc")
+	opts.Limit = optional.NewInt32(1)
+	opts.Ids = optional.NewInterface([]string{updateId})
+	mangaList, err := SearchMangaDex(client, ctx, opts)
+	if err != nil {
+		return err
+	}
+	return BatchUpsertManga(mangaList.Data)
+}
 
-		for currentOffset := int32(0); currentOffset < maxOffset && done == false; currentOffset += currentLimit {
+// refreshMetadataSinceLastUpdate updates every manga changed since the last recorded update
+// and returns the start time of this pass once complete.
+func refreshMetadataSinceLastUpdate(ctx context.Context, client *mangadex.APIClient, start time.Time) (string, error) {
+	readFile, err := os.Open("data/last_metadata_update.txt")
+	if err != nil {
+		return "", fmt.Errorf("read last metadata update: %w", err)
+	}
+	fileScanner := bufio.NewScanner(readFile)
 
-			opts := mangadex.MangaApiGetSearchMangaOpts{}
-			opts.UpdatedAtSince = optional.NewString(lastUpdatedTime)
-			opts.Limit = optional.NewInt32(currentLimit)
-			opts.Offset = optional.NewInt32(currentOffset)
-			fmt.Printf("\rGetting mangadex metadata for offset %d   ", currentOffset)
+	fileScanner.Split(bufio.ScanLines)
 
-			mangaList := SearchMangaDex(rateLimiter, client, ctx, opts)
+	var lastUpdatedTime string
+	for fileScanner.Scan() {
+		lastUpdatedTime = fileScanner.Text()
+	}
+	readFile.Close()
+	if err := fileScanner.Err(); err != nil {
+		return "", fmt.Errorf("read last metadata update: %w", err)
+	}
 
-			if len(mangaList.Data) != 0 {
-				BatchUpsertManga(mangaList.Data)
-			} else {
-				done = true
-			}
+	fmt.Printf("Getting mangadex metadata since last updated time -> %s\n", lastUpdatedTime)
+
+	count := 0
+	cursor := mangaCursor{field: cursorUpdatedAt, since: lastUpdatedTime, limit: 100}
+	_, err = cursor.crawl(func(opts mangadex.MangaApiGetSearchMangaOpts) (mangadex.MangaList, error) {
+		fmt.Printf("\rGetting mangadex metadata, %d manga updated so far   ", count)
+		return SearchMangaDex(client, ctx, opts)
+	}, func(mangaList []mangadex.Manga) error {
+		count += len(mangaList)
+		return BatchUpsertManga(mangaList)
+	})
+	fmt.Println()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Printf("Interrupted after %d manga, the last update time is kept so the next run starts over\n", count)
 		}
-		fmt.Println()
-
+		return "", err
 	}
-
-	metadataFile, err := os.OpenFile("data/last_metadata_update.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-	internal.CheckErr(err)
-	_, err = metadataFile.WriteString(strings.Split(time.Now().UTC().Format(time.RFC3339), "Z")[0])
-	internal.CheckErr(err)
-	metadataFile.Close()
-
-	ExportManga()
-
-	fmt.Printf("\t- Finished in %s\n", time.Since(start))
+	return strings.Split(start.UTC().Format(time.RFC3339), "Z")[0], nil
 }
 
 func printProgress(current, total int) {
@@ -139,32 +213,36 @@ func printProgress(current, total int) {
 	fmt.Printf("\r[%s] %.2f%% (%d/%d)", bar, percent, current, total)
 }
 
-func collectAllMangaIds() [][]string {
+// collectAllMangaIds returns every manga uuid sorted after the given one, in batches of 100.
+func collectAllMangaIds(after string) ([][]string, error) {
 	var mangaIdArray [][]string
-	processing := true
-	dbOffset := 0
 
-	for processing {
-		rows, err := internal.DB.Query("SELECT UUID FROM "+internal.TableManga+" ORDER BY UUID LIMIT 100 OFFSET ?", dbOffset)
-		internal.CheckErr(err)
+	for {
+		rows, err := internal.DB.Query("SELECT UUID FROM "+internal.TableManga+" WHERE UUID > ? ORDER BY UUID LIMIT 100", after)
+		if err != nil {
+			return nil, fmt.Errorf("query manga ids: %w", err)
+		}
 
 		var mangaIds []string
 		for rows.Next() {
 			var uuid string
-			err = rows.Scan(&uuid)
-			internal.CheckErr(err)
+			if err := rows.Scan(&uuid); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan manga id: %w", err)
+			}
 			mangaIds = append(mangaIds, uuid)
 		}
-		internal.CheckErr(rows.Err())
+		err = rows.Err()
 		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("iterate manga ids: %w", err)
+		}
 
 		if len(mangaIds) == 0 {
-			processing = false
-			break
+			return mangaIdArray, nil
 		}
 
 		mangaIdArray = append(mangaIdArray, mangaIds)
-		dbOffset = dbOffset + 100
+		after = mangaIds[len(mangaIds)-1]
 	}
-	return mangaIdArray
 }
@@ -0,0 +1,117 @@
+package mangadex
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex"
+)
+
+// findMissingIds returns the requested ids which MangaDex did not return.
+func findMissingIds(requested []string, returned []mangadex.Manga) []string {
+	found := make(map[string]bool, len(returned))
+	for _, apiManga := range returned {
+		found[apiManga.Id] = true
+	}
+	var missing []string
+	for _, id := range requested {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// purgeRemovedManga tombstones manga no longer on MangaDex and deletes them from every table
+// and from the matches of other manga. Nothing is deleted and an error is returned when more
+// than maxFraction of the checked manga are missing, since that is far more likely an API
+// problem than real removals.
+func purgeRemovedManga(missingIds []string, checkedCount int, maxFraction float64) error {
+	if len(missingIds) == 0 {
+		fmt.Println("No removed manga found")
+		return nil
+	}
+	if checkedCount == 0 || float64(len(missingIds))/float64(checkedCount) > maxFraction {
+		return fmt.Errorf("refusing to purge %d of %d manga, more than %.1f%% would be removed, the checkpoint keeps them for a rerun with a higher --max-removed",
+			len(missingIds), checkedCount, maxFraction*100)
+	}
+
+	fmt.Printf("Purging %d manga removed from MangaDex\n", len(missingIds))
+	if err := removeManga(missingIds); err != nil {
+		return err
+	}
+	return exportRemovedManga()
+}
+
+func removeManga(uuids []string) error {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin manga removal: %w", err)
+	}
+	defer tx.Rollback()
+
+	removedAt := strings.Split(time.Now().UTC().Format(time.RFC3339), "Z")[0]
+	run := internal.NewMappingRun("purge removed manga")
+
+	for _, uuid := range uuids {
+		var title string
+		var jsonManga []byte
+		if err := tx.QueryRow("SELECT JSON FROM "+internal.TableManga+" WHERE UUID = ?", uuid).Scan(&jsonManga); err == nil {
+			manga := internal.Manga{}
+			if json.Unmarshal(jsonManga, &manga) == nil && manga.Title != nil {
+				title = (*manga.Title)["en"]
+			}
+		}
+
+		_, err = tx.Exec("INSERT INTO "+internal.TableMangaRemoved+" (UUID, TITLE, REMOVED_AT) VALUES (?, ?, ?) ON CONFLICT (UUID) DO NOTHING", uuid, title, removedAt)
+		if err != nil {
+			return fmt.Errorf("tombstone manga %s: %w", uuid, err)
+		}
+		if err := internal.PurgeManga(tx, uuid, run); err != nil {
+			return err
+		}
+		fmt.Printf("Removed manga %s %s\n", uuid, title)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit manga removal: %w", err)
+	}
+	return nil
+}
+
+// exportRemovedManga writes every tombstoned manga so consumers can drop them too.
+func exportRemovedManga() error {
+	rows, err := internal.DB.Query("SELECT UUID, REMOVED_AT FROM " + internal.TableMangaRemoved + " ORDER BY REMOVED_AT ASC, UUID ASC")
+	if err != nil {
+		return fmt.Errorf("query removed manga: %w", err)
+	}
+	defer rows.Close()
+
+	file, err := os.Create("data/removed_manga.txt")
+	if err != nil {
+		return fmt.Errorf("create removed manga export: %w", err)
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	for rows.Next() {
+		var uuid, removedAt string
+		if err := rows.Scan(&uuid, &removedAt); err != nil {
+			return fmt.Errorf("scan removed manga: %w", err)
+		}
+		if _, err = writer.WriteString(uuid + ":::||@!@||:::" + removedAt + "\n"); err != nil {
+			return fmt.Errorf("write removed manga export: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate removed manga: %w", err)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("write removed manga export: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,108 @@
+package mangadex
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex"
+)
+
+func setupRemovedTestDB(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	originalDB := internal.DB
+	internal.DB = db
+	t.Cleanup(func() {
+		internal.DB = originalDB
+		db.Close()
+	})
+
+	schemas := []string{
+		"CREATE TABLE " + internal.TableManga + " (UUID TEXT PRIMARY KEY, JSON TEXT, DATE TEXT)",
+		"CREATE TABLE " + internal.TableSimilar + " (UUID TEXT PRIMARY KEY, JSON BLOB)",
+	}
+	for _, site := range internal.MappingSites {
+		schemas = append(schemas, "CREATE TABLE "+site.Table+" (UUID TEXT PRIMARY KEY, ID TEXT)")
+	}
+	for _, schema := range schemas {
+		if _, err := db.Exec(schema); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := internal.Migrate(db, internal.DataMigrations); err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range []string{"uuid-1", "uuid-2"} {
+		if _, err := db.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?)", uuid, `{"title":{"en":"Title `+uuid+`"}}`, "2023-01-01"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO "+internal.TableSimilar+" (UUID, JSON) VALUES (?, '{}')", uuid); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO "+internal.TableAnilist+" (UUID, ID) VALUES (?, '1')", uuid); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func countRows(t *testing.T, table string, uuid string) int {
+	var count int
+	if err := internal.DB.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE UUID = ?", uuid).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestFindMissingIds(t *testing.T) {
+	missing := findMissingIds([]string{"a", "b", "c"}, []mangadex.Manga{{Id: "b"}})
+	if !slices.Equal(missing, []string{"a", "c"}) {
+		t.Errorf("expected [a c], got %v", missing)
+	}
+	if missing := findMissingIds([]string{"a"}, []mangadex.Manga{{Id: "a"}}); len(missing) != 0 {
+		t.Errorf("expected nothing missing, got %v", missing)
+	}
+}
+
+func TestRemoveManga(t *testing.T) {
+	setupRemovedTestDB(t)
+
+	if err := removeManga([]string{"uuid-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{internal.TableManga, internal.TableSimilar, internal.TableAnilist} {
+		if countRows(t, table, "uuid-1") != 0 {
+			t.Errorf("uuid-1 should be removed from %s", table)
+		}
+		if countRows(t, table, "uuid-2") != 1 {
+			t.Errorf("uuid-2 should be kept in %s", table)
+		}
+	}
+
+	var title string
+	if err := internal.DB.QueryRow("SELECT TITLE FROM " + internal.TableMangaRemoved + " WHERE UUID = 'uuid-1'").Scan(&title); err != nil {
+		t.Fatalf("expected a tombstone for uuid-1: %v", err)
+	}
+	if title != "Title uuid-1" {
+		t.Errorf("expected tombstone title, got %q", title)
+	}
+}
+
+func TestPurgeRemovedMangaThreshold(t *testing.T) {
+	setupRemovedTestDB(t)
+
+	// Half the database missing is over the threshold so nothing may be touched
+	if err := purgeRemovedManga([]string{"uuid-1"}, 2, 0.05); err == nil {
+		t.Fatal("expected purging over the safety threshold to fail")
+	}
+
+	if countRows(t, internal.TableManga, "uuid-1") != 1 {
+		t.Error("uuid-1 should not be purged when over the safety threshold")
+	}
+}
@@ -1,6 +1,7 @@
 package neko
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"slices"
@@ -73,7 +74,10 @@ func BenchmarkNekoExport(b *testing.B) {
 	// Load mappings for benchmark
 	mappings := make(map[string]map[string]string)
 	for _, table := range mappingTables {
-		mappings[table] = getAllMappings(table)
+		mappings[table], err = getAllMappings(table)
+		if err != nil {
+			b.Fatal(err)
+		}
 	}
 
 	b.ResetTimer()
@@ -82,7 +86,9 @@ func BenchmarkNekoExport(b *testing.B) {
 		if err != nil {
 			b.Fatalf("Failed to begin transaction: %v", err)
 		}
-		processMangaList(tx, slices.Values(mangaList), mappings)
+		if _, err := processMangaList(context.Background(), tx, slices.Values(mangaList), mappings); err != nil {
+			b.Fatal(err)
+		}
 		tx.Rollback()
 	}
 }
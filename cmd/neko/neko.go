@@ -1,13 +1,14 @@
 package neko
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"iter"
 	"github.com/similar-manga/similar/cmd"
 	"github.com/similar-manga/similar/internal"
 	"github.com/spf13/cobra"
-	"io"
 	"os"
 	"time"
 )
@@ -16,7 +17,7 @@ var nekoCmd = &cobra.Command{
 	Use:   "neko",
 	Short: "Generate a neko mapping database",
 	Long:  `Generate the neko mapping database file`,
-	Run:   runNeko,
+	RunE:  runNeko,
 }
 
 var mappingTables = []string{
@@ -24,54 +25,161 @@ var mappingTables = []string{
 	internal.TableAnimePlanet,
 	internal.TableBookWalker,
 	internal.TableKitsu,
+	internal.TableKitsuResolved,
 	internal.TableMyanimelist,
 	internal.TableMangaupdates,
 	internal.TableMangaupdatesNewId,
 	internal.TableNovelUpdates,
+	internal.TableAmazon,
+	internal.TableEbookJapan,
+	internal.TableRaw,
+	internal.TableEnglishTl,
 }
 
+// StableNekoName is the neko database updated in place, served under one file name.
+const StableNekoName = "neko_mapping"
+
 func init() {
 	cmd.RootCmd.AddCommand(nekoCmd)
+	cmd.UsesDatabase(nekoCmd, cmd.DatabaseReadWrite)
+	nekoCmd.Flags().String("update", "", "Update the neko database data/<name>.db in place instead of creating a dated copy")
+	nekoCmd.Flags().Lookup("update").NoOptDefVal = StableNekoName
 }
 
-func runNeko(command *cobra.Command, args []string) {
+func runNeko(command *cobra.Command, args []string) error {
 	initialStart := time.Now()
+	update, _ := command.Flags().GetString("update")
 
-	nekoDb := createNekoMappingDB()
+	var nekoDb *sql.DB
+	var err error
+	// A dated export continues from the data version of the previous ones
+	var previousVersion int64
+	if update != "" {
+		nekoDb, err = openNekoMappingDB(update)
+	} else {
+		if previousVersion, err = previousDataVersion("data"); err != nil {
+			return err
+		}
+		nekoDb, err = createNekoMappingDB(time.Now().Format(time.DateOnly) + "_neko_mapping")
+	}
+	if err != nil {
+		return err
+	}
+	defer nekoDb.Close()
 	fmt.Println("Starting neko export")
 
 	mappings := make(map[string]map[string]string)
 	for _, table := range mappingTables {
-		mappings[table] = getAllMappings(table)
+		if mappings[table], err = getAllMappings(table); err != nil {
+			return err
+		}
+	}
+
+	if update != "" {
+		return runNekoUpdate(command.Context(), nekoDb, mappings, initialStart)
+	}
+
+	tx, err := nekoDb.Begin()
+	if err != nil {
+		return fmt.Errorf("begin neko export: %w", err)
+	}
+	defer tx.Rollback()
+
+	count, err := processMangaList(command.Context(), tx, internal.StreamAllManga(), mappings)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	version, versionErr := bumpDataVersion(tx, previousVersion, time.Now())
+	if versionErr != nil {
+		return versionErr
 	}
 
+	// An interrupted export still commits the manga written so far
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit neko export: %w", err)
+	}
+	if err != nil {
+		fmt.Printf("Interrupted, exported %d manga, the rest were skipped\n", count)
+		return fmt.Errorf("neko export: %w", err)
+	}
+	if err := checkIntegrity(nekoDb); err != nil {
+		return err
+	}
+	fmt.Printf("Finished neko export of %d manga, data version %d, in %s\n", count, version, time.Since(initialStart))
+	return nil
+}
+
+// runNekoUpdate brings an existing neko database up to date. An interrupted update is rolled
+// back, removed manga are only known once every manga was seen.
+func runNekoUpdate(ctx context.Context, nekoDb *sql.DB, mappings map[string]map[string]string, start time.Time) error {
 	tx, err := nekoDb.Begin()
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("begin neko update: %w", err)
+	}
+	defer tx.Rollback()
 
-	processMangaList(tx, internal.StreamAllManga(), mappings)
+	changes, err := updateMangaList(ctx, tx, internal.StreamAllManga(), mappings)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Interrupted, the neko database was left unchanged")
+	}
+	if err != nil {
+		return fmt.Errorf("neko update: %w", err)
+	}
 
-	err = tx.Commit()
-	internal.CheckErr(err)
-	fmt.Printf("Finished neko export in %s\n", time.Since(initialStart))
+	version, err := getDataVersion(tx)
+	if err != nil {
+		return err
+	}
+	if changes.changed() || version == 0 {
+		if version, err = bumpDataVersion(tx, 0, time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit neko update: %w", err)
+	}
+	if err := checkIntegrity(nekoDb); err != nil {
+		return err
+	}
+	fmt.Printf("Finished neko update: %d inserted, %d updated, %d deleted, %d unchanged, data version %d, in %s\n",
+		changes.Inserted, changes.Updated, changes.Deleted, changes.Unchanged, version, time.Since(start))
+	return nil
 }
 
-func processMangaList(tx *sql.Tx, mangaList iter.Seq[internal.Manga], mappings map[string]map[string]string) {
-	stmt, err := tx.Prepare("INSERT INTO " + internal.TableNekoMappings + " (mdex, al, ap, bw, mu, mu_new, nu, kt , mal) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
-	internal.CheckErr(err)
+// processMangaList inserts a neko row for every manga until ctx is cancelled, returning how
+// many were inserted.
+func processMangaList(ctx context.Context, tx *sql.Tx, mangaList iter.Seq[internal.Manga], mappings map[string]map[string]string) (int, error) {
+	if err := addMissingColumns(tx); err != nil {
+		return 0, err
+	}
+	stmt, err := prepareNekoInsert(tx)
+	if err != nil {
+		return 0, err
+	}
 	defer stmt.Close()
 
+	count := 0
 	for manga := range mangaList {
-		nekoEntry := internal.DbNeko{}
-		nekoEntry.UUID = manga.Id
-
-		for table, mapping := range mappings {
-			if val, ok := mapping[manga.Id]; ok {
-				setNekoField(&nekoEntry, table, val)
-			}
+		if err := ctx.Err(); err != nil {
+			return count, err
 		}
+		if err := insertNekoEntry(stmt, newNekoEntry(manga.Id, mappings)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
 
-		insertNekoEntry(stmt, nekoEntry)
+// newNekoEntry returns the neko row of a manga with every id it is mapped to.
+func newNekoEntry(uuid string, mappings map[string]map[string]string) internal.DbNeko {
+	nekoEntry := internal.DbNeko{UUID: uuid}
+	for table, mapping := range mappings {
+		if val, ok := mapping[uuid]; ok {
+			setNekoField(&nekoEntry, table, val)
+		}
 	}
+	return nekoEntry
 }
 
 func setNekoField(nekoEntry *internal.DbNeko, table, value string) {
@@ -84,6 +192,8 @@ func setNekoField(nekoEntry *internal.DbNeko, table, value string) {
 		nekoEntry.BOOKWALKER = value
 	case internal.TableKitsu:
 		nekoEntry.KITSU = value
+	case internal.TableKitsuResolved:
+		nekoEntry.KITSU_ID = value
 	case internal.TableMyanimelist:
 		nekoEntry.MYANIMELIST = value
 	case internal.TableMangaupdates:
@@ -92,14 +202,29 @@ func setNekoField(nekoEntry *internal.DbNeko, table, value string) {
 		nekoEntry.MANGAUPDATES_NEW = value
 	case internal.TableNovelUpdates:
 		nekoEntry.NOVEL_UPDATES = value
+	case internal.TableAmazon:
+		nekoEntry.AMAZON = value
+	case internal.TableEbookJapan:
+		nekoEntry.EBOOKJAPAN = value
+	case internal.TableRaw:
+		nekoEntry.RAW = value
+	case internal.TableEnglishTl:
+		nekoEntry.ENGLISH_TL = value
 	default:
 		fmt.Fprintf(os.Stderr, "Warning: unhandled table in setNekoField: %s\n", table)
 	}
 }
 
-func getAllMappings(table string) map[string]string {
+// addMissingColumns adds the link columns an older neko database does not have.
+func addMissingColumns(tx *sql.Tx) error {
+	return internal.AddMissingColumns(tx, internal.TableNekoMappings, internal.NekoLinkColumns)
+}
+
+func getAllMappings(table string) (map[string]string, error) {
 	rows, err := internal.DB.Query("SELECT UUID, ID FROM " + table)
-	internal.CheckErr(err)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", table, err)
+	}
 	defer rows.Close()
 
 	mapping := make(map[string]string)
@@ -111,46 +236,32 @@ func getAllMappings(table string) map[string]string {
 			fmt.Printf("Warning: failed to scan row in table %s: %v\n", table, err)
 		}
 	}
-	internal.CheckErr(rows.Err())
-	return mapping
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", table, err)
+	}
+	return mapping, nil
 }
 
-func createNekoMappingDB() *sql.DB {
-	fmt.Println("Creating neko_mapping.db")
-	src, err := os.Open("data/default_empty_neko_mapping.db")
-	currentTime := time.Now().Format(time.DateOnly)
-	internal.CheckErr(err)
-	dbName := currentTime + "_neko_mapping"
-	defer src.Close()
-	dst, err := os.Create("data/" + dbName + ".db")
-	internal.CheckErr(err)
-	defer dst.Close()
-
-	buf := make([]byte, 1024)
-	for {
-		n, err := src.Read(buf)
-		if err != nil && err != io.EOF {
-			internal.CheckErr(err)
-			break
-		}
-
-		if n == 0 {
-			break
-		}
-
-		if _, err := dst.Write(buf[:n]); err != nil {
-			internal.CheckErr(err)
-			break
-		}
+// createNekoMappingDB replaces data/<dbName>.db with an empty database built by the migrations.
+func createNekoMappingDB(dbName string) (*sql.DB, error) {
+	fmt.Printf("Creating %s.db\n", dbName)
+	if err := os.Remove("data/" + dbName + ".db"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove neko database: %w", err)
 	}
+	return internal.ConnectNekoDB(dbName)
+}
 
+// openNekoMappingDB opens data/<dbName>.db, migrating it to the latest schema or creating it
+// the first time.
+func openNekoMappingDB(dbName string) (*sql.DB, error) {
+	fmt.Printf("Updating %s.db\n", dbName)
 	return internal.ConnectNekoDB(dbName)
 }
 
-func insertNekoEntry(stmt *sql.Stmt, nekoEntry internal.DbNeko) {
-	_, err := stmt.Exec(nekoEntry.UUID, nekoEntry.ANILIST, nekoEntry.ANIMEPLANET, nekoEntry.BOOKWALKER, nekoEntry.MANGAUPDATES, nekoEntry.MANGAUPDATES_NEW, nekoEntry.NOVEL_UPDATES, nekoEntry.KITSU, nekoEntry.MYANIMELIST)
+func insertNekoEntry(stmt *sql.Stmt, nekoEntry internal.DbNeko) error {
+	_, err := stmt.Exec(nekoValues(nekoEntry)...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to insert neko entry for manga %s: %v\n", nekoEntry.UUID, err)
-		fmt.Fprintln(os.Stderr, "Continuing after error.")
+		return fmt.Errorf("insert neko entry for manga %s: %w", nekoEntry.UUID, err)
 	}
+	return nil
 }
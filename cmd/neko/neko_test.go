@@ -1,7 +1,10 @@
 package neko
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"slices"
 	"testing"
 
@@ -36,7 +39,9 @@ func TestProcessMangaList(t *testing.T) {
 		t.Fatalf("Failed to begin transaction: %v", err)
 	}
 	// Pass nil for other maps
-	processMangaList(tx, slices.Values(mangaList), mappings)
+	if _, err := processMangaList(context.Background(), tx, slices.Values(mangaList), mappings); err != nil {
+		t.Fatal(err)
+	}
 	err = tx.Commit()
 	if err != nil {
 		t.Fatalf("Failed to commit transaction: %v", err)
@@ -62,3 +67,89 @@ func TestProcessMangaList(t *testing.T) {
 		t.Errorf("No rows found")
 	}
 }
+
+func TestProcessMangaListCancelled(t *testing.T) {
+	outputDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open output memory db: %v", err)
+	}
+	defer outputDB.Close()
+
+	_, err = outputDB.Exec("CREATE TABLE " + internal.TableNekoMappings + " (mdex TEXT, al TEXT, ap TEXT, bw TEXT, mu TEXT, mu_new TEXT, nu TEXT, kt TEXT, mal TEXT)")
+	if err != nil {
+		t.Fatalf("Failed to create mappings table: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mangaList := func(yield func(internal.Manga) bool) {
+		for i := 0; i < 10; i++ {
+			if i == 4 {
+				cancel()
+			}
+			if !yield(internal.Manga{Id: fmt.Sprintf("uuid-%d", i)}) {
+				return
+			}
+		}
+	}
+
+	tx, err := outputDB.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	count, err := processMangaList(ctx, tx, mangaList, map[string]map[string]string{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 manga before cancellation, got %d", count)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	var rows int
+	if err := outputDB.QueryRow("SELECT COUNT(*) FROM " + internal.TableNekoMappings).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 4 {
+		t.Errorf("Expected 4 committed rows, got %d", rows)
+	}
+}
+
+func TestProcessMangaListAddsLinkColumns(t *testing.T) {
+	outputDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outputDB.Close()
+
+	// The template database predates the link columns
+	_, err = outputDB.Exec("CREATE TABLE " + internal.TableNekoMappings + " (mdex TEXT, al TEXT, ap TEXT, bw TEXT, mu TEXT, mu_new TEXT, nu TEXT, kt TEXT, mal TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mappings := map[string]map[string]string{
+		internal.TableAmazon:    {"uuid-1": "https://www.amazon.co.jp/dp/4088725093"},
+		internal.TableEnglishTl: {"uuid-1": "https://www.viz.com/one-piece"},
+	}
+	tx, err := outputDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := processMangaList(context.Background(), tx, slices.Values([]internal.Manga{{Id: "uuid-1"}}), mappings); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var amz, ebj, engtl string
+	err = outputDB.QueryRow("SELECT amz, ebj, engtl FROM "+internal.TableNekoMappings+" WHERE mdex = 'uuid-1'").Scan(&amz, &ebj, &engtl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amz != "https://www.amazon.co.jp/dp/4088725093" || ebj != "" || engtl != "https://www.viz.com/one-piece" {
+		t.Errorf("unexpected link columns amz=%q ebj=%q engtl=%q", amz, ebj, engtl)
+	}
+}
@@ -0,0 +1,393 @@
+package neko
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var nekoDiffCmd = &cobra.Command{
+	Use:   "diff <old.db> <new.db>",
+	Short: "Write a patch turning one neko database into another",
+	Long:  "Write the rows inserted, updated and deleted between two neko databases, keyed by mdex, with the data version and checksum of both",
+	Args:  cmd.UsageArgs(cobra.ExactArgs(2)),
+	RunE:  runNekoDiff,
+}
+
+var nekoApplyCmd = &cobra.Command{
+	Use:   "apply <patch> <neko.db>",
+	Short: "Apply a patch written by neko diff to a neko database",
+	Long:  "Apply a JSON patch to the neko database it was made from, then verify the checksum of the result",
+	Args:  cmd.UsageArgs(cobra.ExactArgs(2)),
+	RunE:  runNekoApply,
+}
+
+func init() {
+	nekoCmd.AddCommand(nekoDiffCmd)
+	nekoCmd.AddCommand(nekoApplyCmd)
+	// Patches only touch neko files
+	cmd.UsesDatabase(nekoDiffCmd, cmd.DatabaseNone)
+	cmd.UsesDatabase(nekoApplyCmd, cmd.DatabaseNone)
+	nekoDiffCmd.Flags().StringP("format", "f", "json", "Patch format: json, or sql to run with sqlite3")
+	nekoDiffCmd.Flags().StringP("output", "o", "", "File the patch is written to instead of stdout")
+}
+
+// nekoPatch turns the neko database at BaseVersion into the one at TargetVersion. The
+// checksums are of the mappings rows, see nekoChecksum.
+type nekoPatch struct {
+	BaseVersion     int64  `json:"base_version"`
+	TargetVersion   int64  `json:"target_version"`
+	BaseChecksum    string `json:"base_checksum"`
+	TargetChecksum  string `json:"target_checksum"`
+	TargetUpdatedAt string `json:"target_updated_at,omitempty"`
+	// Columns names the values of each insert
+	Columns []string   `json:"columns"`
+	Inserts [][]string `json:"inserts"`
+	// Updates hold the mdex of a row and only the columns which changed
+	Updates []map[string]string `json:"updates"`
+	Deletes []string            `json:"deletes"`
+}
+
+func runNekoDiff(command *cobra.Command, args []string) error {
+	format, _ := command.Flags().GetString("format")
+	output, _ := command.Flags().GetString("output")
+	if format != "json" && format != "sql" {
+		return cmd.UsageErrorf("invalid --format %q: expected json or sql", format)
+	}
+
+	patch, err := diffNekoFiles(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("create neko patch: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if format == "sql" {
+		_, err = io.WriteString(out, patch.sql())
+	} else {
+		err = json.NewEncoder(out).Encode(patch)
+	}
+	if err != nil {
+		return fmt.Errorf("write neko patch: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Patch from data version %d to %d: %d inserts, %d updates, %d deletes\n",
+		patch.BaseVersion, patch.TargetVersion, len(patch.Inserts), len(patch.Updates), len(patch.Deletes))
+	return nil
+}
+
+func runNekoApply(command *cobra.Command, args []string) error {
+	content, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("read neko patch: %w", err)
+	}
+	patch, err := parseNekoPatch(content)
+	if err != nil {
+		return err
+	}
+	// Opening a missing file would create an empty database
+	if _, err := os.Stat(args[1]); err != nil {
+		return fmt.Errorf("open neko database: %w", err)
+	}
+	nekoDb, err := internal.OpenNekoDB(args[1])
+	if err != nil {
+		return err
+	}
+	defer nekoDb.Close()
+
+	if err := applyNekoPatch(nekoDb, patch); err != nil {
+		return err
+	}
+	if err := checkIntegrity(nekoDb); err != nil {
+		return err
+	}
+	fmt.Printf("Applied patch, %s is at data version %d\n", args[1], patch.TargetVersion)
+	return nil
+}
+
+// diffNekoFiles reads two neko databases without modifying them and returns the patch
+// between them.
+func diffNekoFiles(oldPath string, newPath string) (nekoPatch, error) {
+	var states [2]nekoState
+	for i, path := range []string{oldPath, newPath} {
+		db, err := openNekoReadOnly(path)
+		if err != nil {
+			return nekoPatch{}, err
+		}
+		states[i], err = readNekoState(db)
+		db.Close()
+		if err != nil {
+			return nekoPatch{}, fmt.Errorf("read neko database %s: %w", path, err)
+		}
+	}
+	return diffNekoStates(states[0], states[1]), nil
+}
+
+// nekoState is the content of a neko database a patch is made from.
+type nekoState struct {
+	Version   int64
+	UpdatedAt string
+	Entries   map[string]internal.DbNeko
+}
+
+func readNekoState(db nekoQuerier) (nekoState, error) {
+	var state nekoState
+	var err error
+	if state.Version, err = getDataVersion(db); err != nil {
+		return state, err
+	}
+	if state.Version > 0 {
+		err = db.QueryRow("SELECT COALESCE(updated_at, '') FROM " + internal.TableNekoMetadata + " WHERE id = 1").Scan(&state.UpdatedAt)
+		if err != nil {
+			return state, fmt.Errorf("read neko data version: %w", err)
+		}
+	}
+	state.Entries, err = getNekoEntries(db)
+	return state, err
+}
+
+// diffNekoStates returns the patch turning base into target, rows in mdex order.
+func diffNekoStates(base nekoState, target nekoState) nekoPatch {
+	patch := nekoPatch{
+		BaseVersion:     base.Version,
+		TargetVersion:   target.Version,
+		BaseChecksum:    nekoChecksum(base.Entries),
+		TargetChecksum:  nekoChecksum(target.Entries),
+		TargetUpdatedAt: target.UpdatedAt,
+		Columns:         nekoColumns,
+		Inserts:         [][]string{},
+		Updates:         []map[string]string{},
+		Deletes:         []string{},
+	}
+	for _, uuid := range slices.Sorted(maps.Keys(target.Entries)) {
+		values := nekoStrings(target.Entries[uuid])
+		current, ok := base.Entries[uuid]
+		if !ok {
+			patch.Inserts = append(patch.Inserts, values)
+			continue
+		}
+		if current == target.Entries[uuid] {
+			continue
+		}
+		update := map[string]string{"mdex": uuid}
+		currentValues := nekoStrings(current)
+		for i := 1; i < len(nekoColumns); i++ {
+			if values[i] != currentValues[i] {
+				update[nekoColumns[i]] = values[i]
+			}
+		}
+		patch.Updates = append(patch.Updates, update)
+	}
+	for _, uuid := range slices.Sorted(maps.Keys(base.Entries)) {
+		if _, ok := target.Entries[uuid]; !ok {
+			patch.Deletes = append(patch.Deletes, uuid)
+		}
+	}
+	return patch
+}
+
+// nekoStrings returns the fields of a neko row in the order of nekoColumns.
+func nekoStrings(entry internal.DbNeko) []string {
+	values := nekoValues(entry)
+	strs := make([]string, len(values))
+	for i, value := range values {
+		strs[i] = value.(string)
+	}
+	return strs
+}
+
+// nekoChecksum is the sha256 of every mappings row in mdex order, its columns in the order of
+// nekoColumns. It only depends on the data, not on how sqlite laid out the file.
+func nekoChecksum(entries map[string]internal.DbNeko) string {
+	hash := sha256.New()
+	for _, uuid := range slices.Sorted(maps.Keys(entries)) {
+		hash.Write([]byte(strings.Join(nekoStrings(entries[uuid]), "\x1f")))
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// sql returns the patch as a SQL script, its versions and checksums in a comment header. It is
+// only written for running with sqlite3 directly, neko apply reads JSON patches. The script
+// has no transaction of its own.
+func (p nekoPatch) sql() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- base_version: %d\n", p.BaseVersion)
+	fmt.Fprintf(&b, "-- target_version: %d\n", p.TargetVersion)
+	fmt.Fprintf(&b, "-- base_checksum: %s\n", p.BaseChecksum)
+	fmt.Fprintf(&b, "-- target_checksum: %s\n", p.TargetChecksum)
+	for _, values := range p.Inserts {
+		quoted := make([]string, len(values))
+		for i, value := range values {
+			quoted[i] = sqlQuote(value)
+		}
+		fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES (%s);\n", internal.TableNekoMappings, strings.Join(p.Columns, ", "), strings.Join(quoted, ", "))
+	}
+	for _, update := range p.Updates {
+		var set []string
+		for _, column := range slices.Sorted(maps.Keys(update)) {
+			if column != "mdex" {
+				set = append(set, column+" = "+sqlQuote(update[column]))
+			}
+		}
+		fmt.Fprintf(&b, "UPDATE %s SET %s WHERE mdex = %s;\n", internal.TableNekoMappings, strings.Join(set, ", "), sqlQuote(update["mdex"]))
+	}
+	for _, uuid := range p.Deletes {
+		fmt.Fprintf(&b, "DELETE FROM %s WHERE mdex = %s;\n", internal.TableNekoMappings, sqlQuote(uuid))
+	}
+	fmt.Fprintf(&b, "INSERT INTO %s (id, data_version, updated_at) VALUES (1, %d, %s) ON CONFLICT (id) DO UPDATE SET data_version = excluded.data_version, updated_at = excluded.updated_at;\n",
+		internal.TableNekoMetadata, p.TargetVersion, sqlQuote(p.TargetUpdatedAt))
+	return b.String()
+}
+
+func sqlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// parseNekoPatch reads a JSON patch.
+func parseNekoPatch(content []byte) (nekoPatch, error) {
+	var patch nekoPatch
+	if trimmed := bytes.TrimSpace(content); len(trimmed) == 0 || trimmed[0] != '{' {
+		return patch, errors.New("parse neko patch: not a JSON patch, SQL patches are meant for sqlite3 and are not applied by neko apply")
+	}
+	if err := json.Unmarshal(content, &patch); err != nil {
+		return patch, fmt.Errorf("parse neko patch: %w", err)
+	}
+	if err := patch.validate(); err != nil {
+		return patch, fmt.Errorf("parse neko patch: %w", err)
+	}
+	return patch, nil
+}
+
+// validate checks that the patch only names neko columns, they end up in SQL statements.
+func (p nekoPatch) validate() error {
+	if !slices.Equal(p.Columns, nekoColumns) {
+		return fmt.Errorf("unexpected columns %v", p.Columns)
+	}
+	if p.BaseChecksum == "" || p.TargetChecksum == "" {
+		return errors.New("missing checksum")
+	}
+	for _, values := range p.Inserts {
+		if len(values) != len(nekoColumns) {
+			return fmt.Errorf("insert has %d values, expected %d", len(values), len(nekoColumns))
+		}
+	}
+	for _, update := range p.Updates {
+		if _, ok := update["mdex"]; !ok {
+			return errors.New("update without an mdex")
+		}
+		for column := range update {
+			if !slices.Contains(nekoColumns, column) {
+				return fmt.Errorf("update of unknown column %q", column)
+			}
+		}
+	}
+	return nil
+}
+
+// applyNekoPatch applies a patch in one transaction. It is rolled back unless the database is
+// the base of the patch and ends up with its target checksum.
+func applyNekoPatch(db *sql.DB, patch nekoPatch) error {
+	if err := patch.validate(); err != nil {
+		return fmt.Errorf("apply neko patch: %w", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin neko patch: %w", err)
+	}
+	defer tx.Rollback()
+
+	base, err := readNekoState(tx)
+	if err != nil {
+		return err
+	}
+	if base.Version != patch.BaseVersion {
+		return fmt.Errorf("neko patch is for data version %d, the database is at %d", patch.BaseVersion, base.Version)
+	}
+	if checksum := nekoChecksum(base.Entries); checksum != patch.BaseChecksum {
+		return fmt.Errorf("neko database checksum %s does not match the base of the patch %s", checksum, patch.BaseChecksum)
+	}
+
+	if err := applyNekoRows(tx, patch); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO "+internal.TableNekoMetadata+" (id, data_version, updated_at) VALUES (1, ?, ?)"+
+		" ON CONFLICT (id) DO UPDATE SET data_version = excluded.data_version, updated_at = excluded.updated_at", patch.TargetVersion, patch.TargetUpdatedAt)
+	if err != nil {
+		return fmt.Errorf("set neko data version: %w", err)
+	}
+
+	target, err := readNekoState(tx)
+	if err != nil {
+		return err
+	}
+	if checksum := nekoChecksum(target.Entries); checksum != patch.TargetChecksum {
+		return fmt.Errorf("patched neko database checksum %s does not match the target of the patch %s", checksum, patch.TargetChecksum)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit neko patch: %w", err)
+	}
+	return nil
+}
+
+// applyNekoRows runs the inserts, updates and deletes of a validated patch.
+func applyNekoRows(tx *sql.Tx, patch nekoPatch) error {
+	insert, err := prepareNekoInsert(tx)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, values := range patch.Inserts {
+		args := make([]any, len(values))
+		for i, value := range values {
+			args[i] = value
+		}
+		if _, err := insert.Exec(args...); err != nil {
+			return fmt.Errorf("insert neko entry for manga %s: %w", values[0], err)
+		}
+	}
+
+	for _, update := range patch.Updates {
+		var set []string
+		var args []any
+		for _, column := range slices.Sorted(maps.Keys(update)) {
+			if column != "mdex" {
+				set = append(set, column+" = ?")
+				args = append(args, update[column])
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+		_, err := tx.Exec("UPDATE "+internal.TableNekoMappings+" SET "+strings.Join(set, ", ")+" WHERE mdex = ?", append(args, update["mdex"])...)
+		if err != nil {
+			return fmt.Errorf("update neko entry for manga %s: %w", update["mdex"], err)
+		}
+	}
+
+	for _, uuid := range patch.Deletes {
+		if _, err := tx.Exec("DELETE FROM "+internal.TableNekoMappings+" WHERE mdex = ?", uuid); err != nil {
+			return fmt.Errorf("delete neko entry for manga %s: %w", uuid, err)
+		}
+	}
+	return nil
+}
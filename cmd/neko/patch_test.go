@@ -0,0 +1,191 @@
+package neko
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+var (
+	baseManga    = []internal.Manga{{Id: "uuid-1"}, {Id: "uuid-2"}, {Id: "uuid-3"}}
+	baseMappings = map[string]map[string]string{
+		internal.TableAnilist: {"uuid-1": "1", "uuid-2": "2"},
+	}
+	targetManga    = []internal.Manga{{Id: "uuid-1"}, {Id: "uuid-2"}, {Id: "uuid-4"}}
+	targetMappings = map[string]map[string]string{
+		internal.TableAnilist:     {"uuid-1": "1", "uuid-2": "22", "uuid-4": "4"},
+		internal.TableEnglishTl:   {"uuid-2": "https://example.com/it's"},
+		internal.TableMyanimelist: {"uuid-1": "10"},
+	}
+)
+
+// createNekoFile writes a neko database updated once with the base manga, then with the
+// target manga if target is set.
+func createNekoFile(t *testing.T, name string, target bool) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	db, err := internal.OpenNekoDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	updateNeko(t, db, context.Background(), baseManga, baseMappings)
+	if target {
+		updateNeko(t, db, context.Background(), targetManga, targetMappings)
+	}
+	return path
+}
+
+func TestNekoDiffAndApply(t *testing.T) {
+	oldPath := createNekoFile(t, "old.db", false)
+	newPath := createNekoFile(t, "new.db", true)
+
+	patch, err := diffNekoFiles(oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch.BaseVersion != 1 || patch.TargetVersion != 2 {
+		t.Errorf("expected a patch from version 1 to 2, got %d to %d", patch.BaseVersion, patch.TargetVersion)
+	}
+	if len(patch.Inserts) != 1 || patch.Inserts[0][0] != "uuid-4" {
+		t.Errorf("unexpected inserts %v", patch.Inserts)
+	}
+	// Updates only carry the columns which changed
+	if len(patch.Updates) != 2 || len(patch.Updates[0]) != 2 || patch.Updates[0]["mal"] != "10" || patch.Updates[1]["al"] != "22" {
+		t.Errorf("unexpected updates %v", patch.Updates)
+	}
+	if strings.Join(patch.Deletes, ",") != "uuid-3" {
+		t.Errorf("unexpected deletes %v", patch.Deletes)
+	}
+
+	patchPath := filepath.Join(t.TempDir(), "patch.json")
+	nekoDiffCmd.Flags().Set("format", "json")
+	nekoDiffCmd.Flags().Set("output", patchPath)
+	if err := runNekoDiff(nekoDiffCmd, []string{oldPath, newPath}); err != nil {
+		t.Fatal(err)
+	}
+	basePath := createNekoFile(t, "base.db", false)
+	if err := runNekoApply(nekoApplyCmd, []string{patchPath, basePath}); err != nil {
+		t.Fatal(err)
+	}
+	assertNekoFileMatches(t, basePath, newPath, patch.TargetChecksum)
+
+	// The patch no longer applies to its result
+	if err := runNekoApply(nekoApplyCmd, []string{patchPath, basePath}); err == nil {
+		t.Error("expected applying the patch twice to fail")
+	}
+
+	// The SQL script is run as is by sqlite3, and is refused by neko apply
+	sqlPath := filepath.Join(t.TempDir(), "patch.sql")
+	nekoDiffCmd.Flags().Set("format", "sql")
+	nekoDiffCmd.Flags().Set("output", sqlPath)
+	if err := runNekoDiff(nekoDiffCmd, []string{oldPath, newPath}); err != nil {
+		t.Fatal(err)
+	}
+	script, err := os.ReadFile(sqlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlBasePath := createNekoFile(t, "sql.db", false)
+	if err := runNekoApply(nekoApplyCmd, []string{sqlPath, sqlBasePath}); err == nil || !strings.Contains(err.Error(), "not a JSON patch") {
+		t.Errorf("expected a SQL patch to be refused, got %v", err)
+	}
+	db, err := internal.OpenNekoDB(sqlBasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(script)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	assertNekoFileMatches(t, sqlBasePath, newPath, patch.TargetChecksum)
+}
+
+// assertNekoFileMatches checks the neko database at path has the rows and data version of the
+// one at targetPath.
+func assertNekoFileMatches(t *testing.T, path string, targetPath string, checksum string) {
+	t.Helper()
+	applied, err := diffNekoFiles(path, targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied.BaseChecksum != checksum || applied.BaseVersion != 2 {
+		t.Errorf("expected the patched database to match the new one, got version %d", applied.BaseVersion)
+	}
+}
+
+func TestNekoDiffOlderFile(t *testing.T) {
+	// A neko file from before the data version and the newer link columns
+	oldPath := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite3", oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE TABLE " + internal.TableNekoMappings + " (mdex TEXT, al TEXT, ap TEXT, bw TEXT, mu TEXT, mu_new TEXT, nu TEXT, kt TEXT, mal TEXT);" +
+		"INSERT INTO " + internal.TableNekoMappings + " (mdex, al) VALUES ('uuid-1', '1'), ('uuid-2', '2'), ('uuid-3', NULL)")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPath := createNekoFile(t, "new.db", true)
+
+	patch, err := diffNekoFiles(oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch.BaseVersion != 0 || patch.TargetVersion != 2 {
+		t.Errorf("expected a patch from version 0 to 2, got %d to %d", patch.BaseVersion, patch.TargetVersion)
+	}
+	if len(patch.Inserts) != 1 || len(patch.Updates) != 2 || strings.Join(patch.Deletes, ",") != "uuid-3" {
+		t.Errorf("unexpected patch %+v", patch)
+	}
+}
+
+func TestNekoApplyRejectsChangedBase(t *testing.T) {
+	oldPath := createNekoFile(t, "old.db", false)
+	newPath := createNekoFile(t, "new.db", true)
+	patch, err := diffNekoFiles(oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := internal.OpenNekoDB(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Same data version, different rows
+	if _, err := db.Exec("UPDATE " + internal.TableNekoMappings + " SET al = '5' WHERE mdex = 'uuid-1'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyNekoPatch(db, patch); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	var al string
+	if err := db.QueryRow("SELECT al FROM " + internal.TableNekoMappings + " WHERE mdex = 'uuid-1'").Scan(&al); err != nil {
+		t.Fatal(err)
+	}
+	if al != "5" {
+		t.Errorf("expected the database left unchanged, got al=%q", al)
+	}
+}
+
+func TestNekoApplyRefusesUnknownColumns(t *testing.T) {
+	oldPath := createNekoFile(t, "old.db", false)
+	newPath := createNekoFile(t, "new.db", true)
+	patch, err := diffNekoFiles(oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown columns of a JSON update end up in the statement, they are refused too
+	patch.Updates = append(patch.Updates, map[string]string{"mdex": "uuid-1", "al = '1'; DROP TABLE mappings; --": ""})
+	if err := applyNekoPatch(nil, patch); err == nil || !strings.Contains(err.Error(), "unknown column") {
+		t.Errorf("expected an unknown column to be refused, got %v", err)
+	}
+}
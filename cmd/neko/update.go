@@ -0,0 +1,256 @@
+package neko
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+// nekoColumns are the neko columns in the order of the fields of internal.DbNeko.
+var nekoColumns = []string{"mdex", "al", "ap", "bw", "mu", "mu_new", "nu", "kt", "kt_id", "mal", "amz", "ebj", "raw", "engtl"}
+
+// nekoValues returns the fields of a neko row in the order of nekoColumns.
+func nekoValues(entry internal.DbNeko) []any {
+	return []any{entry.UUID, entry.ANILIST, entry.ANIMEPLANET, entry.BOOKWALKER, entry.MANGAUPDATES, entry.MANGAUPDATES_NEW, entry.NOVEL_UPDATES,
+		entry.KITSU, entry.KITSU_ID, entry.MYANIMELIST, entry.AMAZON, entry.EBOOKJAPAN, entry.RAW, entry.ENGLISH_TL}
+}
+
+// nekoFields returns pointers to the fields of a neko row in the order of nekoColumns.
+func nekoFields(entry *internal.DbNeko) []any {
+	return []any{&entry.UUID, &entry.ANILIST, &entry.ANIMEPLANET, &entry.BOOKWALKER, &entry.MANGAUPDATES, &entry.MANGAUPDATES_NEW, &entry.NOVEL_UPDATES,
+		&entry.KITSU, &entry.KITSU_ID, &entry.MYANIMELIST, &entry.AMAZON, &entry.EBOOKJAPAN, &entry.RAW, &entry.ENGLISH_TL}
+}
+
+// prepareNekoInsert prepares the insert of a neko row, its values given by nekoValues.
+func prepareNekoInsert(tx *sql.Tx) (*sql.Stmt, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(nekoColumns)), ", ")
+	stmt, err := tx.Prepare("INSERT INTO " + internal.TableNekoMappings + " (" + strings.Join(nekoColumns, ", ") + ") VALUES (" + placeholders + ")")
+	if err != nil {
+		return nil, fmt.Errorf("prepare neko insert: %w", err)
+	}
+	return stmt, nil
+}
+
+// nekoChanges counts the rows an update of the neko database touched.
+type nekoChanges struct {
+	Inserted  int
+	Updated   int
+	Deleted   int
+	Unchanged int
+}
+
+func (c nekoChanges) changed() bool {
+	return c.Inserted+c.Updated+c.Deleted > 0
+}
+
+// updateMangaList upserts the neko row of every manga whose ids changed and deletes the rows
+// of manga no longer listed. Nothing is deleted if ctx is cancelled before the list ends.
+func updateMangaList(ctx context.Context, tx *sql.Tx, mangaList iter.Seq[internal.Manga], mappings map[string]map[string]string) (nekoChanges, error) {
+	var changes nekoChanges
+	if err := addMissingColumns(tx); err != nil {
+		return changes, err
+	}
+	existing, err := getNekoEntries(tx)
+	if err != nil {
+		return changes, err
+	}
+
+	insert, err := prepareNekoInsert(tx)
+	if err != nil {
+		return changes, err
+	}
+	defer insert.Close()
+	update, err := tx.Prepare("UPDATE " + internal.TableNekoMappings + " SET " + strings.Join(nekoColumns[1:], " = ?, ") + " = ? WHERE mdex = ?")
+	if err != nil {
+		return changes, fmt.Errorf("prepare neko update: %w", err)
+	}
+	defer update.Close()
+
+	seen := make(map[string]bool, len(existing))
+	for manga := range mangaList {
+		if err := ctx.Err(); err != nil {
+			return changes, err
+		}
+		seen[manga.Id] = true
+		entry := newNekoEntry(manga.Id, mappings)
+		current, ok := existing[manga.Id]
+		switch {
+		case !ok:
+			if err := insertNekoEntry(insert, entry); err != nil {
+				return changes, err
+			}
+			changes.Inserted++
+		case current != entry:
+			values := nekoValues(entry)
+			if _, err := update.Exec(append(values[1:], entry.UUID)...); err != nil {
+				return changes, fmt.Errorf("update neko entry for manga %s: %w", manga.Id, err)
+			}
+			changes.Updated++
+		default:
+			changes.Unchanged++
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return changes, err
+	}
+
+	for uuid := range existing {
+		if seen[uuid] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM "+internal.TableNekoMappings+" WHERE mdex = ?", uuid); err != nil {
+			return changes, fmt.Errorf("delete neko entry for manga %s: %w", uuid, err)
+		}
+		changes.Deleted++
+	}
+	return changes, nil
+}
+
+// nekoQuerier is a neko database or a transaction on it.
+type nekoQuerier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// getNekoEntries reads every neko row by MangaDex uuid. Link columns missing from a database
+// older than them read as empty.
+func getNekoEntries(db nekoQuerier) (map[string]internal.DbNeko, error) {
+	existing, err := nekoTableColumns(db, internal.TableNekoMappings)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(nekoColumns))
+	for i, column := range nekoColumns {
+		if existing[column] {
+			columns[i] = "COALESCE(" + column + ", '')"
+		} else {
+			columns[i] = "''"
+		}
+	}
+	rows, err := db.Query("SELECT " + strings.Join(columns, ", ") + " FROM " + internal.TableNekoMappings)
+	if err != nil {
+		return nil, fmt.Errorf("read neko entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make(map[string]internal.DbNeko)
+	for rows.Next() {
+		var entry internal.DbNeko
+		if err := rows.Scan(nekoFields(&entry)...); err != nil {
+			return nil, fmt.Errorf("read neko entries: %w", err)
+		}
+		entries[entry.UUID] = entry
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read neko entries: %w", err)
+	}
+	return entries, nil
+}
+
+// getDataVersion returns the data version of the neko database, 0 if it was never set or the
+// database predates the metadata table.
+func getDataVersion(db nekoQuerier) (int64, error) {
+	existing, err := nekoTableColumns(db, internal.TableNekoMetadata)
+	if err != nil || len(existing) == 0 {
+		return 0, err
+	}
+	var version int64
+	err = db.QueryRow("SELECT data_version FROM " + internal.TableNekoMetadata + " WHERE id = 1").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read neko data version: %w", err)
+	}
+	return version, nil
+}
+
+// nekoTableColumns returns the columns of a neko table, none if it does not exist.
+func nekoTableColumns(db nekoQuerier, table string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('" + table + "')")
+	if err != nil {
+		return nil, fmt.Errorf("read %s columns: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("read %s columns: %w", table, err)
+		}
+		columns[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read %s columns: %w", table, err)
+	}
+	return columns, nil
+}
+
+// bumpDataVersion sets the data version of the neko database one past the larger of its
+// current version and previous, and returns it.
+func bumpDataVersion(tx *sql.Tx, previous int64, now time.Time) (int64, error) {
+	_, err := tx.Exec("INSERT INTO "+internal.TableNekoMetadata+" (id, data_version, updated_at) VALUES (1, ? + 1, ?)"+
+		" ON CONFLICT (id) DO UPDATE SET data_version = MAX(data_version, ?) + 1, updated_at = excluded.updated_at",
+		previous, now.UTC().Format(time.RFC3339), previous)
+	if err != nil {
+		return 0, fmt.Errorf("bump neko data version: %w", err)
+	}
+	return getDataVersion(tx)
+}
+
+// previousDataVersion returns the highest data version of the dated neko exports in dir, 0
+// when there are none. Exports made before data versions were kept are skipped.
+func previousDataVersion(dir string) (int64, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*_neko_mapping.db"))
+	if err != nil {
+		return 0, fmt.Errorf("list neko exports: %w", err)
+	}
+	var previous int64
+	for _, path := range paths {
+		db, err := openNekoReadOnly(path)
+		if err != nil {
+			return 0, err
+		}
+		version, err := getDataVersion(db)
+		db.Close()
+		if err != nil {
+			fmt.Printf("Warning: skipping the data version of %s: %v\n", path, err)
+			continue
+		}
+		previous = max(previous, version)
+	}
+	return previous, nil
+}
+
+// openNekoReadOnly opens the neko database at path without migrating or modifying it.
+func openNekoReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("open neko database: %w", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("open neko database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// checkIntegrity runs the sqlite integrity check on the neko database.
+func checkIntegrity(db *sql.DB) error {
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("check neko database integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("neko database failed its integrity check: %s", result)
+	}
+	return nil
+}
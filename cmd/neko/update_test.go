@@ -0,0 +1,168 @@
+package neko
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
+)
+
+func openNekoTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	// The mappings table of the old template database
+	_, err = db.Exec("CREATE TABLE " + internal.TableNekoMappings + " (mdex TEXT, al TEXT, ap TEXT, bw TEXT, mu TEXT, mu_new TEXT, nu TEXT, kt TEXT, mal TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := internal.Migrate(db, internal.NekoMigrations); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// updateNeko runs one update of db and bumps the data version if anything changed.
+func updateNeko(t *testing.T, db *sql.DB, ctx context.Context, mangaList []internal.Manga, mappings map[string]map[string]string) (nekoChanges, int64) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	changes, err := updateMangaList(ctx, tx, slices.Values(mangaList), mappings)
+	if err != nil {
+		return changes, 0
+	}
+	version, err := getDataVersion(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes.changed() {
+		if version, err = bumpDataVersion(tx, 0, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkIntegrity(db); err != nil {
+		t.Fatal(err)
+	}
+	return changes, version
+}
+
+func TestUpdateMangaList(t *testing.T) {
+	db := openNekoTestDB(t)
+	mangaList := []internal.Manga{{Id: "uuid-1"}, {Id: "uuid-2"}, {Id: "uuid-3"}}
+	mappings := map[string]map[string]string{
+		internal.TableAnilist: {"uuid-1": "1", "uuid-2": "2"},
+	}
+	changes, version := updateNeko(t, db, context.Background(), mangaList, mappings)
+	if changes != (nekoChanges{Inserted: 3}) || version != 1 {
+		t.Fatalf("unexpected first update %+v, version %d", changes, version)
+	}
+
+	// uuid-2 gets a new id, uuid-3 is removed and uuid-4 added
+	mappings[internal.TableAnilist]["uuid-2"] = "22"
+	mappings[internal.TableKitsuResolved] = map[string]string{"uuid-1": "100"}
+	changes, version = updateNeko(t, db, context.Background(), []internal.Manga{{Id: "uuid-1"}, {Id: "uuid-2"}, {Id: "uuid-4"}}, mappings)
+	if changes != (nekoChanges{Inserted: 1, Updated: 2, Deleted: 1}) || version != 2 {
+		t.Fatalf("unexpected second update %+v, version %d", changes, version)
+	}
+
+	var al, ktId string
+	if err := db.QueryRow("SELECT al, kt_id FROM "+internal.TableNekoMappings+" WHERE mdex = 'uuid-1'").Scan(&al, &ktId); err != nil {
+		t.Fatal(err)
+	}
+	if al != "1" || ktId != "100" {
+		t.Errorf("unexpected uuid-1 row al=%q kt_id=%q", al, ktId)
+	}
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + internal.TableNekoMappings + " WHERE mdex = 'uuid-3'").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Errorf("expected the removed manga to be deleted")
+	}
+
+	// Nothing changed, the data version stays
+	changes, version = updateNeko(t, db, context.Background(), []internal.Manga{{Id: "uuid-1"}, {Id: "uuid-2"}, {Id: "uuid-4"}}, mappings)
+	if changes != (nekoChanges{Unchanged: 3}) || version != 2 {
+		t.Errorf("unexpected unchanged update %+v, version %d", changes, version)
+	}
+}
+
+func TestUpdateMangaListCancelledKeepsRows(t *testing.T) {
+	db := openNekoTestDB(t)
+	updateNeko(t, db, context.Background(), []internal.Manga{{Id: "uuid-1"}, {Id: "uuid-2"}}, map[string]map[string]string{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := updateMangaList(ctx, tx, slices.Values([]internal.Manga{}), map[string]map[string]string{}); err == nil {
+		t.Fatal("expected the cancelled update to fail")
+	}
+	var rows int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM " + internal.TableNekoMappings).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("expected no rows deleted by a cancelled update, got %d rows", rows)
+	}
+}
+
+func TestDatedExportsContinueDataVersion(t *testing.T) {
+	dir := t.TempDir()
+	for name, updates := range map[string]int{"2024-01-01_neko_mapping.db": 1, "2024-01-02_neko_mapping.db": 3} {
+		db, err := internal.OpenNekoDB(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < updates; i++ {
+			updateNeko(t, db, context.Background(), []internal.Manga{{Id: fmt.Sprintf("uuid-%d", i)}}, map[string]map[string]string{})
+		}
+		db.Close()
+	}
+	// Exported before data versions were kept
+	legacy, err := sql.Open("sqlite3", filepath.Join(dir, "2023-12-31_neko_mapping.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec("CREATE TABLE " + internal.TableNekoMappings + " (mdex TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	previous, err := previousDataVersion(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != 3 {
+		t.Fatalf("expected the highest previous data version 3, got %d", previous)
+	}
+
+	db := openNekoTestDB(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if version, err := bumpDataVersion(tx, previous, time.Now()); err != nil || version != 4 {
+		t.Errorf("expected a fresh export at data version 4, got %d %v", version, err)
+	}
+}
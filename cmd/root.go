@@ -1,9 +1,24 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex"
 	"github.com/spf13/cobra"
 	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Exit codes returned by the binary
+const (
+	ExitFailure = 1
+	ExitUsage   = 2
+	ExitAPI     = 3
+	// ExitInterrupted follows the shell convention of 128 + SIGINT
+	ExitInterrupted = 130
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -25,6 +40,22 @@ var RootCmd = &cobra.Command{
  If you are running again after a while make sure you pull the latest from git, then rerun from scratch as the manga mappings and 
  manga update mappings are updated frequently.
 `,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		httpMode, _ := cmd.Flags().GetString("http-mode")
+		cassetteDir, _ := cmd.Flags().GetString("cassette-dir")
+		mode, err := internal.ParseHTTPMode(httpMode)
+		if err != nil {
+			return usageError{err}
+		}
+		internal.HTTPTransport.SetMode(mode, cassetteDir)
+		switch databaseMode(cmd) {
+		case DatabaseReadWrite:
+			return internal.ConnectDB()
+		case DatabaseReadOnly:
+			return internal.ConnectDBReadOnly()
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			_ = cmd.Help()
@@ -33,13 +64,96 @@ var RootCmd = &cobra.Command{
 	},
 }
 
+// DatabaseAnnotation tells how a command uses data/data.db, set on the command or one of its
+// parents. The database is connected and migrated before DatabaseReadWrite commands run and
+// opened read only for DatabaseReadOnly ones. Other commands never open it.
+const (
+	DatabaseAnnotation = "database"
+	DatabaseReadWrite  = "readwrite"
+	DatabaseReadOnly   = "readonly"
+	DatabaseNone       = "none"
+)
+
+// UsesDatabase sets how the command, and its subcommands unless they set their own, use data/data.db.
+func UsesDatabase(command *cobra.Command, mode string) {
+	if command.Annotations == nil {
+		command.Annotations = make(map[string]string)
+	}
+	command.Annotations[DatabaseAnnotation] = mode
+}
+
+// databaseMode returns the database annotation of the command or its closest annotated parent.
+func databaseMode(command *cobra.Command) string {
+	for c := command; c != nil; c = c.Parent() {
+		if mode, ok := c.Annotations[DatabaseAnnotation]; ok {
+			return mode
+		}
+	}
+	return DatabaseNone
+}
+
+// usageError marks errors caused by invalid flags or arguments.
+type usageError struct {
+	error
+}
+
+func (e usageError) Unwrap() error {
+	return e.error
+}
+
+// UsageErrorf reports an invalid flag value found by a command, exiting with ExitUsage.
+func UsageErrorf(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// UsageArgs wraps an argument validator so its failures exit with ExitUsage.
+func UsageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(command *cobra.Command, args []string) error {
+		if err := validate(command, args); err != nil {
+			return usageError{err}
+		}
+		return nil
+	}
+}
+
+// ExitCode maps an error returned by a command to the process exit code.
+func ExitCode(err error) int {
+	var usageErr usageError
+	var apiErr *mangadex.APIError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.As(err, &apiErr):
+		return ExitAPI
+	default:
+		return ExitFailure
+	}
+}
+
+// Execute runs the root command with a context cancelled on SIGINT or SIGTERM. Commands
+// stop starting new work once it is cancelled, keep what already finished and return an
+// error wrapping context.Canceled.
 func Execute() {
-	err := RootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := RootCmd.ExecuteContext(ctx)
+	stop()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(ExitCode(err))
 	}
 }
 
 func init() {
-	internal.ConnectDB()
+	RootCmd.PersistentFlags().String("http-mode", string(internal.HTTPModeLive), "live hits the network, record also saves every response to a cassette, replay only serves saved responses")
+	RootCmd.PersistentFlags().String("cassette-dir", "data/cassettes", "Directory holding the recorded http cassettes")
+
+	// Runtime errors should not print the usage, only invalid flags
+	RootCmd.SilenceUsage = true
+	RootCmd.SetFlagErrorFunc(func(command *cobra.Command, err error) error {
+		command.PrintErrln(command.UsageString())
+		return usageError{err}
+	})
 }
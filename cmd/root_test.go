@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestDatabaseMode(t *testing.T) {
+	parent := &cobra.Command{Use: "parent"}
+	child := &cobra.Command{Use: "child"}
+	patch := &cobra.Command{Use: "patch"}
+	other := &cobra.Command{Use: "other"}
+	parent.AddCommand(child, patch)
+	UsesDatabase(parent, DatabaseReadWrite)
+	UsesDatabase(patch, DatabaseNone)
+
+	tests := map[*cobra.Command]string{
+		parent: DatabaseReadWrite,
+		child:  DatabaseReadWrite,
+		patch:  DatabaseNone,
+		other:  DatabaseNone,
+	}
+	for command, want := range tests {
+		if got := databaseMode(command); got != want {
+			t.Errorf("databaseMode(%s) = %q, want %q", command.Use, got, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package search
+
+import (
+	"iter"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/similar-manga/similar/internal"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TitleIndex is an in memory trigram index over the title and every alternate title of
+// each manga. Trigrams are taken over runes so CJK titles are indexed as well.
+type TitleIndex struct {
+	entries  []titleEntry
+	postings map[string][]int
+}
+
+type titleEntry struct {
+	uuid       string
+	title      string
+	lang       string
+	normalized string
+	gramCount  int
+}
+
+// Result is a single manga matched by a search, with the title that matched best.
+type Result struct {
+	Id    string  `json:"id"`
+	Title string  `json:"title"`
+	Lang  string  `json:"lang"`
+	Score float64 `json:"score"`
+}
+
+// NewTitleIndex builds the index from the given manga.
+func NewTitleIndex(mangaList iter.Seq[internal.Manga]) *TitleIndex {
+	index := &TitleIndex{postings: make(map[string][]int)}
+	for manga := range mangaList {
+		if manga.Title != nil {
+			for lang, title := range *manga.Title {
+				index.add(manga.Id, lang, title)
+			}
+		}
+		for _, altTitle := range manga.AltTitles {
+			for lang, title := range altTitle {
+				index.add(manga.Id, lang, title)
+			}
+		}
+	}
+	return index
+}
+
+func (index *TitleIndex) add(uuid string, lang string, title string) {
+	normalized := normalizeTitle(title)
+	grams := trigrams(normalized)
+	if len(grams) == 0 {
+		return
+	}
+	id := len(index.entries)
+	index.entries = append(index.entries, titleEntry{
+		uuid:       uuid,
+		title:      title,
+		lang:       lang,
+		normalized: normalized,
+		gramCount:  len(grams),
+	})
+	for gram := range grams {
+		index.postings[gram] = append(index.postings[gram], id)
+	}
+}
+
+// Search returns up to limit manga ordered by how closely one of their titles matches the
+// query. Scores are the Dice coefficient of the trigram sets, with a small boost when the
+// query appears verbatim in the title, and results under minScore are dropped.
+func (index *TitleIndex) Search(query string, limit int, minScore float64) []Result {
+	normalized := normalizeTitle(query)
+	queryGrams := trigrams(normalized)
+	if len(queryGrams) == 0 {
+		return nil
+	}
+
+	shared := make(map[int]int)
+	for gram := range queryGrams {
+		for _, id := range index.postings[gram] {
+			shared[id]++
+		}
+	}
+
+	best := make(map[string]Result)
+	for id, count := range shared {
+		entry := index.entries[id]
+		score := 2 * float64(count) / float64(len(queryGrams)+entry.gramCount)
+		if strings.Contains(entry.normalized, normalized) {
+			score = min(1, score+0.1)
+		}
+		if score < minScore {
+			continue
+		}
+		if current, ok := best[entry.uuid]; !ok || score > current.Score {
+			best[entry.uuid] = Result{Id: entry.uuid, Title: entry.title, Lang: entry.lang, Score: score}
+		}
+	}
+
+	results := make([]Result, 0, len(best))
+	for _, result := range best {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Id < results[j].Id
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// titleFolder strips Latin combining diacritics only, kana voicing marks are meaningful.
+var titleFolder = transform.Chain(norm.NFKD, runes.Remove(runes.Predicate(func(r rune) bool {
+	return r >= 0x0300 && r <= 0x036F
+})), norm.NFC)
+
+// normalizeTitle folds case, width and diacritics and reduces punctuation to single spaces.
+func normalizeTitle(title string) string {
+	folded, _, err := transform.String(titleFolder, title)
+	if err != nil {
+		folded = title
+	}
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(folded) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), " ")
+}
+
+// trigrams returns the set of rune trigrams of each word, padded with a space on both
+// sides so that short words and word boundaries still produce grams.
+func trigrams(normalized string) map[string]struct{} {
+	grams := make(map[string]struct{})
+	for _, word := range strings.Fields(normalized) {
+		padded := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			grams[string(padded[i:i+3])] = struct{}{}
+		}
+	}
+	return grams
+}
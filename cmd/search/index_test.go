@@ -0,0 +1,69 @@
+package search
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Pink to Habanero", "pink to habanero"},
+		{"  Màu hồng và Ớt Habanero!! ", "mau hong va ot habanero"},
+		{"ＳＰＹ×ＦＡＭＩＬＹ", "spy family"},
+		{"ピンクとハバネロ", "ピンクとハバネロ"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeTitle(tt.input); got != tt.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTitleIndexSearch(t *testing.T) {
+	mangaList := []internal.Manga{
+		{
+			Id:        "uuid-1",
+			Title:     &map[string]string{"en": "Pink to Habanero"},
+			AltTitles: []map[string]string{{"ja": "ピンクとハバネロ"}, {"en": "Pink and Habanero"}},
+		},
+		{
+			Id:    "uuid-2",
+			Title: &map[string]string{"en": "Spy x Family"},
+		},
+		{
+			Id:    "uuid-3",
+			Title: &map[string]string{"en": "Pink Lemonade"},
+		},
+	}
+	index := NewTitleIndex(slices.Values(mangaList))
+
+	results := index.Search("pink habanero", 10, 0.3)
+	if len(results) == 0 || results[0].Id != "uuid-1" {
+		t.Fatalf("expected uuid-1 first, got %+v", results)
+	}
+
+	results = index.Search("ハバネロ", 10, 0.1)
+	if len(results) != 1 || results[0].Id != "uuid-1" || results[0].Lang != "ja" {
+		t.Fatalf("expected japanese alt title of uuid-1, got %+v", results)
+	}
+
+	results = index.Search("spy famly", 10, 0.3)
+	if len(results) != 1 || results[0].Id != "uuid-2" {
+		t.Fatalf("expected typo tolerant match on uuid-2, got %+v", results)
+	}
+
+	results = index.Search("pink", 1, 0)
+	if len(results) != 1 {
+		t.Fatalf("expected limit to be applied, got %d results", len(results))
+	}
+
+	if results := index.Search("!!!", 10, 0); results != nil {
+		t.Errorf("expected no results for an empty query, got %+v", results)
+	}
+}
@@ -0,0 +1,51 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search <title>",
+	Short: "Find manga uuids by title",
+	Long:  `Fuzzy search the title and alternate titles of every manga in the database`,
+	Args:  cmd.UsageArgs(cobra.MinimumNArgs(1)),
+	RunE:  runSearch,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(searchCmd)
+	cmd.UsesDatabase(searchCmd, cmd.DatabaseReadOnly)
+	searchCmd.Flags().IntP("limit", "l", 10, "Maximum number of results")
+	searchCmd.Flags().Float64P("min-score", "m", 0.3, "Drop results scoring under this value")
+	searchCmd.Flags().BoolP("json", "j", false, "Print the results as json")
+}
+
+func runSearch(command *cobra.Command, args []string) error {
+	limit, _ := command.Flags().GetInt("limit")
+	minScore, _ := command.Flags().GetFloat64("min-score")
+	asJson, _ := command.Flags().GetBool("json")
+
+	index := NewTitleIndex(internal.StreamAllManga())
+	results := index.Search(strings.Join(args, " "), limit, minScore)
+
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+	if len(results) == 0 {
+		fmt.Println("No matches found")
+		return nil
+	}
+	for _, result := range results {
+		fmt.Printf("%.3f  %s  [%s] %s\n", result.Score, result.Id, result.Lang, result.Title)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve similar results and mappings over http",
+	Long: `
+Starts a read only REST server backed by data.db.
+
+  GET /manga/{uuid}
+  GET /manga/{uuid}/similar?lang=en&contentRating=safe,suggestive
+  GET /mappings/{site}/{externalId}
+  GET /mappings/mangadex/{uuid}`,
+	RunE: runServe,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(serveCmd)
+	cmd.UsesDatabase(serveCmd, cmd.DatabaseReadOnly)
+	serveCmd.Flags().StringP("addr", "a", ":8080", "Address to listen on")
+	serveCmd.Flags().DurationP("shutdown-timeout", "s", 10*time.Second, "How long to wait for open requests on shutdown")
+}
+
+func runServe(command *cobra.Command, args []string) error {
+	addr, _ := command.Flags().GetString("addr")
+	shutdownTimeout, _ := command.Flags().GetDuration("shutdown-timeout")
+	// The mapping endpoints query every mapping table, data.db is never migrated from here
+	if err := internal.RequireSchema(internal.DB, internal.DataMigrations); err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           NewServer(internal.DB),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Cancelled on SIGINT or SIGTERM by the root command
+	ctx := command.Context()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Listening on %s\n", addr)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("serve %s: %w", addr, err)
+		}
+		return nil
+	case <-ctx.Done():
+		fmt.Println("Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("shutdown server: %w", err)
+		}
+		return nil
+	}
+}
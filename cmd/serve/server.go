@@ -0,0 +1,284 @@
+package serve
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/similar-manga/similar/internal"
+)
+
+// Server answers the read only endpoints from the given database.
+type Server struct {
+	db  *sql.DB
+	mux *http.ServeMux
+}
+
+type errorResponse struct {
+	Result string       `json:"result"`
+	Errors []modelError `json:"errors"`
+}
+
+type modelError struct {
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type externalMapping struct {
+	Site string   `json:"site"`
+	Id   string   `json:"id"`
+	Mdex []string `json:"mdex"`
+}
+
+// NewServer creates the http handler for the REST endpoints.
+func NewServer(db *sql.DB) *Server {
+	s := &Server{db: db, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /manga/{uuid}", s.handleManga)
+	s.mux.HandleFunc("GET /manga/{uuid}/similar", s.handleSimilar)
+	s.mux.HandleFunc("GET /mappings/mangadex/{uuid}", s.handleMangaDexMappings)
+	s.mux.HandleFunc("GET /mappings/{site}/{externalId}", s.handleExternalMapping)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no route for "+r.URL.Path)
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleManga(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	var jsonManga []byte
+	err := s.db.QueryRow("SELECT JSON FROM "+internal.TableManga+" WHERE UUID = ?", uuid).Scan(&jsonManga)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "manga "+uuid+" not found")
+		return
+	} else if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	writeJSON(w, r, jsonManga)
+}
+
+func (s *Server) handleSimilar(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	var jsonSimilar []byte
+	err := s.db.QueryRow("SELECT JSON FROM "+internal.TableSimilar+" WHERE UUID = ?", uuid).Scan(&jsonSimilar)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "no similar entry for "+uuid)
+		return
+	} else if err != nil {
+		s.internalError(w, err)
+		return
+	}
+
+	languages := queryList(r, "lang")
+	contentRatings := queryList(r, "contentRating")
+	if len(languages) == 0 && len(contentRatings) == 0 {
+		writeJSON(w, r, jsonSimilar)
+		return
+	}
+
+	similar := internal.SimilarManga{}
+	if err := json.Unmarshal(jsonSimilar, &similar); err != nil {
+		s.internalError(w, err)
+		return
+	}
+	var ratings map[string]string
+	if len(contentRatings) > 0 {
+		if ratings, err = s.contentRatings(similar.SimilarMatches); err != nil {
+			s.internalError(w, err)
+			return
+		}
+	}
+	filtered := make([]internal.SimilarMatch, 0, len(similar.SimilarMatches))
+	for _, match := range similar.SimilarMatches {
+		if len(languages) > 0 && !containsAny(match.Languages, languages) {
+			continue
+		}
+		if len(contentRatings) > 0 && !containsAny([]string{ratings[match.Id]}, contentRatings) {
+			continue
+		}
+		filtered = append(filtered, match)
+	}
+	similar.SimilarMatches = filtered
+
+	body, err := json.Marshal(similar)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	writeJSON(w, r, body)
+}
+
+// contentRatings returns the rating of every match by uuid. Matches calculated before they
+// carried their rating are looked up in the MANGA table, all in one query.
+func (s *Server) contentRatings(matches []internal.SimilarMatch) (map[string]string, error) {
+	ratings := make(map[string]string, len(matches))
+	var placeholders []string
+	var args []any
+	for _, match := range matches {
+		if match.ContentRating != "" {
+			ratings[match.Id] = match.ContentRating
+			continue
+		}
+		placeholders = append(placeholders, "?")
+		args = append(args, match.Id)
+	}
+	if len(args) == 0 {
+		return ratings, nil
+	}
+
+	rows, err := s.db.Query("SELECT UUID, COALESCE(json_extract(JSON, '$.contentRating'), '') FROM "+internal.TableManga+
+		" WHERE UUID IN ("+strings.Join(placeholders, ",")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uuid, rating string
+		if err := rows.Scan(&uuid, &rating); err != nil {
+			return nil, err
+		}
+		ratings[uuid] = rating
+	}
+	return ratings, rows.Err()
+}
+
+func (s *Server) handleMangaDexMappings(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	mappings := map[string]string{"mdex": uuid}
+	for _, site := range internal.MappingSites {
+		var id string
+		err := s.db.QueryRow("SELECT ID FROM "+site.Table+" WHERE UUID = ?", uuid).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			s.internalError(w, err)
+			return
+		}
+		mappings[site.Key] = id
+	}
+	if len(mappings) == 1 {
+		writeError(w, http.StatusNotFound, "no mappings for "+uuid)
+		return
+	}
+
+	body, err := json.Marshal(mappings)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	writeJSON(w, r, body)
+}
+
+func (s *Server) handleExternalMapping(w http.ResponseWriter, r *http.Request) {
+	siteKey := r.PathValue("site")
+	externalId := r.PathValue("externalId")
+	site, ok := internal.MappingSiteByKey(siteKey)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "unknown site "+siteKey)
+		return
+	}
+
+	rows, err := s.db.Query("SELECT UUID FROM "+site.Table+" WHERE ID = ? ORDER BY UUID ASC", externalId)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	mapping := externalMapping{Site: site.Key, Id: externalId}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			s.internalError(w, err)
+			return
+		}
+		mapping.Mdex = append(mapping.Mdex, uuid)
+	}
+	if err := rows.Err(); err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if len(mapping.Mdex) == 0 {
+		writeError(w, http.StatusNotFound, "no mangadex entry for "+site.Key+" "+externalId)
+		return
+	}
+
+	body, err := json.Marshal(mapping)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	writeJSON(w, r, body)
+}
+
+func (s *Server) internalError(w http.ResponseWriter, err error) {
+	log.Printf("ERROR: %v", err)
+	writeError(w, http.StatusInternalServerError, "")
+}
+
+// writeJSON writes the body with an ETag derived from its content, answering with a 304
+// when the client already holds the same version.
+func writeJSON(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/json")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	_, _ = w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{
+		Result: "error",
+		Errors: []modelError{{Status: status, Title: http.StatusText(status), Detail: detail}},
+	})
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// queryList accepts both repeated and comma separated query values.
+func queryList(r *http.Request, key string) []string {
+	var values []string
+	for _, value := range r.URL.Query()[key] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+func containsAny(haystack []string, needles []string) bool {
+	for _, h := range haystack {
+		for _, n := range needles {
+			if h == n {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package serve
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
+)
+
+func setupServerDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("CREATE TABLE " + internal.TableManga + " (UUID TEXT PRIMARY KEY, JSON TEXT, DATE TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE TABLE " + internal.TableSimilar + " (UUID TEXT PRIMARY KEY, JSON BLOB)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, site := range internal.MappingSites {
+		if _, err := db.Exec("CREATE TABLE " + site.Table + " (UUID TEXT PRIMARY KEY, ID TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = db.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?), (?, ?, ?)",
+		"uuid-1", `{"id":"uuid-1","contentRating":"safe"}`, "2023-01-01",
+		"uuid-3", `{"id":"uuid-3","contentRating":"erotica"}`, "2023-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	similar, _ := json.Marshal(internal.SimilarManga{
+		Id: "uuid-1",
+		SimilarMatches: []internal.SimilarMatch{
+			{Id: "uuid-2", ContentRating: "safe", Languages: []string{"en"}, Score: 0.5},
+			{Id: "uuid-3", Languages: []string{"fr"}, Score: 0.4},
+		},
+	})
+	if _, err = db.Exec("INSERT INTO "+internal.TableSimilar+" (UUID, JSON) VALUES (?, ?)", "uuid-1", similar); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO " + internal.TableAnilist + " (UUID, ID) VALUES ('uuid-1', '30013'), ('uuid-4', '30013')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO " + internal.TableMyanimelist + " (UUID, ID) VALUES ('uuid-1', '13')"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestServerManga(t *testing.T) {
+	ts := httptest.NewServer(NewServer(setupServerDB(t)))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/manga/uuid-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag header")
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/manga/uuid-1", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/manga/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.StatusCode)
+	}
+	var errResp errorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("expected json error body: %v", err)
+	}
+	if errResp.Result != "error" || len(errResp.Errors) != 1 || errResp.Errors[0].Status != 404 {
+		t.Errorf("unexpected error body %+v", errResp)
+	}
+}
+
+func TestServerSimilarFilters(t *testing.T) {
+	ts := httptest.NewServer(NewServer(setupServerDB(t)))
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"No filter", "", []string{"uuid-2", "uuid-3"}},
+		{"Language", "?lang=fr", []string{"uuid-3"}},
+		{"Content rating from match", "?contentRating=safe", []string{"uuid-2"}},
+		{"Content rating from manga table", "?contentRating=erotica,pornographic", []string{"uuid-3"}},
+		{"Both", "?lang=en&contentRating=erotica", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + "/manga/uuid-1/similar" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d", resp.StatusCode)
+			}
+			var similar internal.SimilarManga
+			if err := json.NewDecoder(resp.Body).Decode(&similar); err != nil {
+				t.Fatal(err)
+			}
+			if len(similar.SimilarMatches) != len(tt.want) {
+				t.Fatalf("expected %d matches, got %d", len(tt.want), len(similar.SimilarMatches))
+			}
+			for i, id := range tt.want {
+				if similar.SimilarMatches[i].Id != id {
+					t.Errorf("match %d: expected %s, got %s", i, id, similar.SimilarMatches[i].Id)
+				}
+			}
+		})
+	}
+}
+
+func TestServerMappings(t *testing.T) {
+	ts := httptest.NewServer(NewServer(setupServerDB(t)))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/mappings/al/30013")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mapping externalMapping
+	err = json.NewDecoder(resp.Body).Decode(&mapping)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapping.Mdex) != 2 || mapping.Mdex[0] != "uuid-1" || mapping.Mdex[1] != "uuid-4" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+
+	resp, err = http.Get(ts.URL + "/mappings/mangadex/uuid-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mdex map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&mdex)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mdex["al"] != "30013" || mdex["mal"] != "13" {
+		t.Errorf("unexpected mangadex mappings %v", mdex)
+	}
+
+	resp, err = http.Get(ts.URL + "/mappings/unknown/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown site, got %d", resp.StatusCode)
+	}
+}
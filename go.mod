@@ -12,6 +12,7 @@ require (
 	github.com/james-bowman/sparse v0.0.0-20210729090128-1e6c7dd483e9
 	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/spf13/cobra v1.7.0
+	github.com/spf13/pflag v1.0.5
 	go.uber.org/ratelimit v0.3.0
 	golang.org/x/text v0.23.0
 	gonum.org/v1/gonum v0.11.0
@@ -23,7 +24,6 @@ require (
 	github.com/benbjohnson/clock v1.3.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d // indirect
 	golang.org/x/net v0.38.0 // indirect
 )
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HTTPMode selects whether outgoing requests hit the network, are recorded, or are replayed.
+type HTTPMode string
+
+const (
+	HTTPModeLive   HTTPMode = "live"
+	HTTPModeRecord HTTPMode = "record"
+	HTTPModeReplay HTTPMode = "replay"
+)
+
+// ParseHTTPMode validates the value of the --http-mode flag.
+func ParseHTTPMode(value string) (HTTPMode, error) {
+	switch mode := HTTPMode(value); mode {
+	case HTTPModeLive, HTTPModeRecord, HTTPModeReplay:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid http mode %q, expected live, record or replay", value)
+	}
+}
+
+// ErrCassetteMiss is returned in replay mode for a request that was never recorded.
+var ErrCassetteMiss = errors.New("no recorded response")
+
+// Interaction is one recorded response.
+type Interaction struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// CassetteTransport records responses into, or replays them from, cassette files. Each
+// request is keyed by its method and url (query parameters sorted), a key holds every
+// response recorded for it in order so retries replay the same way they happened. Once the
+// recorded responses run out the last one is repeated. Recording a request again overwrites
+// its cassette.
+type CassetteTransport struct {
+	Next http.RoundTripper
+
+	mu     sync.Mutex
+	mode   HTTPMode
+	dir    string
+	replay map[string]int
+}
+
+// HTTPTransport is shared by every client talking to an external service, so the global
+// --http-mode flag applies to all of them.
+var HTTPTransport = NewCassetteTransport(HTTPModeLive, "", nil)
+
+// NewCassetteTransport creates a transport keeping its cassettes under dir. In live and
+// record mode requests are sent through next, or http.DefaultTransport when nil.
+func NewCassetteTransport(mode HTTPMode, dir string, next http.RoundTripper) *CassetteTransport {
+	return &CassetteTransport{Next: next, mode: mode, dir: dir, replay: make(map[string]int)}
+}
+
+// SetMode switches the transport and resets the replay position of every cassette.
+func (t *CassetteTransport) SetMode(mode HTTPMode, dir string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mode = mode
+	t.dir = dir
+	t.replay = make(map[string]int)
+}
+
+// Mode returns the current mode.
+func (t *CassetteTransport) Mode() HTTPMode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mode
+}
+
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch t.Mode() {
+	case HTTPModeRecord:
+		return t.record(req)
+	case HTTPModeReplay:
+		return t.play(req)
+	default:
+		return t.next().RoundTrip(req)
+	}
+}
+
+func (t *CassetteTransport) next() http.RoundTripper {
+	if t.Next != nil {
+		return t.Next
+	}
+	return http.DefaultTransport
+}
+
+func (t *CassetteTransport) record(req *http.Request) (*http.Response, error) {
+	resp, err := t.next().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response to record: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	interaction := Interaction{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	path := t.cassettePath(req)
+	key := filepath.Base(path)
+
+	// The first response of a run replaces whatever an earlier recording left behind
+	var interactions []Interaction
+	if t.replay[key] > 0 {
+		if interactions, err = readCassette(path); err != nil {
+			return nil, err
+		}
+	}
+	t.replay[key]++
+	interactions = append(interactions, interaction)
+	if err := writeCassette(path, interactions); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *CassetteTransport) play(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	path := t.cassettePath(req)
+	interactions, err := readCassette(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(interactions) == 0) {
+		return nil, fmt.Errorf("%w for %s %s", ErrCassetteMiss, req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := filepath.Base(path)
+	index := t.replay[key]
+	if index >= len(interactions) {
+		index = len(interactions) - 1
+	}
+	t.replay[key] = index + 1
+
+	interaction := interactions[index]
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.StatusCode, http.StatusText(interaction.StatusCode)),
+		StatusCode:    interaction.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(interaction.Body)),
+		ContentLength: int64(len(interaction.Body)),
+		Request:       req,
+	}, nil
+}
+
+// cassettePath names the cassette after the request host and a hash of the method and url.
+func (t *CassetteTransport) cassettePath(req *http.Request) string {
+	u := *req.URL
+	u.RawQuery = u.Query().Encode()
+	sum := sha256.Sum256([]byte(req.Method + " " + u.String()))
+	return filepath.Join(t.dir, req.URL.Hostname(), hex.EncodeToString(sum[:16])+".json")
+}
+
+func readCassette(path string) ([]Interaction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("decode cassette %s: %w", path, err)
+	}
+	return interactions, nil
+}
+
+func writeCassette(path string, interactions []Interaction) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create cassette dir: %w", err)
+	}
+	data, err := json.MarshalIndent(interactions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode cassette %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("write cassette %s: %w", path, err)
+	}
+	return nil
+}
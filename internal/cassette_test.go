@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func getBody(t *testing.T, client *http.Client, url string) (int, string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first call is throttled, retries succeed
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("slow down"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"` + r.URL.Query().Get("id") + `"}`))
+	}))
+
+	dir := t.TempDir()
+	transport := NewCassetteTransport(HTTPModeRecord, dir, nil)
+	client := &http.Client{Transport: transport}
+
+	if status, _ := getBody(t, client, server.URL+"/series?id=1&b=2"); status != http.StatusTooManyRequests {
+		t.Fatalf("expected the first recorded call to be throttled, got %d", status)
+	}
+	if status, body := getBody(t, client, server.URL+"/series?id=1&b=2"); status != http.StatusOK || body != `{"id":"1"}` {
+		t.Fatalf("unexpected recorded response %d %s", status, body)
+	}
+	server.Close()
+
+	transport.SetMode(HTTPModeReplay, dir)
+
+	// Query parameters in a different order hit the same cassette, replayed in recorded order
+	if status, body := getBody(t, client, server.URL+"/series?b=2&id=1"); status != http.StatusTooManyRequests || body != "slow down" {
+		t.Errorf("expected the throttled response first, got %d %s", status, body)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL + "/series?id=1&b=2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != `{"id":"1"}` {
+			t.Errorf("replay %d: unexpected response %d %s", i, resp.StatusCode, body)
+		}
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("replay %d: headers were not replayed: %v", i, resp.Header)
+		}
+	}
+
+	if _, err := client.Get(server.URL + "/series?id=2"); !errors.Is(err, ErrCassetteMiss) {
+		t.Errorf("expected ErrCassetteMiss for an unrecorded request, got %v", err)
+	}
+}
+
+func TestCassetteRecordOverwritesPreviousRun(t *testing.T) {
+	body := "first"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	transport := NewCassetteTransport(HTTPModeRecord, dir, nil)
+	client := &http.Client{Transport: transport}
+	getBody(t, client, server.URL)
+
+	body = "second"
+	transport.SetMode(HTTPModeRecord, dir)
+	getBody(t, client, server.URL)
+
+	transport.SetMode(HTTPModeReplay, dir)
+	if _, got := getBody(t, client, server.URL); got != "second" {
+		t.Errorf("expected the latest recording, got %s", got)
+	}
+}
+
+func TestParseHTTPMode(t *testing.T) {
+	for _, value := range []string{"live", "record", "replay"} {
+		if mode, err := ParseHTTPMode(value); err != nil || string(mode) != value {
+			t.Errorf("ParseHTTPMode(%q) = %q, %v", value, mode, err)
+		}
+	}
+	if _, err := ParseHTTPMode("offline"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
@@ -3,43 +3,86 @@ package internal
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"iter"
 	"log"
 )
 
 const TableMangaupdates = "MANGAUPDATES_OLD"
 const TableMangaupdatesNewId = "MANGAUPDATES_NEW"
+const TableResolveAttempts = "RESOLVE_ATTEMPTS"
 const TableAnilist = "ANILIST"
 const TableMyanimelist = "MYANIMELIST"
 const TableManga = "MANGA"
 const TableSimilar = "SIMILAR"
+const TableSimilarInbound = "SIMILAR_INBOUND"
+const TableMangaRemoved = "MANGA_REMOVED"
+const TableMetadataCheckpoint = "METADATA_CHECKPOINT"
+const TableCoverCache = "COVER_CACHE"
+const TableMappingConflicts = "MAPPING_CONFLICTS"
+const TableLinkIssues = "LINK_ISSUES"
+const TableMappingHistory = "MAPPING_HISTORY"
+const TableMappingInferred = "MAPPING_INFERRED"
 const TableNovelUpdates = "NOVEL_UPDATES"
 const TableKitsu = "KITSU"
+const TableKitsuResolved = "KITSU_RESOLVED"
 const TableBookWalker = "BOOK_WALKER"
 const TableAnimePlanet = "ANIME_PLANET"
+const TableAmazon = "AMAZON"
+const TableEbookJapan = "EBOOK_JAPAN"
+const TableRaw = "RAW"
+const TableEnglishTl = "ENGLISH_TL"
 
 const TableNekoMappings = "mappings"
+const TableNekoMetadata = "metadata"
 
 var DB *sql.DB
 
-func ConnectDB() {
+func ConnectDB() error {
 	db, err := sql.Open("sqlite3", "data/data.db")
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := Migrate(db, DataMigrations); err != nil {
+		db.Close()
+		return fmt.Errorf("migrate database: %w", err)
+	}
+	DB = db
+	return nil
+}
+
+// ConnectDBReadOnly opens data.db read only, without migrating it.
+func ConnectDBReadOnly() error {
+	db, err := sql.Open("sqlite3", "file:data/data.db?mode=ro")
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
 	}
 	db.SetMaxOpenConns(1)
 	DB = db
+	return nil
 }
 
-func ConnectNekoDB(name string) *sql.DB {
-	db, err := sql.Open("sqlite3", "data/"+name+".db")
+func ConnectNekoDB(name string) (*sql.DB, error) {
+	return OpenNekoDB("data/" + name + ".db")
+}
+
+// OpenNekoDB opens the neko database at path, migrating it to the latest schema.
+func OpenNekoDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		panic(err.Error())
+		return nil, fmt.Errorf("open neko database %s: %w", path, err)
 	}
 	db.SetMaxOpenConns(1)
-	return db
+	if err := Migrate(db, NekoMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate neko database %s: %w", path, err)
+	}
+	return db, nil
 }
 
+// CheckErr exits the program on any error.
+// Deprecated: Return a wrapped error to the command instead.
 func CheckErr(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -94,7 +137,7 @@ func GetAllManga() []Manga {
 func GetMangaCount() (int, error) {
 	var count int
 	if err := DB.QueryRow("SELECT COUNT(*) FROM " + TableManga).Scan(&count); err != nil {
-		return 0, err
+		return 0, fmt.Errorf("count manga: %w", err)
 	}
 	return count, nil
 }
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MappingTimeFormat is how history times are stored, sortable as text.
+const MappingTimeFormat = time.RFC3339
+
+// Execer runs statements against either the database or a transaction.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// MappingRun is the run changing mappings, recorded as the source of each change.
+type MappingRun struct {
+	Id string
+	At time.Time
+}
+
+// NewMappingRun starts a run of the given command.
+func NewMappingRun(command string) MappingRun {
+	at := time.Now().UTC().Truncate(time.Second)
+	return MappingRun{Id: command + " " + at.Format(MappingTimeFormat), At: at}
+}
+
+// SetMapping stores the external id of a manga for a site. A new or changed id closes the
+// history interval of the previous id and opens one for the new id.
+func SetMapping(db Execer, site MappingSite, uuid string, id string, run MappingRun) error {
+	var current string
+	err := db.QueryRow("SELECT ID FROM "+site.Table+" WHERE UUID = ?", uuid).Scan(&current)
+	if err == nil && current == id {
+		return nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("query %s %s: %w", site.Table, uuid, err)
+	}
+
+	_, err = db.Exec("INSERT INTO "+site.Table+" (UUID, ID) VALUES (?, ?) ON CONFLICT (UUID) DO UPDATE SET ID=excluded.ID", uuid, id)
+	if err != nil {
+		return fmt.Errorf("upsert %s %s: %w", site.Table, uuid, err)
+	}
+	if err := closeMappingHistory(db, site, uuid, run); err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO "+TableMappingHistory+" (SITE, UUID, ID, VALID_FROM, VALID_TO, SOURCE_RUN) VALUES (?, ?, ?, ?, NULL, ?)",
+		site.Key, uuid, id, run.At.Format(MappingTimeFormat), run.Id)
+	if err != nil {
+		return fmt.Errorf("record %s history %s: %w", site.Key, uuid, err)
+	}
+	return nil
+}
+
+// DeleteMapping removes the external id of a manga for a site, closing its history interval.
+func DeleteMapping(db Execer, site MappingSite, uuid string, run MappingRun) error {
+	if _, err := db.Exec("DELETE FROM "+site.Table+" WHERE UUID = ?", uuid); err != nil {
+		return fmt.Errorf("delete %s %s: %w", site.Table, uuid, err)
+	}
+	return closeMappingHistory(db, site, uuid, run)
+}
+
+func closeMappingHistory(db Execer, site MappingSite, uuid string, run MappingRun) error {
+	_, err := db.Exec("UPDATE "+TableMappingHistory+" SET VALID_TO = ? WHERE SITE = ? AND UUID = ? AND VALID_TO IS NULL",
+		run.At.Format(MappingTimeFormat), site.Key, uuid)
+	if err != nil {
+		return fmt.Errorf("close %s history %s: %w", site.Key, uuid, err)
+	}
+	return nil
+}
+
+// BackfillMappingHistory opens a history interval for every mapping stored before history was
+// kept. Their start is unknown and left empty, so they never show up as added.
+func BackfillMappingHistory(run MappingRun) error {
+	for _, site := range MappingSites {
+		_, err := DB.Exec("INSERT INTO "+TableMappingHistory+" (SITE, UUID, ID, VALID_FROM, VALID_TO, SOURCE_RUN) "+
+			"SELECT ?, UUID, ID, NULL, NULL, ? FROM "+site.Table+" AS m WHERE NOT EXISTS "+
+			"(SELECT 1 FROM "+TableMappingHistory+" AS h WHERE h.SITE = ? AND h.UUID = m.UUID AND h.VALID_TO IS NULL)",
+			site.Key, run.Id, site.Key)
+		if err != nil {
+			return fmt.Errorf("backfill %s history: %w", site.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMappingHistory(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	originalDB := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = originalDB
+		db.Close()
+	})
+	for _, site := range MappingSites {
+		if _, err := db.Exec("CREATE TABLE " + site.Table + " (UUID TEXT PRIMARY KEY, ID TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Migrate(db, DataMigrations); err != nil {
+		t.Fatal(err)
+	}
+	// Stored before history was kept
+	if _, err := db.Exec("INSERT INTO " + TableMyanimelist + " (UUID, ID) VALUES ('uuid-2', '13')"); err != nil {
+		t.Fatal(err)
+	}
+
+	anilist, _ := MappingSiteByKey("al")
+	myanimelist, _ := MappingSiteByKey("mal")
+	first := MappingRun{Id: "first", At: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	second := MappingRun{Id: "second", At: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+
+	if err := BackfillMappingHistory(first); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []func() error{
+		func() error { return SetMapping(db, anilist, "uuid-1", "30013", first) },
+		// Unchanged ids are not recorded again
+		func() error { return SetMapping(db, anilist, "uuid-1", "30013", second) },
+		func() error { return SetMapping(db, anilist, "uuid-1", "30014", second) },
+		func() error { return DeleteMapping(db, myanimelist, "uuid-2", second) },
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query("SELECT SITE, UUID, ID, COALESCE(VALID_FROM, ''), COALESCE(VALID_TO, ''), SOURCE_RUN FROM " + TableMappingHistory + " ORDER BY SITE, UUID, ID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var site, uuid, id, validFrom, validTo, run string
+		if err := rows.Scan(&site, &uuid, &id, &validFrom, &validTo, &run); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, site+" "+uuid+" "+id+" ["+validFrom+", "+validTo+") "+run)
+	}
+	want := []string{
+		"al uuid-1 30013 [2024-01-01T00:00:00Z, 2024-02-01T00:00:00Z) first",
+		"al uuid-1 30014 [2024-02-01T00:00:00Z, ) second",
+		"mal uuid-2 13 [, 2024-02-01T00:00:00Z) first",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected history %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("history row %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	var id string
+	if err := db.QueryRow("SELECT ID FROM " + TableAnilist + " WHERE UUID = 'uuid-1'").Scan(&id); err != nil || id != "30014" {
+		t.Errorf("expected the current id 30014, got %q (%v)", id, err)
+	}
+}
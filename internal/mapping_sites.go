@@ -0,0 +1,37 @@
+package internal
+
+// MappingSite links a MangaDex link key (as documented in the README) to the table
+// holding the external id for each manga.
+type MappingSite struct {
+	Key   string
+	Name  string
+	Table string
+}
+
+// MappingSites is every site we keep a mapping table for, keyed the same way as the
+// columns of the neko mapping database.
+var MappingSites = []MappingSite{
+	{"al", "AniList", TableAnilist},
+	{"ap", "AnimePlanet", TableAnimePlanet},
+	{"bw", "BookWalker", TableBookWalker},
+	{"mu", "MangaUpdates", TableMangaupdates},
+	{"mu_new", "MangaUpdates New Id", TableMangaupdatesNewId},
+	{"nu", "NovelUpdates", TableNovelUpdates},
+	{"kt", "Kitsu", TableKitsu},
+	{"kt_id", "Kitsu Id", TableKitsuResolved},
+	{"mal", "MyAnimeList", TableMyanimelist},
+	{"amz", "Amazon", TableAmazon},
+	{"ebj", "eBookJapan", TableEbookJapan},
+	{"raw", "Raw", TableRaw},
+	{"engtl", "Official English", TableEnglishTl},
+}
+
+// MappingSiteByKey returns the mapping site for a link key such as "al" or "mu_new".
+func MappingSiteByKey(key string) (MappingSite, bool) {
+	for _, site := range MappingSites {
+		if site.Key == key {
+			return site, true
+		}
+	}
+	return MappingSite{}, false
+}
@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const TableSchemaVersion = "schema_version"
+
+// Migration is one versioned change to the schema of a database.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(tx *sql.Tx) error
+}
+
+// createTables returns a migration step running each CREATE statement. The statements skip
+// tables which exist already, so databases created from the old template files migrate too.
+func createTables(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// mappingTable is the schema of a table mapping MangaDex uuids to the id of a site.
+func mappingTable(table string) string {
+	return "CREATE TABLE IF NOT EXISTS " + table + " (UUID TEXT PRIMARY KEY, ID TEXT)"
+}
+
+// DataMigrations builds the schema of data.db, in the order they are applied.
+var DataMigrations = []Migration{
+	{1, "initial schema", createTables(
+		"CREATE TABLE IF NOT EXISTS "+TableManga+" (UUID TEXT PRIMARY KEY, JSON TEXT, DATE TEXT)",
+		"CREATE TABLE IF NOT EXISTS "+TableSimilar+" (UUID TEXT PRIMARY KEY, JSON BLOB)",
+		mappingTable(TableAnilist),
+		mappingTable(TableAnimePlanet),
+		mappingTable(TableBookWalker),
+		mappingTable(TableKitsu),
+		mappingTable(TableMyanimelist),
+		mappingTable(TableMangaupdates),
+		mappingTable(TableMangaupdatesNewId),
+		mappingTable(TableNovelUpdates),
+	)},
+	{2, "similar inbound", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableSimilarInbound + " (UUID TEXT PRIMARY KEY, JSON BLOB)",
+	)},
+	{3, "removed manga", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableMangaRemoved + " (UUID TEXT PRIMARY KEY, TITLE TEXT, REMOVED_AT TEXT)",
+	)},
+	{4, "metadata checkpoint", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableMetadataCheckpoint + " (ID INTEGER PRIMARY KEY CHECK (ID = 1), RUN_ID TEXT, STARTED_AT TEXT, LAST_BATCH INTEGER, LAST_UUID TEXT, CHECKED INTEGER, MISSING TEXT)",
+	)},
+	{5, "cover cache", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableCoverCache + " (UUID TEXT PRIMARY KEY, FILE_NAME TEXT, SIZE TEXT, SHA256 TEXT, FETCHED_AT TEXT)",
+	)},
+	{6, "link mappings", createTables(
+		mappingTable(TableAmazon),
+		mappingTable(TableEbookJapan),
+		mappingTable(TableRaw),
+		mappingTable(TableEnglishTl),
+	)},
+	{7, "mapping conflicts", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableMappingConflicts + " (SITE TEXT, ID TEXT, UUID TEXT, CANONICAL INTEGER, PRIMARY KEY (SITE, ID, UUID))",
+	)},
+	{8, "link issues", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableLinkIssues + " (SITE TEXT, UUID TEXT, VALUE TEXT, FIXED TEXT, ERROR TEXT)",
+	)},
+	{9, "resolved kitsu ids", createTables(
+		mappingTable(TableKitsuResolved),
+	)},
+	{10, "resolve attempts", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableResolveAttempts + " (SITE TEXT, UUID TEXT, LINK TEXT, ATTEMPTS INTEGER, OUTCOME TEXT, LAST_ATTEMPT TEXT, NEXT_RETRY TEXT, PRIMARY KEY (SITE, UUID))",
+	)},
+	{11, "mapping history", createTables(
+		"CREATE TABLE IF NOT EXISTS "+TableMappingHistory+" (SITE TEXT, UUID TEXT, ID TEXT, VALID_FROM TEXT, VALID_TO TEXT, SOURCE_RUN TEXT)",
+		"CREATE INDEX IF NOT EXISTS MAPPING_HISTORY_OPEN ON "+TableMappingHistory+" (SITE, UUID, VALID_TO)",
+	)},
+	{12, "inferred mappings", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableMappingInferred + " (SITE TEXT, UUID TEXT, ID TEXT, VIA TEXT, SOURCE TEXT, PRIMARY KEY (SITE, UUID))",
+	)},
+}
+
+// NekoLinkColumns are the neko columns added after the initial schema.
+var NekoLinkColumns = []string{"amz", "ebj", "raw", "engtl", "kt_id"}
+
+// NekoMigrations builds the schema of the neko mapping database, in the order they are applied.
+var NekoMigrations = []Migration{
+	{1, "initial schema", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableNekoMappings + " (mdex TEXT PRIMARY KEY, al TEXT, ap TEXT, bw TEXT, mu TEXT, mu_new TEXT, nu TEXT, kt TEXT, mal TEXT)",
+	)},
+	{2, "link columns", func(tx *sql.Tx) error {
+		return AddMissingColumns(tx, TableNekoMappings, NekoLinkColumns)
+	}},
+	{3, "data version", createTables(
+		"CREATE TABLE IF NOT EXISTS " + TableNekoMetadata + " (id INTEGER PRIMARY KEY CHECK (id = 1), data_version INTEGER NOT NULL, updated_at TEXT)",
+	)},
+}
+
+// AddMissingColumns adds the TEXT columns a table does not have yet.
+func AddMissingColumns(tx *sql.Tx, table string, columns []string) error {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info('" + table + "')")
+	if err != nil {
+		return fmt.Errorf("read %s columns: %w", table, err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("read %s columns: %w", table, err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read %s columns: %w", table, err)
+	}
+
+	for _, column := range columns {
+		if existing[column] {
+			continue
+		}
+		if _, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " TEXT"); err != nil {
+			return fmt.Errorf("add %s column %s: %w", table, column, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied to db, 0 if none was.
+func SchemaVersion(db *sql.DB) (int, error) {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS " + TableSchemaVersion + " (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT)"); err != nil {
+		return 0, fmt.Errorf("create schema version table: %w", err)
+	}
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM " + TableSchemaVersion).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// RequireSchema checks, without writing to it, that db has every migration applied. Commands
+// opening the database read only call it instead of migrating.
+func RequireSchema(db *sql.DB, migrations []Migration) error {
+	var tables int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", TableSchemaVersion).Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	version := 0
+	if tables > 0 {
+		if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM " + TableSchemaVersion).Scan(&version); err != nil {
+			return fmt.Errorf("read schema version: %w", err)
+		}
+	}
+	if version < len(migrations) {
+		return fmt.Errorf("database schema version %d is older than the %d migrations known, run a command which writes to it, such as calculate, to migrate it first",
+			version, len(migrations))
+	}
+	return nil
+}
+
+// Migrate applies the migrations newer than the schema version of db, each in its own
+// transaction together with its schema_version row.
+func Migrate(db *sql.DB, migrations []Migration) error {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, expected %d", migration.Name, migration.Version, i+1)
+		}
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the %d migrations known", current, len(migrations))
+	}
+
+	for _, migration := range migrations[current:] {
+		if err := applyMigration(db, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration %d: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if err := migration.Apply(tx); err != nil {
+		return fmt.Errorf("apply migration %d %s: %w", migration.Version, migration.Name, err)
+	}
+	_, err = tx.Exec("INSERT INTO "+TableSchemaVersion+" (version, name, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("record migration %d: %w", migration.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit migration %d: %w", migration.Version, err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openMigrationsTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := openMigrationsTestDB(t)
+	if err := Migrate(db, DataMigrations); err != nil {
+		t.Fatal(err)
+	}
+	// Applying them again is a no-op
+	if err := Migrate(db, DataMigrations); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(DataMigrations) {
+		t.Errorf("expected schema version %d, got %d", len(DataMigrations), version)
+	}
+	var applied int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + TableSchemaVersion).Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(DataMigrations) {
+		t.Errorf("expected %d schema_version rows, got %d", len(DataMigrations), applied)
+	}
+
+	tables := []string{TableManga, TableSimilar, TableSimilarInbound, TableMangaRemoved, TableMetadataCheckpoint, TableCoverCache,
+		TableMappingConflicts, TableLinkIssues, TableResolveAttempts, TableMappingHistory, TableMappingInferred}
+	for _, site := range MappingSites {
+		tables = append(tables, site.Table)
+	}
+	for _, table := range tables {
+		var name string
+		if err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name); err != nil {
+			t.Errorf("expected table %s to be created: %v", table, err)
+		}
+	}
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	db := openMigrationsTestDB(t)
+	if err := Migrate(db, NekoMigrations); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db, NekoMigrations[:1]); err == nil {
+		t.Error("expected a database newer than the migrations to fail")
+	}
+}
+
+func TestMigrateNekoTemplateDatabase(t *testing.T) {
+	db := openMigrationsTestDB(t)
+	// The mappings table of the old template database
+	if _, err := db.Exec("CREATE TABLE " + TableNekoMappings + " (mdex TEXT, al TEXT, ap TEXT, bw TEXT, mu TEXT, mu_new TEXT, nu TEXT, kt TEXT, mal TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO " + TableNekoMappings + " (mdex, al) VALUES ('uuid-1', '1')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db, NekoMigrations); err != nil {
+		t.Fatal(err)
+	}
+
+	var al, ktId sql.NullString
+	if err := db.QueryRow("SELECT al, kt_id FROM "+TableNekoMappings+" WHERE mdex = 'uuid-1'").Scan(&al, &ktId); err != nil {
+		t.Fatal(err)
+	}
+	if al.String != "1" || ktId.Valid {
+		t.Errorf("expected the row kept with an empty kt_id, got al=%v kt_id=%v", al, ktId)
+	}
+	if _, err := db.Exec("INSERT INTO " + TableNekoMetadata + " (id, data_version) VALUES (1, 1)"); err != nil {
+		t.Errorf("expected the metadata table to be created: %v", err)
+	}
+}
+
+func TestMigrationVersionsAreOrdered(t *testing.T) {
+	bad := []Migration{{1, "first", createTables()}, {3, "skipped", createTables()}}
+	if err := Migrate(openMigrationsTestDB(t), bad); err == nil {
+		t.Error("expected a gap in the migration versions to fail")
+	}
+}
+
+func TestRequireSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE " + TableManga + " (UUID TEXT PRIMARY KEY, JSON TEXT, DATE TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	// Never migrated, and checking it must not write to it
+	if err := RequireSchema(readOnly, DataMigrations); err == nil || !strings.Contains(err.Error(), "schema version 0") {
+		t.Errorf("expected an unmigrated database to be refused, got %v", err)
+	}
+	if err := Migrate(db, DataMigrations); err != nil {
+		t.Fatal(err)
+	}
+	if err := RequireSchema(readOnly, DataMigrations); err != nil {
+		t.Errorf("expected a migrated database to pass, got %v", err)
+	}
+}
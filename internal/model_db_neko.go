@@ -13,5 +13,10 @@ type DbNeko struct {
 	MANGAUPDATES_NEW string
 	NOVEL_UPDATES    string
 	KITSU            string
+	KITSU_ID         string
 	MYANIMELIST      string
+	AMAZON           string
+	EBOOKJAPAN       string
+	RAW              string
+	ENGLISH_TL       string
 }
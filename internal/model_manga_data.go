@@ -13,6 +13,30 @@ type Manga struct {
 	PublicationDemographic       string              `json:"publicationDemographic,omitempty"`
 	ContentRating                string              `json:"contentRating,omitempty"`
 	Tags                         []Tag               `json:"tags,omitempty"`
+	CoverFileName                string              `json:"coverFileName,omitempty"`
+	CreatedAt                    string              `json:"createdAt,omitempty"`
+}
+
+// CoverBaseUrl serves the cover art of every manga.
+const CoverBaseUrl = "https://uploads.mangadex.org"
+
+// CoverUrl returns the url of the 256px wide thumbnail of the cover, or an empty string
+// when the cover is not known.
+func (m Manga) CoverUrl() string {
+	return CoverFileUrl(CoverBaseUrl, m.Id, m.CoverFileName, "256")
+}
+
+// CoverFileUrl builds the url of a cover on the given host. The size is the width of the
+// thumbnail, 256 or 512, or empty for the original image.
+func CoverFileUrl(baseUrl string, mangaId string, fileName string, size string) string {
+	if fileName == "" {
+		return ""
+	}
+	url := baseUrl + "/covers/" + mangaId + "/" + fileName
+	if size != "" {
+		url += "." + size + ".jpg"
+	}
+	return url
 }
 
 type Tag struct {
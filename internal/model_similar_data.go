@@ -14,4 +14,17 @@ type SimilarMatch struct {
 	ContentRating string            `json:"contentRating,omitempty"`
 	Score         float32           `json:"score,omitempty"`
 	Languages     []string          `json:"languages,omitempty"`
+	CoverUrl      string            `json:"coverUrl,omitempty"`
+}
+
+type InboundManga struct {
+	Id      string         `json:"id,omitempty"`
+	Inbound []InboundMatch `json:"inbound,omitempty"`
+}
+
+type InboundMatch struct {
+	Id    string            `json:"id,omitempty"`
+	Title map[string]string `json:"title,omitempty"`
+	Rank  int               `json:"rank,omitempty"`
+	Score float32           `json:"score,omitempty"`
 }
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// mangaTables are the tables with rows keyed by the uuid of a manga, besides the mapping tables.
+var mangaTables = []string{TableManga, TableSimilar, TableSimilarInbound, TableCoverCache, TableMappingInferred,
+	TableResolveAttempts, TableMappingConflicts, TableLinkIssues}
+
+// PurgeManga deletes a manga removed from MangaDex from every table and from the matches of
+// other manga, so no export points at it anymore. Its mappings are closed through their
+// history so the removal shows up in mapping diffs.
+func PurgeManga(tx *sql.Tx, uuid string, run MappingRun) error {
+	for _, table := range mangaTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE UUID = ?", uuid); err != nil {
+			return fmt.Errorf("remove manga %s from %s: %w", uuid, table, err)
+		}
+	}
+	for _, site := range MappingSites {
+		if err := DeleteMapping(tx, site, uuid, run); err != nil {
+			return fmt.Errorf("remove manga %s: %w", uuid, err)
+		}
+	}
+
+	err := rewriteMatches(tx, TableSimilar, uuid, func(data []byte) ([]byte, error) {
+		var similar SimilarManga
+		if err := json.Unmarshal(data, &similar); err != nil {
+			return nil, err
+		}
+		similar.SimilarMatches = slices.DeleteFunc(similar.SimilarMatches, func(match SimilarMatch) bool { return match.Id == uuid })
+		return json.Marshal(similar)
+	})
+	if err != nil {
+		return err
+	}
+	return rewriteMatches(tx, TableSimilarInbound, uuid, func(data []byte) ([]byte, error) {
+		var inbound InboundManga
+		if err := json.Unmarshal(data, &inbound); err != nil {
+			return nil, err
+		}
+		inbound.Inbound = slices.DeleteFunc(inbound.Inbound, func(match InboundMatch) bool { return match.Id == uuid })
+		if len(inbound.Inbound) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(inbound)
+	})
+}
+
+// rewriteMatches rewrites the json of every row of table mentioning uuid. A row rewritten to
+// nil is deleted.
+func rewriteMatches(tx *sql.Tx, table string, uuid string, rewrite func([]byte) ([]byte, error)) error {
+	rows, err := tx.Query("SELECT UUID, JSON FROM "+table+" WHERE JSON LIKE ?", `%"`+uuid+`"%`)
+	if err != nil {
+		return fmt.Errorf("query %s matches of %s: %w", table, uuid, err)
+	}
+	// Read every row first, the transaction has a single connection
+	rewritten := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan %s matches of %s: %w", table, uuid, err)
+		}
+		if rewritten[id], err = rewrite(data); err != nil {
+			rows.Close()
+			return fmt.Errorf("rewrite %s %s: %w", table, id, err)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate %s matches of %s: %w", table, uuid, err)
+	}
+
+	for id, data := range rewritten {
+		if data == nil {
+			_, err = tx.Exec("DELETE FROM "+table+" WHERE UUID = ?", id)
+		} else {
+			_, err = tx.Exec("UPDATE "+table+" SET JSON = ? WHERE UUID = ?", data, id)
+		}
+		if err != nil {
+			return fmt.Errorf("update %s %s: %w", table, id, err)
+		}
+	}
+	return nil
+}
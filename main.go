@@ -3,9 +3,13 @@ package main
 import (
 	"github.com/similar-manga/similar/cmd"
 	_ "github.com/similar-manga/similar/cmd/calculate"
+	_ "github.com/similar-manga/similar/cmd/inbound"
 	_ "github.com/similar-manga/similar/cmd/init"
+	_ "github.com/similar-manga/similar/cmd/lookup"
 	_ "github.com/similar-manga/similar/cmd/mangadex"
 	_ "github.com/similar-manga/similar/cmd/neko"
+	_ "github.com/similar-manga/similar/cmd/search"
+	_ "github.com/similar-manga/similar/cmd/serve"
 )
 
 func main() {
@@ -37,8 +37,9 @@ var (
 // APIClient manages communication with the MangaDex API API v5.9.0
 // In most cases there should be only one, shared, APIClient.
 type APIClient struct {
-	cfg    *Configuration
-	common service // Reuse a single struct instead of allocating one for each service on the heap.
+	cfg     *Configuration
+	common  service // Reuse a single struct instead of allocating one for each service on the heap.
+	limiter *RateLimiter
 
 	MangaApi *MangaApiService
 }
@@ -57,6 +58,7 @@ func NewAPIClient(cfg *Configuration) *APIClient {
 	c := &APIClient{}
 	c.cfg = cfg
 	c.common.client = c
+	c.limiter = NewRateLimiter(cfg.RateLimitInterval)
 
 	c.MangaApi = (*MangaApiService)(&c.common)
 
@@ -119,9 +121,19 @@ func parameterToString(obj interface{}, collectionFormat string) string {
 	return fmt.Sprintf("%v", obj)
 }
 
-// callAPI do the request.
+// callAPI do the request, waiting on the rate limiter first and feeding the response back to it.
 func (c *APIClient) callAPI(request *http.Request) (*http.Response, error) {
-	return c.cfg.HTTPClient.Do(request)
+	if err := c.limiter.Wait(request.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := c.cfg.HTTPClient.Do(request)
+	c.limiter.Observe(resp, err)
+	return resp, err
+}
+
+// RateLimiter returns the limiter pacing every request made by this client.
+func (c *APIClient) RateLimiter() *RateLimiter {
+	return c.limiter
 }
 
 // Change base path to allow switching to mocks
@@ -225,9 +237,9 @@ func (c *APIClient) prepareRequest(
 
 	// Generate a new request
 	if body != nil {
-		localVarRequest, err = http.NewRequest(method, url.String(), body)
+		localVarRequest, err = http.NewRequestWithContext(ctx, method, url.String(), body)
 	} else {
-		localVarRequest, err = http.NewRequest(method, url.String(), nil)
+		localVarRequest, err = http.NewRequestWithContext(ctx, method, url.String(), nil)
 	}
 	if err != nil {
 		return nil, err
@@ -11,6 +11,7 @@ package mangadex
 
 import (
 	"net/http"
+	"time"
 )
 
 // contextKeys are used to identify the type of value in the context.
@@ -56,6 +57,8 @@ type Configuration struct {
 	DefaultHeader map[string]string `json:"defaultHeader,omitempty"`
 	UserAgent     string            `json:"userAgent,omitempty"`
 	HTTPClient    *http.Client
+	// RateLimitInterval is the minimum time between two requests
+	RateLimitInterval time.Duration `json:"rateLimitInterval,omitempty"`
 }
 
 func NewConfiguration() *Configuration {
@@ -0,0 +1,48 @@
+package mangadex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrRateLimited matches, with errors.Is, any APIError caused by MangaDex throttling us.
+var ErrRateLimited = errors.New("rate limited")
+
+// APIError is returned for every response MangaDex answered but we could not use. The
+// embedded GenericSwaggerError gives access to the raw body and any decoded ErrorResponse.
+type APIError struct {
+	GenericSwaggerError
+	Operation   string
+	StatusCode  int
+	ContentType string
+}
+
+func newAPIError(operation string, resp *http.Response, body []byte, message string, model interface{}) *APIError {
+	return &APIError{
+		GenericSwaggerError: GenericSwaggerError{body: body, error: message, model: model},
+		Operation:           operation,
+		StatusCode:          resp.StatusCode,
+		ContentType:         resp.Header.Get("Content-Type"),
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: http %d: %s", e.Operation, e.StatusCode, e.GenericSwaggerError.Error())
+}
+
+// RateLimited reports a 429 or the html page served with a 200 on the soft rate limit.
+func (e *APIError) RateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		(e.StatusCode < 300 && strings.HasPrefix(e.ContentType, "text/html"))
+}
+
+// Temporary reports whether retrying the same request later may succeed.
+func (e *APIError) Temporary() bool {
+	return e.RateLimited() || e.StatusCode >= 500
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == ErrRateLimited && e.RateLimited()
+}
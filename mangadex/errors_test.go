@@ -0,0 +1,81 @@
+package mangadex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func searchAgainst(t *testing.T, handler http.HandlerFunc) error {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	config := NewConfiguration()
+	config.BasePath = server.URL
+	config.RateLimitInterval = time.Nanosecond
+	client := NewAPIClient(config)
+
+	_, _, err := client.MangaApi.GetSearchManga(context.Background(), &MangaApiGetSearchMangaOpts{})
+	return err
+}
+
+func TestGetSearchMangaErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		rateLimited bool
+		temporary   bool
+	}{
+		{"too many requests", http.StatusTooManyRequests, "application/json", `{"result":"error","errors":[]}`, true, true},
+		{"soft limit html", http.StatusOK, "text/html", "<html>slow down</html>", true, true},
+		{"server error", http.StatusBadGateway, "text/plain", "bad gateway", false, true},
+		{"bad request", http.StatusBadRequest, "application/json", `{"result":"error","errors":[{"status":400,"title":"bad"}]}`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := searchAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected *APIError, got %v", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("status code = %d, want %d", apiErr.StatusCode, tt.status)
+			}
+			if string(apiErr.Body()) != tt.body {
+				t.Errorf("body = %q, want %q", apiErr.Body(), tt.body)
+			}
+			if apiErr.RateLimited() != tt.rateLimited || errors.Is(err, ErrRateLimited) != tt.rateLimited {
+				t.Errorf("rate limited = %v, want %v", apiErr.RateLimited(), tt.rateLimited)
+			}
+			if apiErr.Temporary() != tt.temporary {
+				t.Errorf("temporary = %v, want %v", apiErr.Temporary(), tt.temporary)
+			}
+		})
+	}
+}
+
+func TestGetSearchMangaDecodesErrorResponse(t *testing.T) {
+	err := searchAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"result":"error","errors":[{"status":400,"title":"bad"}]}`))
+	})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %v", err)
+	}
+	if _, ok := apiErr.Model().(ErrorResponse); !ok {
+		t.Errorf("expected decoded ErrorResponse model, got %T", apiErr.Model())
+	}
+}
@@ -0,0 +1,104 @@
+package mangadextest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex"
+)
+
+// dumpTimeFormat is the DATE column written to the data/manga dump.
+const dumpTimeFormat = "2006-01-02T15:04:05"
+
+// LoadDump reads every manga_*.txt file of a data/manga dump directory.
+func LoadDump(dir string) ([]mangadex.Manga, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "manga_*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	var all []mangadex.Manga
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		manga, err := ParseDump(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		all = append(all, manga...)
+	}
+	return all, nil
+}
+
+// ParseDump reads manga in the data/manga dump format, one `uuid:::||@!@||:::date:::||@!@||:::json`
+// line each. The date is used as both the createdAt and updatedAt of the manga.
+func ParseDump(r io.Reader) ([]mangadex.Manga, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var all []mangadex.Manga
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		split := strings.Split(scanner.Text(), ":::||@!@||:::")
+		if len(split) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 fields, got %d", line, len(split))
+		}
+		var manga internal.Manga
+		if err := json.Unmarshal([]byte(split[2]), &manga); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		date, err := time.Parse(dumpTimeFormat, split[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		manga.Id = split[0]
+		all = append(all, FromManga(manga, date))
+	}
+	return all, scanner.Err()
+}
+
+// FromManga builds the API representation of a stored manga, created and last updated at date.
+func FromManga(manga internal.Manga, date time.Time) mangadex.Manga {
+	tags := make([]mangadex.Tag, 0, len(manga.Tags))
+	for _, tag := range manga.Tags {
+		tags = append(tags, mangadex.Tag{Id: tag.Id, Type_: "tag", Attributes: &mangadex.TagAttributes{Name: tag.Name}})
+	}
+	relationships := make([]mangadex.Relationship, 0, len(manga.RelatedIds))
+	for _, id := range manga.RelatedIds {
+		relationships = append(relationships, mangadex.Relationship{Id: id, Type_: "manga", Related: "related"})
+	}
+	if manga.CoverFileName != "" {
+		var attributes interface{} = map[string]interface{}{"fileName": manga.CoverFileName}
+		relationships = append(relationships, mangadex.Relationship{Id: "cover-" + manga.Id, Type_: "cover_art", Attributes: &attributes})
+	}
+	timestamp := date.UTC().Format(time.RFC3339)
+	return mangadex.Manga{
+		Id:    manga.Id,
+		Type_: "manga",
+		Attributes: &mangadex.MangaAttributes{
+			Title:                        manga.Title,
+			AltTitles:                    manga.AltTitles,
+			Description:                  manga.Description,
+			Links:                        manga.Links,
+			OriginalLanguage:             manga.OriginalLanguage,
+			LastChapter:                  manga.LastChapter,
+			PublicationDemographic:       manga.PublicationDemographic,
+			ContentRating:                manga.ContentRating,
+			AvailableTranslatedLanguages: manga.AvailableTranslatedLanguages,
+			Tags:                         tags,
+			CreatedAt:                    timestamp,
+			UpdatedAt:                    timestamp,
+		},
+		Relationships: relationships,
+	}
+}
@@ -0,0 +1,260 @@
+// Package mangadextest provides a fake MangaDex API for end to end tests of the commands
+// which talk to MangaDex.
+package mangadextest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/similar-manga/similar/mangadex"
+)
+
+// MaxSearchWindow is the largest offset + limit the real API accepts.
+const MaxSearchWindow = 10000
+
+// timeFilterFormat is the format of the createdAtSince and updatedAtSince filters.
+const timeFilterFormat = "2006-01-02T15:04:05"
+
+// Fault is an error the server answers a request with instead of searching.
+type Fault int
+
+const (
+	// FaultRateLimited answers 429 Too Many Requests.
+	FaultRateLimited Fault = iota
+	// FaultSoftLimit answers 200 with the html page served on the soft rate limit.
+	FaultSoftLimit
+	// FaultServerError answers 503 Service Unavailable.
+	FaultServerError
+)
+
+// Server serves GET /manga from an in memory list of manga. It supports the ids[],
+// createdAtSince, updatedAtSince, order[createdAt], order[updatedAt], limit and offset
+// parameters the way MangaDex does.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	manga    map[string]entry
+	faults   []Fault
+	requests []url.Values
+}
+
+// entry keeps the parsed timestamps of a manga so searches don't parse them on every compare.
+type entry struct {
+	manga     mangadex.Manga
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+// NewServer starts a server holding the given manga. Close it when done.
+func NewServer(manga ...mangadex.Manga) *Server {
+	s := &Server{manga: make(map[string]entry)}
+	s.Add(manga...)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /manga", s.handleSearch)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Add inserts or replaces manga.
+func (s *Server) Add(manga ...mangadex.Manga) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range manga {
+		s.manga[m.Id] = entry{manga: m, createdAt: attributeTime(m, "createdAt"), updatedAt: attributeTime(m, "updatedAt")}
+	}
+}
+
+// Remove deletes manga, as MangaDex does for taken down titles.
+func (s *Server) Remove(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.manga, id)
+	}
+}
+
+// FailNext queues faults, each answering one of the following requests in order.
+func (s *Server) FailNext(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+// Requests returns the query of every request received so far, including failed ones.
+func (s *Server) Requests() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	s.requests = append(s.requests, query)
+	if len(s.faults) > 0 {
+		fault := s.faults[0]
+		s.faults = s.faults[1:]
+		s.mu.Unlock()
+		writeFault(w, fault)
+		return
+	}
+	all := make([]entry, 0, len(s.manga))
+	for _, e := range s.manga {
+		all = append(all, e)
+	}
+	s.mu.Unlock()
+
+	limit := 10
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 || limit > 100 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+	}
+	if offset+limit > MaxSearchWindow {
+		writeError(w, http.StatusBadRequest, "offset + limit must be less than or equal to 10000")
+		return
+	}
+
+	filtered, err := filterManga(all, query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list := mangadex.MangaList{Result: "ok", Response: "collection", Data: []mangadex.Manga{}, Limit: int32(limit), Offset: int32(offset), Total: int32(len(filtered))}
+	includes := query["includes[]"]
+	for i := offset; i < min(offset+limit, len(filtered)); i++ {
+		list.Data = append(list.Data, expandRelationships(filtered[i].manga, includes))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// expandRelationships only keeps the attributes of relationships whose type was requested
+// through includes[], like MangaDex's reference expansion.
+func expandRelationships(manga mangadex.Manga, includes []string) mangadex.Manga {
+	relationships := make([]mangadex.Relationship, len(manga.Relationships))
+	for i, r := range manga.Relationships {
+		if !slices.Contains(includes, r.Type_) {
+			r.Attributes = nil
+		}
+		relationships[i] = r
+	}
+	manga.Relationships = relationships
+	return manga
+}
+
+// filterManga applies the ids and time filters then the requested order. Without an order
+// the result is ordered by id so paging is stable.
+func filterManga(all []entry, query url.Values) ([]entry, error) {
+	ids := query["ids[]"]
+	createdSince, err := parseFilterTime(query.Get("createdAtSince"))
+	if err != nil {
+		return nil, err
+	}
+	updatedSince, err := parseFilterTime(query.Get("updatedAtSince"))
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []entry
+	for _, e := range all {
+		if len(ids) > 0 && !slices.Contains(ids, e.manga.Id) {
+			continue
+		}
+		if !createdSince.IsZero() && e.createdAt.Before(createdSince) {
+			continue
+		}
+		if !updatedSince.IsZero() && e.updatedAt.Before(updatedSince) {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+
+	field, direction := "", "asc"
+	for _, key := range []string{"createdAt", "updatedAt"} {
+		if value := query.Get("order[" + key + "]"); value != "" {
+			field, direction = key, value
+		}
+	}
+	slices.SortFunc(filtered, func(a, b entry) int {
+		cmp := strings.Compare(a.manga.Id, b.manga.Id)
+		switch field {
+		case "createdAt":
+			if byTime := a.createdAt.Compare(b.createdAt); byTime != 0 {
+				cmp = byTime
+			}
+		case "updatedAt":
+			if byTime := a.updatedAt.Compare(b.updatedAt); byTime != 0 {
+				cmp = byTime
+			}
+		}
+		if direction == "desc" {
+			return -cmp
+		}
+		return cmp
+	})
+	return filtered, nil
+}
+
+func parseFilterTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(timeFilterFormat, value)
+}
+
+func attributeTime(m mangadex.Manga, field string) time.Time {
+	if m.Attributes == nil {
+		return time.Time{}
+	}
+	value := m.Attributes.CreatedAt
+	if field == "updatedAt" {
+		value = m.Attributes.UpdatedAt
+	}
+	parsed, _ := time.Parse(time.RFC3339, value)
+	// The filters have second precision, compare at the same precision
+	return parsed.UTC().Truncate(time.Second)
+}
+
+func writeFault(w http.ResponseWriter, fault Fault) {
+	switch fault {
+	case FaultRateLimited:
+		w.Header().Set("Retry-After", "0")
+		writeError(w, http.StatusTooManyRequests, "you are being rate limited")
+	case FaultSoftLimit:
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("<html><body>Please slow down</body></html>"))
+	default:
+		writeError(w, http.StatusServiceUnavailable, "service unavailable")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(mangadex.ErrorResponse{
+		Result: "error",
+		Errors: []mangadex.ModelError{{Status: int32(status), Title: http.StatusText(status), Detail: detail}},
+	})
+}
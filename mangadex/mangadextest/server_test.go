@@ -0,0 +1,181 @@
+package mangadextest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex"
+)
+
+func newTestClient(server *Server) *mangadex.APIClient {
+	config := mangadex.NewConfiguration()
+	config.BasePath = server.URL
+	return mangadex.NewAPIClient(config)
+}
+
+func testManga(count int) []mangadex.Manga {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	manga := make([]mangadex.Manga, count)
+	for i := range manga {
+		manga[i] = mangadex.Manga{
+			Id: fmt.Sprintf("uuid-%02d", i),
+			Attributes: &mangadex.MangaAttributes{
+				CreatedAt: start.Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
+				// Updated in the reverse order they were created
+				UpdatedAt: start.Add(time.Duration(count-i) * time.Hour).Format(time.RFC3339),
+			},
+		}
+	}
+	return manga
+}
+
+func ids(list mangadex.MangaList) []string {
+	var result []string
+	for _, m := range list.Data {
+		result = append(result, m.Id)
+	}
+	return result
+}
+
+func TestServerSearch(t *testing.T) {
+	server := NewServer(testManga(10)...)
+	defer server.Close()
+	client := newTestClient(server)
+
+	tests := []struct {
+		name  string
+		opts  mangadex.MangaApiGetSearchMangaOpts
+		want  string
+		total int32
+	}{
+		{"ids", mangadex.MangaApiGetSearchMangaOpts{Ids: optional.NewInterface([]string{"uuid-03", "uuid-01", "missing"})}, "uuid-01,uuid-03", 2},
+		{"created desc", mangadex.MangaApiGetSearchMangaOpts{OrderCreatedAt: optional.NewString("desc"), Limit: optional.NewInt32(3)}, "uuid-09,uuid-08,uuid-07", 10},
+		{"offset", mangadex.MangaApiGetSearchMangaOpts{OrderCreatedAt: optional.NewString("asc"), Limit: optional.NewInt32(2), Offset: optional.NewInt32(4)}, "uuid-04,uuid-05", 10},
+		{"created since", mangadex.MangaApiGetSearchMangaOpts{CreatedAtSince: optional.NewString("2024-01-01T08:00:00"), OrderCreatedAt: optional.NewString("asc")}, "uuid-08,uuid-09", 2},
+		{"updated since", mangadex.MangaApiGetSearchMangaOpts{UpdatedAtSince: optional.NewString("2024-01-01T08:00:00"), OrderUpdatedAt: optional.NewString("asc")}, "uuid-02,uuid-01,uuid-00", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, _, err := client.MangaApi.GetSearchManga(context.Background(), &tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(ids(list), ","); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if list.Total != tt.total {
+				t.Errorf("total = %d, want %d", list.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestServerOffsetCeiling(t *testing.T) {
+	server := NewServer(testManga(1)...)
+	defer server.Close()
+
+	opts := mangadex.MangaApiGetSearchMangaOpts{Limit: optional.NewInt32(100), Offset: optional.NewInt32(9950)}
+	_, _, err := newTestClient(server).MangaApi.GetSearchManga(context.Background(), &opts)
+	var apiErr *mangadex.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("expected a 400 past the offset ceiling, got %v", err)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	server := NewServer(testManga(2)...)
+	defer server.Close()
+	client := newTestClient(server)
+	client.RateLimiter().SetBackoff(time.Millisecond, time.Millisecond)
+
+	server.FailNext(FaultRateLimited, FaultSoftLimit, FaultServerError)
+	for _, want := range []struct {
+		status      int
+		rateLimited bool
+	}{{429, true}, {200, true}, {503, false}} {
+		_, _, err := client.MangaApi.GetSearchManga(context.Background(), &mangadex.MangaApiGetSearchMangaOpts{})
+		var apiErr *mangadex.APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("expected an APIError, got %v", err)
+		}
+		if apiErr.StatusCode != want.status || errors.Is(err, mangadex.ErrRateLimited) != want.rateLimited {
+			t.Errorf("got status %d rate limited %v, want %d %v", apiErr.StatusCode, errors.Is(err, mangadex.ErrRateLimited), want.status, want.rateLimited)
+		}
+	}
+
+	list, _, err := client.MangaApi.GetSearchManga(context.Background(), &mangadex.MangaApiGetSearchMangaOpts{})
+	if err != nil || len(list.Data) != 2 {
+		t.Fatalf("expected the faults to be used up, got %d manga and %v", len(list.Data), err)
+	}
+	if len(server.Requests()) != 4 {
+		t.Errorf("expected 4 requests to be recorded, got %d", len(server.Requests()))
+	}
+
+	server.Remove("uuid-00")
+	list, _, _ = client.MangaApi.GetSearchManga(context.Background(), &mangadex.MangaApiGetSearchMangaOpts{})
+	if got := strings.Join(ids(list), ","); got != "uuid-01" {
+		t.Errorf("expected the removed manga to be gone, got %s", got)
+	}
+}
+
+func TestParseDump(t *testing.T) {
+	dump := `uuid-1:::||@!@||:::2024-02-03T04:05:06:::||@!@||:::{"id":"uuid-1","title":{"en":"One"},"relatedIds":["uuid-2"],"links":{"al":"1"},"tags":[{"id":"tag-1","name":{"en":"Action"}}]}
+
+uuid-2:::||@!@||:::2024-02-04T00:00:00:::||@!@||:::{"id":"uuid-2","title":{"en":"Two"}}
+`
+	manga, err := ParseDump(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manga) != 2 {
+		t.Fatalf("expected 2 manga, got %d", len(manga))
+	}
+	first := manga[0]
+	if first.Id != "uuid-1" || (*first.Attributes.Title)["en"] != "One" || first.Attributes.Links["al"] != "1" {
+		t.Errorf("unexpected manga %+v", first)
+	}
+	if first.Attributes.CreatedAt != "2024-02-03T04:05:06Z" || first.Attributes.UpdatedAt != first.Attributes.CreatedAt {
+		t.Errorf("unexpected timestamps %s %s", first.Attributes.CreatedAt, first.Attributes.UpdatedAt)
+	}
+	if len(first.Relationships) != 1 || first.Relationships[0].Id != "uuid-2" || first.Relationships[0].Related == "" {
+		t.Errorf("unexpected relationships %+v", first.Relationships)
+	}
+	if len(first.Attributes.Tags) != 1 || (*first.Attributes.Tags[0].Attributes.Name)["en"] != "Action" {
+		t.Errorf("unexpected tags %+v", first.Attributes.Tags)
+	}
+
+	if _, err := ParseDump(strings.NewReader("uuid-1:::||@!@||:::{}\n")); err == nil {
+		t.Error("expected an error for a line missing its date")
+	}
+}
+
+func TestServerExpandsIncludes(t *testing.T) {
+	manga := FromManga(internal.Manga{Id: "uuid-1", CoverFileName: "cover.jpg"}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	server := NewServer(manga)
+	defer server.Close()
+	client := newTestClient(server)
+
+	for _, tt := range []struct {
+		includes []string
+		want     bool
+	}{{nil, false}, {[]string{"cover_art"}, true}} {
+		opts := mangadex.MangaApiGetSearchMangaOpts{}
+		if tt.includes != nil {
+			opts.Includes = optional.NewInterface(tt.includes)
+		}
+		list, _, err := client.MangaApi.GetSearchManga(context.Background(), &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		relationships := list.Data[0].Relationships
+		if len(relationships) != 1 || relationships[0].Type_ != "cover_art" || (relationships[0].Attributes != nil) != tt.want {
+			t.Errorf("includes %v: unexpected relationships %+v", tt.includes, relationships)
+		}
+	}
+}
@@ -20,7 +20,10 @@ type MangaApiGetSearchMangaOpts struct {
 	Offset         optional.Int32
 	Ids            optional.Interface
 	OrderCreatedAt optional.String
+	OrderUpdatedAt optional.String
+	CreatedAtSince optional.String
 	UpdatedAtSince optional.String
+	Includes       optional.Interface
 }
 
 func (a *MangaApiService) GetSearchManga(ctx context.Context, localVarOptionals *MangaApiGetSearchMangaOpts) (MangaList, *http.Response, error) {
@@ -48,6 +51,14 @@ func (a *MangaApiService) GetSearchManga(ctx context.Context, localVarOptionals
 		localVarQueryParams.Add("order[createdAt]", parameterToString(localVarOptionals.OrderCreatedAt.Value(), ""))
 	}
 
+	if localVarOptionals != nil && localVarOptionals.OrderUpdatedAt.IsSet() {
+		localVarQueryParams.Add("order[updatedAt]", parameterToString(localVarOptionals.OrderUpdatedAt.Value(), ""))
+	}
+
+	if localVarOptionals != nil && localVarOptionals.CreatedAtSince.IsSet() {
+		localVarQueryParams.Add("createdAtSince", parameterToString(localVarOptionals.CreatedAtSince.Value(), ""))
+	}
+
 	if localVarOptionals != nil && localVarOptionals.UpdatedAtSince.IsSet() {
 		localVarQueryParams.Add("updatedAtSince", parameterToString(localVarOptionals.UpdatedAtSince.Value(), ""))
 	}
@@ -67,6 +78,12 @@ func (a *MangaApiService) GetSearchManga(ctx context.Context, localVarOptionals
 		//localVarQueryParams.Add("ids[]", parameterToString(localVarOptionals.Ids.Value(), "multi"))
 	}
 
+	if localVarOptionals != nil && localVarOptionals.Includes.IsSet() {
+		for _, include := range localVarOptionals.Includes.Value().([]string) {
+			localVarQueryParams.Add("includes[]", parameterToString(include, ""))
+		}
+	}
+
 	// to determine the Content-Type header
 	localVarHttpContentTypes := []string{}
 
@@ -107,35 +124,13 @@ func (a *MangaApiService) GetSearchManga(ctx context.Context, localVarOptionals
 		if err == nil {
 			return localVarReturnValue, localVarHttpResponse, err
 		}
+		// A 2xx we can't decode is usually the html page served on the soft rate limit
+		return localVarReturnValue, localVarHttpResponse, newAPIError("GetSearchManga", localVarHttpResponse, localVarBody, err.Error(), nil)
 	}
 
-	if localVarHttpResponse.StatusCode >= 300 {
-		newErr := GenericSwaggerError{
-			body:  localVarBody,
-			error: localVarHttpResponse.Status,
-		}
-		if localVarHttpResponse.StatusCode == 200 {
-			var v MangaList
-			err = a.client.decode(&v, localVarBody, localVarHttpResponse.Header.Get("Content-Type"))
-			if err != nil {
-				newErr.error = err.Error()
-				return localVarReturnValue, localVarHttpResponse, newErr
-			}
-			newErr.model = v
-			return localVarReturnValue, localVarHttpResponse, newErr
-		}
-		if localVarHttpResponse.StatusCode == 400 {
-			var v ErrorResponse
-			err = a.client.decode(&v, localVarBody, localVarHttpResponse.Header.Get("Content-Type"))
-			if err != nil {
-				newErr.error = err.Error()
-				return localVarReturnValue, localVarHttpResponse, newErr
-			}
-			newErr.model = v
-			return localVarReturnValue, localVarHttpResponse, newErr
-		}
-		return localVarReturnValue, localVarHttpResponse, newErr
+	var v ErrorResponse
+	if err = a.client.decode(&v, localVarBody, localVarHttpResponse.Header.Get("Content-Type")); err == nil {
+		return localVarReturnValue, localVarHttpResponse, newAPIError("GetSearchManga", localVarHttpResponse, localVarBody, localVarHttpResponse.Status, v)
 	}
-
-	return localVarReturnValue, localVarHttpResponse, nil
+	return localVarReturnValue, localVarHttpResponse, newAPIError("GetSearchManga", localVarHttpResponse, localVarBody, localVarHttpResponse.Status, nil)
 }
@@ -0,0 +1,186 @@
+package mangadex
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitMetrics counts how the limiter has been pacing requests.
+type RateLimitMetrics struct {
+	Requests  int64
+	Throttled int64
+	Backoffs  int64
+	TotalWait time.Duration
+	// Remaining is the last X-RateLimit-Remaining seen, -1 if the header was never sent
+	Remaining int
+}
+
+// RateLimiter spaces requests by a fixed interval and adapts to the rate limit headers sent
+// by MangaDex. When throttled it backs off exponentially with jitter until a request succeeds.
+type RateLimiter struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	next        time.Time
+	failures    int
+	metrics     RateLimitMetrics
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRateLimiter creates a limiter allowing one request per interval.
+func NewRateLimiter(interval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		interval:    interval,
+		baseBackoff: 500 * time.Millisecond,
+		maxBackoff:  time.Minute,
+		metrics:     RateLimitMetrics{Remaining: -1},
+		now:         time.Now,
+		sleep:       sleepContext,
+	}
+}
+
+// SetBackoff changes the first and largest delay used after a throttled request.
+func (l *RateLimiter) SetBackoff(base time.Duration, max time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.baseBackoff = base
+	l.maxBackoff = max
+}
+
+// Wait blocks until the next request is allowed or the context is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.now()
+	wait := l.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	l.next = now.Add(wait + l.interval)
+	l.metrics.Requests++
+	l.metrics.TotalWait += wait
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return ctx.Err()
+	}
+	return l.sleep(ctx, wait)
+}
+
+// Observe updates the limiter from the outcome of a request.
+func (l *RateLimiter) Observe(resp *http.Response, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if resp == nil {
+		if err != nil {
+			l.backoff(0)
+		}
+		return
+	}
+
+	now := l.now()
+	if remaining, convErr := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); convErr == nil {
+		l.metrics.Remaining = remaining
+		if remaining <= 0 {
+			if until, ok := parseRateLimitRetryAfter(resp.Header.Get("X-RateLimit-Retry-After")); ok && until.After(l.next) {
+				l.next = until
+			}
+		}
+	}
+
+	if throttled(resp) {
+		l.metrics.Throttled++
+		var retryAfter time.Duration
+		if until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			retryAfter = until.Sub(now)
+		}
+		if until, ok := parseRateLimitRetryAfter(resp.Header.Get("X-RateLimit-Retry-After")); ok && until.Sub(now) > retryAfter {
+			retryAfter = until.Sub(now)
+		}
+		l.backoff(retryAfter)
+		return
+	}
+	if resp.StatusCode >= 500 {
+		l.backoff(0)
+		return
+	}
+	l.failures = 0
+}
+
+// backoff pushes the next request out by the larger of the exponential delay and the
+// delay the server asked for. Must be called with the lock held.
+func (l *RateLimiter) backoff(atLeast time.Duration) {
+	l.failures++
+	l.metrics.Backoffs++
+	delay := l.baseBackoff << min(l.failures-1, 16)
+	if delay > l.maxBackoff || delay <= 0 {
+		delay = l.maxBackoff
+	}
+	if delay > 0 {
+		delay += time.Duration(rand.Int64N(int64(delay)/2 + 1))
+	}
+	if atLeast > delay {
+		delay = atLeast
+	}
+	if until := l.now().Add(delay); until.After(l.next) {
+		l.next = until
+	}
+}
+
+// Metrics returns a copy of the current counters.
+func (l *RateLimiter) Metrics() RateLimitMetrics {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.metrics
+}
+
+// throttled reports a 429, or the html page served with a 200 when the soft limit is hit.
+func throttled(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return resp.StatusCode < 300 && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html")
+}
+
+// parseRateLimitRetryAfter reads X-RateLimit-Retry-After, a unix timestamp in seconds.
+func parseRateLimitRetryAfter(value string) (time.Time, bool) {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// parseRetryAfter reads Retry-After, either a delay in seconds or an http date.
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,170 @@
+package mangadex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock lets tests drive the limiter without sleeping.
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func newTestLimiter(interval time.Duration) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	limiter := NewRateLimiter(interval)
+	limiter.now = func() time.Time { return clock.now }
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		clock.slept = append(clock.slept, d)
+		clock.now = clock.now.Add(d)
+		return nil
+	}
+	return limiter, clock
+}
+
+func get(t *testing.T, url string) *http.Response {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRateLimiterInterval(t *testing.T) {
+	limiter, clock := newTestLimiter(time.Second)
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(clock.slept) != 2 || clock.slept[0] != time.Second || clock.slept[1] != time.Second {
+		t.Errorf("expected two one second waits, got %v", clock.slept)
+	}
+	if metrics := limiter.Metrics(); metrics.Requests != 3 || metrics.TotalWait != 2*time.Second {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	limiter, clock := newTestLimiter(0)
+	limiter.Observe(get(t, server.URL), nil)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.slept) != 1 || clock.slept[0] < 7*time.Second {
+		t.Errorf("expected to wait at least the Retry-After delay, got %v", clock.slept)
+	}
+	if metrics := limiter.Metrics(); metrics.Throttled != 1 || metrics.Backoffs != 1 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestRateLimiterRemainingHeaders(t *testing.T) {
+	limiter, clock := newTestLimiter(0)
+	retryAt := clock.now.Add(5 * time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Retry-After", strconv.FormatInt(retryAt.Unix(), 10))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	limiter.Observe(get(t, server.URL), nil)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.slept) != 1 || clock.slept[0] != 5*time.Second {
+		t.Errorf("expected to wait until the window resets, got %v", clock.slept)
+	}
+	if metrics := limiter.Metrics(); metrics.Remaining != 0 || metrics.Throttled != 0 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestRateLimiterExponentialBackoff(t *testing.T) {
+	softLimit := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if softLimit {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<html></html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	limiter, clock := newTestLimiter(0)
+	limiter.SetBackoff(time.Second, time.Minute)
+	for i := 0; i < 4; i++ {
+		limiter.Observe(get(t, server.URL), nil)
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, slept := range clock.slept {
+		base := time.Second << i
+		if slept < base || slept > base+base/2 {
+			t.Errorf("backoff %d: expected between %s and %s, got %s", i, base, base+base/2, slept)
+		}
+	}
+
+	softLimit = false
+	limiter.Observe(get(t, server.URL), nil)
+	clock.slept = nil
+	softLimit = true
+	limiter.Observe(get(t, server.URL), nil)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.slept) != 1 || clock.slept[0] > time.Second+time.Second/2 {
+		t.Errorf("expected backoff to reset after a success, got %v", clock.slept)
+	}
+}
+
+func TestClientHonoursRateLimit(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":"ok","data":[{"id":"uuid-1"}]}`))
+	}))
+	defer server.Close()
+
+	cfg := NewConfiguration()
+	cfg.BasePath = server.URL
+	client := NewAPIClient(cfg)
+	client.RateLimiter().SetBackoff(time.Millisecond, 10*time.Millisecond)
+
+	if _, _, err := client.MangaApi.GetSearchManga(context.Background(), nil); err == nil {
+		t.Fatal("expected the first request to be rate limited")
+	}
+	list, _, err := client.MangaApi.GetSearchManga(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Data) != 1 || list.Data[0].Id != "uuid-1" {
+		t.Errorf("unexpected response %+v", list)
+	}
+	if metrics := client.RateLimiter().Metrics(); metrics.Requests != 2 || metrics.Throttled != 1 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}